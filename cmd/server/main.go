@@ -3,19 +3,24 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/chatapp/internal/broker"
 	"github.com/chatapp/internal/config"
 	"github.com/chatapp/internal/handlers"
 	"github.com/chatapp/internal/repository"
 	"github.com/chatapp/internal/service"
 	"github.com/chatapp/internal/websocket"
 	"github.com/chatapp/pkg/jwt"
+	"github.com/chatapp/pkg/oidc"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 	_ "github.com/joho/godotenv/autoload"
@@ -34,19 +39,112 @@ func main() {
 
 	messageRepo := repository.NewMessageRepository(db, &logger.Logger)
 	statusRepo := repository.NewStatusRepository(db, &logger.Logger)
+	if cfg.StatusCacheEnabled {
+		statusCache := repository.NewStatusCache(statusRepo, cfg.StatusCacheTTL, cfg.StatusCacheMaxEntries)
+		expvar.Publish("status_cache", expvar.Func(func() interface{} { return statusCache.Stats() }))
+		statusRepo = statusCache
+	}
+	sessionRepo := repository.NewSessionRepository(db, &logger.Logger)
+	statusEventRepo := repository.NewStatusEventRepository(db, &logger.Logger)
+	userRepo := repository.NewUserRepository(db, &logger.Logger)
+	tokenRepo := repository.NewTokenRepository(db, &logger.Logger)
+	identityRepo := repository.NewIdentityRepository(db, &logger.Logger)
+	apiKeyRepo := repository.NewAPIKeyRepository(db, &logger.Logger)
+	authSessionRepo := repository.NewAuthSessionRepository(db, &logger.Logger)
+	contactRepo := repository.NewContactRepository(db, &logger.Logger)
+	blockRepo := repository.NewBlockRepository(db, &logger.Logger)
+	conversationRepo := repository.NewConversationRepository(db, &logger.Logger)
+	profileRepo := repository.NewProfileRepository(db, &logger.Logger)
 
-	jwtService := jwt.NewJWTService(cfg.JWTSecret)
-	authService := service.NewAuthService(jwtService)
-	messageService := service.NewMessageService(messageRepo)
-	statusService := service.NewStatusService(statusRepo)
+	jwtService, err := setupJWT(cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to set up JWT signing")
+	}
+	twoFactorService := service.NewTwoFactorService(userRepo, cfg.TOTPIssuer)
+	apiKeyService := service.NewAPIKeyService(userRepo, apiKeyRepo)
+	authService := service.NewAuthService(jwtService, userRepo, tokenRepo, authSessionRepo, identityRepo, twoFactorService, apiKeyService, service.AuthConfig{
+		MaxLoginAttempts: cfg.MaxLoginAttempts,
+		LoginLockout:     cfg.LoginLockout,
+		RefreshTokenTTL:  cfg.RefreshTokenTTL,
+		WSTicketTTL:      cfg.WSTicketTTL,
+		MFAChallengeTTL:  cfg.MFAChallengeTTL,
+	})
+	blockService := service.NewBlockService(blockRepo, userRepo)
+	conversationService := service.NewConversationService(conversationRepo)
+	profileService := service.NewProfileService(profileRepo, blockService)
+	contactService := service.NewContactService(contactRepo, blockService, userRepo)
+	messageService := service.NewMessageService(messageRepo, userRepo, contactService, blockService)
+	// Conversation partners kept counting as contacts for presence privacy
+	// when accepted contacts were introduced.
+	privacyContacts := service.NewAnyContacts(contactService, service.NewConversationContacts(messageRepo))
+	statusService := service.NewStatusService(statusRepo, statusEventRepo, privacyContacts, blockService)
+	presenceService := service.NewPresenceService(sessionRepo, statusService, cfg.NodeID, cfg.PresenceTTL)
+	activityService := service.NewActivityService(statusEventRepo, statusService, cfg.StatusEventsRetention)
+	roleService := service.NewRoleService(userRepo)
+	if err := roleService.BootstrapAdmins(context.Background(), cfg.AdminUserIDs); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to grant bootstrap admin roles")
+	}
+
+	oidcService, err := setupOIDC(cfg, identityRepo, userRepo, authService)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to set up OIDC provider")
+	}
 
-	hub := websocket.NewHub(messageService, statusService, &logger.Logger)
+	messageBroker, err := setupBroker(cfg, &logger.Logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to connect to message broker")
+	}
+	defer messageBroker.Close()
+
+	hub, err := websocket.NewHub(authService, messageService, statusService, presenceService, conversationService, messageBroker, websocket.HubConfig{
+		Shards:            cfg.HubShards,
+		HeartbeatInterval: cfg.HeartbeatInterval,
+		PresenceTTL:       cfg.PresenceTTL,
+		AwayAfter:         cfg.AwayAfter,
+		Persister: websocket.PersisterConfig{
+			Workers:       cfg.PersistWorkers,
+			BatchSize:     cfg.PersistBatchSize,
+			FlushInterval: cfg.PersistFlushInterval,
+			MaxRetries:    cfg.PersistMaxRetries,
+			RetryBackoff:  cfg.PersistRetryBackoff,
+		},
+	}, &logger.Logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create hub")
+	}
 	go hub.Run()
 
 	router := mux.NewRouter()
 	router.Use(handlers.LoggingMiddleware(&logger.Logger))
 
-	handlers.SetupRoutes(router, hub, authService, messageService, statusService, &logger.Logger)
+	handlers.SetupRoutes(router, handlers.Services{
+		Hub:           hub,
+		Auth:          authService,
+		JWT:           jwtService,
+		Message:       messageService,
+		Status:        statusService,
+		Activity:      activityService,
+		OIDC:          oidcService,
+		TwoFactor:     twoFactorService,
+		APIKeys:       apiKeyService,
+		Roles:         roleService,
+		Contacts:      contactService,
+		Blocks:        blockService,
+		Conversations: conversationService,
+		Profiles:      profileService,
+	}, handlers.RouteConfig{
+		Cookies: handlers.CookieConfig{
+			Enabled:         cfg.CookieAuth,
+			Secure:          cfg.CookieSecure,
+			AllowedOrigins:  cfg.AllowedOrigins,
+			RefreshTokenTTL: cfg.RefreshTokenTTL,
+		},
+		PostLoginRedirect: cfg.OIDCPostLoginRedirect,
+	}, &logger.Logger)
+
+	retentionCtx, stopRetention := context.WithCancel(context.Background())
+	defer stopRetention()
+	go runRetention(retentionCtx, activityService, authService, oidcService, &logger.Logger)
 
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -63,7 +161,7 @@ func main() {
 		}
 	}()
 
-	gracefulShutdown(server, hub, statusService, &logger.Logger)
+	gracefulShutdown(server, hub, &logger.Logger)
 }
 
 func setupDatabase(cfg *config.Config) (*sql.DB, error) {
@@ -86,17 +184,119 @@ func setupDatabase(cfg *config.Config) (*sql.DB, error) {
 	return db, nil
 }
 
-func gracefulShutdown(server *http.Server, hub *websocket.Hub, statusService service.StatusService, logger *zerolog.Logger) {
+func setupBroker(cfg *config.Config, logger *zerolog.Logger) (broker.Broker, error) {
+	switch cfg.Broker {
+	case "memory":
+		return broker.NewMemoryBroker(), nil
+	case "redis":
+		return broker.NewRedisBroker(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisPrefix, logger)
+	default:
+		return nil, fmt.Errorf("unknown broker %q", cfg.Broker)
+	}
+}
+
+// setupJWT signs with the keys in JWT_KEYS_DIR when set, and with JWT_SECRET
+// otherwise. There is no default secret: anyone could forge tokens with it,
+// so the server refuses to start without one of the two. External JWKS are
+// trusted in both modes.
+func setupJWT(cfg *config.Config) (jwt.Service, error) {
+	jwtCfg := jwt.Config{TTL: cfg.AccessTokenTTL}
+
+	if cfg.JWTKeysDir != "" {
+		keys, err := jwt.LoadKeyDir(cfg.JWTKeysDir)
+		if err != nil {
+			return nil, err
+		}
+		jwtCfg.Keys = keys
+		jwtCfg.ActiveKeyID = cfg.JWTActiveKeyID
+	} else {
+		if cfg.JWTSecret == "" {
+			return nil, errors.New("JWT_SECRET or JWT_KEYS_DIR must be set")
+		}
+		jwtCfg.Secret = cfg.JWTSecret
+	}
+
+	// Each external source is given as issuer|audience|location.
+	for _, entry := range cfg.JWTExternalJWKS {
+		parts := strings.SplitN(entry, "|", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("external JWKS %q is not issuer|audience|location", entry)
+		}
+		source, err := jwt.NewJWKSSource(parts[2], parts[0], parts[1], cfg.JWKSRefreshInterval)
+		if err != nil {
+			return nil, fmt.Errorf("loading JWKS %s: %w", parts[2], err)
+		}
+		jwtCfg.External = append(jwtCfg.External, source)
+	}
+
+	return jwt.NewJWTService(jwtCfg)
+}
+
+// setupOIDC discovers the identity provider. OIDC login is disabled when no
+// issuer is configured.
+func setupOIDC(cfg *config.Config, identities repository.IdentityRepository, users repository.UserRepository, authService service.AuthService) (service.OIDCService, error) {
+	if cfg.OIDCIssuer == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	provider, err := oidc.Discover(ctx, oidc.Config{
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  cfg.OIDCRedirectURL,
+		Scopes:       cfg.OIDCScopes,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.NewOIDCService(provider, identities, users, authService), nil
+}
+
+// runRetention periodically purges presence history older than the
+// configured retention, and tokens and login states that have expired.
+func runRetention(ctx context.Context, activityService service.ActivityService, authService service.AuthService, oidcService service.OIDCService, logger *zerolog.Logger) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purged, err := activityService.PurgeExpiredEvents(ctx)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to purge status events")
+		} else if purged > 0 {
+			logger.Info().Int64("purged", purged).Msg("Purged expired status events")
+		}
+
+		purged, err = authService.PurgeExpiredTokens(ctx)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to purge expired tokens")
+		} else if purged > 0 {
+			logger.Info().Int64("purged", purged).Msg("Purged expired tokens")
+		}
+
+		if oidcService != nil {
+			if _, err := oidcService.PurgeExpiredStates(ctx); err != nil {
+				logger.Error().Err(err).Msg("Failed to purge OIDC login states")
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func gracefulShutdown(server *http.Server, hub *websocket.Hub, logger *zerolog.Logger) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
 	logger.Info().Msg("Shutting down server...")
 
-	if err := statusService.UpdateAllUsersOffline(context.Background()); err != nil {
-		logger.Error().Err(err).Msg("Failed to update all users to offline")
-	}
-
 	hub.Shutdown()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
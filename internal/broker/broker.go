@@ -0,0 +1,14 @@
+package broker
+
+import "context"
+
+// Handler receives the raw payload of a message published on a topic.
+type Handler func(payload []byte)
+
+// Broker carries hub events between backend replicas. Every subscriber of a
+// topic, on every node, receives each payload published to it.
+type Broker interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Subscribe(topic string, handler Handler) error
+	Close() error
+}
@@ -0,0 +1,155 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rs/zerolog"
+)
+
+// collector records the payloads a handler receives.
+type collector chan string
+
+func (c collector) handle(payload []byte) {
+	c <- string(payload)
+}
+
+func (c collector) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-c:
+		if got != want {
+			t.Fatalf("received %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("did not receive %q", want)
+	}
+}
+
+func (c collector) expectNothing(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-c:
+		t.Fatalf("received unexpected %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryBrokerDeliversToTopicSubscribers(t *testing.T) {
+	b := NewMemoryBroker()
+	first, second, other := make(collector, 1), make(collector, 1), make(collector, 1)
+	for topic, c := range map[string]collector{"deliver": first, "presence": other} {
+		if err := b.Subscribe(topic, c.handle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Subscribe("deliver", second.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Publish(context.Background(), "deliver", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, "hello")
+	second.expect(t, "hello")
+	other.expectNothing(t)
+}
+
+func newTestRedisBroker(t *testing.T, server *miniredis.Miniredis, prefix string) Broker {
+	t.Helper()
+	logger := zerolog.Nop()
+	b, err := NewRedisBroker(server.Addr(), "", prefix, &logger)
+	if err != nil {
+		t.Fatalf("NewRedisBroker: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// waitSubscribed waits until Redis has registered n subscribers to the
+// channel, since SUBSCRIBE is acknowledged asynchronously.
+func waitSubscribed(t *testing.T, server *miniredis.Miniredis, channel string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for server.PubSubNumSub(channel)[channel] < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s has %d subscribers, want %d", channel, server.PubSubNumSub(channel)[channel], n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRedisBrokerFansOutAcrossReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	nodeA := newTestRedisBroker(t, server, "chat:")
+	nodeB := newTestRedisBroker(t, server, "chat:")
+
+	onA, onB := make(collector, 1), make(collector, 1)
+	if err := nodeA.Subscribe("deliver", onA.handle); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeB.Subscribe("deliver", onB.handle); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, server, "chat:deliver", 2)
+
+	if err := nodeA.Publish(context.Background(), "deliver", []byte(`{"user_id":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	onA.expect(t, `{"user_id":2}`)
+	onB.expect(t, `{"user_id":2}`)
+}
+
+func TestRedisBrokerSeparatesPrefixes(t *testing.T) {
+	server := miniredis.RunT(t)
+	production := newTestRedisBroker(t, server, "prod:")
+	staging := newTestRedisBroker(t, server, "staging:")
+
+	received := make(collector, 1)
+	if err := staging.Subscribe("presence", received.handle); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, server, "staging:presence", 1)
+
+	if err := production.Publish(context.Background(), "presence", []byte("prod")); err != nil {
+		t.Fatal(err)
+	}
+	received.expectNothing(t)
+	if err := staging.Publish(context.Background(), "presence", []byte("staging")); err != nil {
+		t.Fatal(err)
+	}
+	received.expect(t, "staging")
+}
+
+func TestRedisBrokerSharesSubscriptionPerTopic(t *testing.T) {
+	server := miniredis.RunT(t)
+	b := newTestRedisBroker(t, server, "chat:")
+
+	first, second := make(collector, 1), make(collector, 1)
+	if err := b.Subscribe("revoke", first.handle); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Subscribe("revoke", second.handle); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, server, "chat:revoke", 1)
+
+	if err := b.Publish(context.Background(), "revoke", []byte("jti")); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, "jti")
+	second.expect(t, "jti")
+}
+
+func TestNewRedisBrokerFailsWithoutServer(t *testing.T) {
+	server := miniredis.RunT(t)
+	addr := server.Addr()
+	server.Close()
+
+	logger := zerolog.Nop()
+	if _, err := NewRedisBroker(addr, "", "chat:", &logger); err == nil {
+		t.Fatal("NewRedisBroker succeeded without a server")
+	}
+}
@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+type memoryBroker struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+// NewMemoryBroker returns a broker that only delivers within the current
+// process. It is the default for single-replica deployments.
+func NewMemoryBroker() Broker {
+	return &memoryBroker{handlers: make(map[string][]Handler)}
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mu.RLock()
+	handlers := b.handlers[topic]
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(payload)
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(topic string, handler Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[topic] = append(b.handlers[topic], handler)
+	return nil
+}
+
+func (b *memoryBroker) Close() error {
+	return nil
+}
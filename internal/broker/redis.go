@@ -0,0 +1,93 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+type redisBroker struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	prefix string
+	logger *zerolog.Logger
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	done     chan struct{}
+}
+
+// NewRedisBroker returns a broker backed by Redis pub/sub. Topics are
+// namespaced with prefix so several deployments can share one Redis.
+func NewRedisBroker(addr, password, prefix string, logger *zerolog.Logger) (Broker, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+	})
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis ping failed: %w", err)
+	}
+
+	b := &redisBroker{
+		client:   client,
+		pubsub:   client.Subscribe(ctx),
+		prefix:   prefix,
+		logger:   logger,
+		handlers: make(map[string][]Handler),
+		done:     make(chan struct{}),
+	}
+	go b.receive()
+	return b, nil
+}
+
+func (b *redisBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	if err := b.client.Publish(ctx, b.prefix+topic, payload).Err(); err != nil {
+		b.logger.Error().Err(err).Str("topic", topic).Msg("Failed to publish to redis")
+		return err
+	}
+	return nil
+}
+
+func (b *redisBroker) Subscribe(topic string, handler Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	channel := b.prefix + topic
+	if _, ok := b.handlers[channel]; !ok {
+		if err := b.pubsub.Subscribe(context.Background(), channel); err != nil {
+			b.logger.Error().Err(err).Str("topic", topic).Msg("Failed to subscribe to redis channel")
+			return err
+		}
+	}
+	b.handlers[channel] = append(b.handlers[channel], handler)
+	return nil
+}
+
+func (b *redisBroker) Close() error {
+	err := b.pubsub.Close()
+	<-b.done
+	if closeErr := b.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (b *redisBroker) receive() {
+	defer close(b.done)
+
+	for msg := range b.pubsub.Channel() {
+		b.mu.RLock()
+		handlers := b.handlers[msg.Channel]
+		b.mu.RUnlock()
+
+		for _, handler := range handlers {
+			handler([]byte(msg.Payload))
+		}
+	}
+}
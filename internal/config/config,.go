@@ -2,6 +2,10 @@ package config
 
 import (
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -14,6 +18,54 @@ type Config struct {
 	DBName     string
 	JWTSecret  string
 	LogLevel   string
+
+	JWTKeysDir          string
+	JWTActiveKeyID      string
+	JWTExternalJWKS     []string
+	JWKSRefreshInterval time.Duration
+
+	OIDCIssuer            string
+	OIDCClientID          string
+	OIDCClientSecret      string
+	OIDCRedirectURL       string
+	OIDCScopes            []string
+	OIDCPostLoginRedirect string
+
+	MaxLoginAttempts int
+	LoginLockout     time.Duration
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+	WSTicketTTL      time.Duration
+	MFAChallengeTTL  time.Duration
+	TOTPIssuer       string
+	AdminUserIDs     []int
+
+	CookieAuth     bool
+	CookieSecure   bool
+	AllowedOrigins []string
+
+	Broker        string
+	RedisAddr     string
+	RedisPassword string
+	RedisPrefix   string
+
+	NodeID            string
+	HeartbeatInterval time.Duration
+	PresenceTTL       time.Duration
+	AwayAfter         time.Duration
+
+	StatusEventsRetention time.Duration
+
+	StatusCacheEnabled    bool
+	StatusCacheTTL        time.Duration
+	StatusCacheMaxEntries int
+
+	HubShards            int
+	PersistWorkers       int
+	PersistBatchSize     int
+	PersistFlushInterval time.Duration
+	PersistMaxRetries    int
+	PersistRetryBackoff  time.Duration
 }
 
 func LoadConfig() *Config {
@@ -23,8 +75,56 @@ func LoadConfig() *Config {
 		DBPassword: getEnv("DB_PASSWORD", ""),
 		DBHost:     getEnv("DB_HOST", "localhost"),
 		DBName:     getEnv("DB_NAME", "chat_db"),
-		JWTSecret:  getEnv("JWT_SECRET", "default-secret-key"),
+		JWTSecret:  getEnv("JWT_SECRET", ""),
 		LogLevel:   getEnv("LOG_LEVEL", "info"),
+
+		JWTKeysDir:          getEnv("JWT_KEYS_DIR", ""),
+		JWTActiveKeyID:      getEnv("JWT_ACTIVE_KID", ""),
+		JWTExternalJWKS:     getEnvList("JWT_EXTERNAL_JWKS", nil),
+		JWKSRefreshInterval: getEnvDuration("JWKS_REFRESH_INTERVAL", time.Hour),
+
+		OIDCIssuer:            getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:          getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:      getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:       getEnv("OIDC_REDIRECT_URL", ""),
+		OIDCScopes:            getEnvList("OIDC_SCOPES", []string{"openid", "profile", "email"}),
+		OIDCPostLoginRedirect: getEnv("OIDC_POST_LOGIN_REDIRECT", ""),
+
+		MaxLoginAttempts: getEnvInt("MAX_LOGIN_ATTEMPTS", 5),
+		LoginLockout:     getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		AccessTokenTTL:   getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:  getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		WSTicketTTL:      getEnvDuration("WS_TICKET_TTL", 30*time.Second),
+		MFAChallengeTTL:  getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+		TOTPIssuer:       getEnv("TOTP_ISSUER", "ChatApp"),
+		AdminUserIDs:     getEnvIntList("ADMIN_USER_IDS"),
+
+		CookieAuth:     getEnvBool("COOKIE_AUTH", false),
+		CookieSecure:   getEnvBool("COOKIE_SECURE", true),
+		AllowedOrigins: getEnvList("ALLOWED_ORIGINS", nil),
+
+		Broker:        getEnv("BROKER", "memory"),
+		RedisAddr:     getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPassword: getEnv("REDIS_PASSWORD", ""),
+		RedisPrefix:   getEnv("REDIS_PREFIX", "chatapp:"),
+
+		NodeID:            getEnv("NODE_ID", defaultNodeID()),
+		HeartbeatInterval: getEnvDuration("PRESENCE_HEARTBEAT_INTERVAL", 15*time.Second),
+		PresenceTTL:       getEnvDuration("PRESENCE_TTL", 60*time.Second),
+		AwayAfter:         getEnvDuration("AWAY_AFTER", 5*time.Minute),
+
+		StatusEventsRetention: getEnvDuration("STATUS_EVENTS_RETENTION", 90*24*time.Hour),
+
+		StatusCacheEnabled:    getEnvBool("STATUS_CACHE_ENABLED", true),
+		StatusCacheTTL:        getEnvDuration("STATUS_CACHE_TTL", 30*time.Second),
+		StatusCacheMaxEntries: getEnvInt("STATUS_CACHE_MAX_ENTRIES", 100000),
+
+		HubShards:            getEnvInt("HUB_SHARDS", runtime.NumCPU()),
+		PersistWorkers:       getEnvInt("PERSIST_WORKERS", 4),
+		PersistBatchSize:     getEnvInt("PERSIST_BATCH_SIZE", 100),
+		PersistFlushInterval: getEnvDuration("PERSIST_FLUSH_INTERVAL", 20*time.Millisecond),
+		PersistMaxRetries:    getEnvInt("PERSIST_MAX_RETRIES", 3),
+		PersistRetryBackoff:  getEnvDuration("PERSIST_RETRY_BACKOFF", 50*time.Millisecond),
 	}
 }
 
@@ -35,6 +135,43 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// defaultNodeID identifies this process among the backend replicas. The pid
+// keeps restarted containers with a reused hostname apart.
+func defaultNodeID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "node"
+	}
+	return hostname + "-" + strconv.Itoa(os.Getpid())
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
 type Logger struct {
 	zerolog.Logger
 }
@@ -54,3 +191,31 @@ func SetupLogger(cfg *Config) *Logger {
 
 	return &Logger{logger}
 }
+
+// getEnvList splits a comma separated value, dropping empty entries.
+func getEnvList(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvIntList parses a comma separated list of integers, skipping invalid
+// entries.
+func getEnvIntList(key string) []int {
+	var list []int
+	for _, item := range getEnvList(key, nil) {
+		if value, err := strconv.Atoi(item); err == nil {
+			list = append(list, value)
+		}
+	}
+	return list
+}
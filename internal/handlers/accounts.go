@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/service"
+	"github.com/chatapp/internal/websocket"
+	"github.com/rs/zerolog"
+)
+
+type credentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// DeviceName labels the session in the session list.
+	DeviceName string `json:"device_name,omitempty"`
+}
+
+func HandleRegister(authService service.AuthService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req credentialsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid register body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		user, err := authService.Register(r.Context(), req.Username, req.Password)
+		if errors.Is(err, service.ErrUsernameTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Warn().Err(err).Str("username", req.Username).Msg("Failed to register user")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(user); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode register response")
+		}
+	}
+}
+
+func HandleLogin(authService service.AuthService, cookies CookieConfig, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req credentialsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid login body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := authService.Login(r.Context(), req.Username, req.Password, deviceInfo(r, req.DeviceName))
+		switch {
+		case errors.Is(err, service.ErrInvalidCredentials):
+			logger.Warn().Str("username", req.Username).Msg("Failed login attempt")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errors.Is(err, service.ErrAccountLocked):
+			logger.Warn().Str("username", req.Username).Msg("Login attempt on locked account")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			logger.Error().Err(err).Msg("Failed to log in")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeTokens(w, cookies, tokens, logger)
+	}
+}
+
+// writeTokens returns the tokens in the body and, with cookie auth enabled,
+// also as cookies.
+func writeTokens(w http.ResponseWriter, cookies CookieConfig, tokens *models.AuthTokens, logger *zerolog.Logger) {
+	if cookies.Enabled {
+		if err := setAuthCookies(w, cookies, tokens); err != nil {
+			logger.Error().Err(err).Msg("Failed to set auth cookies")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode token response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func HandleRefresh(authService service.AuthService, cookies CookieConfig, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refreshToken, ok := readRefreshToken(w, r, cookies, logger)
+		if !ok {
+			return
+		}
+
+		tokens, err := authService.Refresh(r.Context(), refreshToken, deviceInfo(r, ""))
+		if errors.Is(err, service.ErrInvalidRefresh) {
+			logger.Warn().Msg("Rejected refresh token")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to refresh tokens")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeTokens(w, cookies, tokens, logger)
+	}
+}
+
+// readRefreshToken takes the refresh token from the body or, with cookie auth
+// enabled, from its cookie after checking the CSRF header.
+func readRefreshToken(w http.ResponseWriter, r *http.Request, cookies CookieConfig, logger *zerolog.Logger) (string, bool) {
+	var req refreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid refresh token body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return "", false
+		}
+	}
+	if req.RefreshToken != "" || !cookies.Enabled {
+		return req.RefreshToken, true
+	}
+
+	refreshToken := cookieValue(r, refreshTokenCookie)
+	if refreshToken != "" && !checkCSRF(r, cookies) {
+		logger.Warn().Str("path", r.URL.Path).Msg("CSRF check failed")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return refreshToken, true
+}
+
+// HandleLogout revokes the refresh token and the access token, taken from the
+// body and Authorization header or from the auth cookies, and closes the
+// WebSockets opened in that session.
+func HandleLogout(authService service.AuthService, hub *websocket.Hub, cookies CookieConfig, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refreshToken, ok := readRefreshToken(w, r, cookies, logger)
+		if !ok {
+			return
+		}
+
+		accessToken := extractToken(r)
+		if accessToken == "" && cookies.Enabled && checkCSRF(r, cookies) {
+			accessToken = cookieValue(r, accessTokenCookie)
+		}
+		token, _ := authService.ValidateToken(r.Context(), accessToken)
+
+		err := authService.Logout(r.Context(), accessToken, refreshToken)
+		if errors.Is(err, service.ErrInvalidRefresh) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			logger.Warn().Err(err).Msg("Failed to log out")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if token != nil {
+			hub.RevokeToken(token.ID)
+			hub.RevokeSession(token.SessionID)
+		}
+		if cookies.Enabled {
+			clearAuthCookies(w, cookies)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chatapp/internal/service"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+func HandleOnlineSessions(activityService service.ActivityService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		viewerID := ctx.Value(userIDKey).(int)
+
+		userID, from, to, _, ok := parseActivityRequest(w, r, logger)
+		if !ok {
+			return
+		}
+
+		sessions, err := activityService.GetOnlineSessions(ctx, viewerID, userID, from, to)
+		if err != nil {
+			writeActivityError(w, err, logger)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(sessions); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode sessions response")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+func HandleDailyActivity(activityService service.ActivityService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		viewerID := ctx.Value(userIDKey).(int)
+
+		userID, from, to, loc, ok := parseActivityRequest(w, r, logger)
+		if !ok {
+			return
+		}
+
+		days, err := activityService.GetDailyActiveMinutes(ctx, viewerID, userID, from, to, loc)
+		if err != nil {
+			writeActivityError(w, err, logger)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(days); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode activity response")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// parseActivityRequest reads the user ID from the path and the from, to and
+// tz query parameters. The range defaults to the last seven days.
+func parseActivityRequest(w http.ResponseWriter, r *http.Request, logger *zerolog.Logger) (int, time.Time, time.Time, *time.Location, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, time.Time{}, time.Time{}, nil, false
+	}
+
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			logger.Warn().Err(err).Str("tz", tz).Msg("Invalid tz parameter")
+			http.Error(w, "Invalid tz parameter", http.StatusBadRequest)
+			return 0, time.Time{}, time.Time{}, nil, false
+		}
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -7)
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = parseTimeParam(value, loc); err != nil {
+			http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+			return 0, time.Time{}, time.Time{}, nil, false
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = parseTimeParam(value, loc); err != nil {
+			http.Error(w, "Invalid to parameter", http.StatusBadRequest)
+			return 0, time.Time{}, time.Time{}, nil, false
+		}
+	}
+
+	return userID, from, to, loc, true
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or plain dates, which are taken
+// as midnight in loc.
+func parseTimeParam(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
+
+func writeActivityError(w http.ResponseWriter, err error, logger *zerolog.Logger) {
+	if errors.Is(err, service.ErrActivityHidden) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	logger.Warn().Err(err).Msg("Failed to load activity")
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -4,29 +4,118 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/chatapp/internal/policy"
 	"github.com/chatapp/internal/service"
 	"github.com/rs/zerolog"
 )
 
-func AuthMiddleware(authService service.AuthService, logger *zerolog.Logger) func(http.Handler) http.Handler {
+// AuthMiddleware accepts a bearer token or, when cookie auth is enabled, the
+// access_token cookie subject to the CSRF checks described on CookieConfig.
+func AuthMiddleware(authService service.AuthService, cookies CookieConfig, logger *zerolog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			tokenString := extractToken(r)
+			if tokenString == "" && cookies.Enabled {
+				tokenString = cookieValue(r, accessTokenCookie)
+				if tokenString != "" && !checkCSRF(r, cookies) {
+					logger.Warn().Str("path", r.URL.Path).Msg("CSRF check failed")
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
 			if tokenString == "" {
 				logger.Warn().Msg("Missing authorization token")
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			userID, err := authService.ValidateToken(tokenString)
+			token, err := authService.ValidateToken(r.Context(), tokenString)
 			if err != nil {
 				logger.Warn().Err(err).Msg("Invalid authorization token")
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), userIDKey, userID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(withToken(r.Context(), token)))
 		})
 	}
 }
+
+// WebSocketAuthMiddleware lets the handshake authenticate with a one-time
+// ticket in the query string and falls back to AuthMiddleware otherwise.
+func WebSocketAuthMiddleware(authService service.AuthService, cookies CookieConfig, logger *zerolog.Logger) func(http.Handler) http.Handler {
+	authMiddleware := AuthMiddleware(authService, cookies, logger)
+	return func(next http.Handler) http.Handler {
+		fallback := authMiddleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ticket := r.URL.Query().Get("ticket")
+			if ticket == "" {
+				fallback.ServeHTTP(w, r)
+				return
+			}
+
+			token, err := authService.RedeemWSTicket(r.Context(), ticket)
+			if err != nil {
+				logger.Warn().Err(err).Msg("Invalid WebSocket ticket")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(withToken(r.Context(), token)))
+		})
+	}
+}
+
+// RequirePermission only lets through users whose role grants perm. It must
+// run after AuthMiddleware.
+func RequirePermission(perm policy.Permission, logger *zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !policy.Allowed(r.Context(), perm) {
+				userID, _ := r.Context().Value(userIDKey).(int)
+				logger.Warn().Int("user_id", userID).Str("permission", string(perm)).Str("path", r.URL.Path).Msg("Permission denied")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope lets user tokens through and API keys only when they carry
+// scope. It must run after AuthMiddleware.
+func RequireScope(scope string, logger *zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(tokenKey).(*service.TokenInfo)
+			if !ok || !token.HasScope(scope) {
+				logger.Warn().Str("scope", scope).Str("path", r.URL.Path).Msg("API key lacks scope")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireUserToken rejects API keys, for account management that only the
+// user themselves may do. It must run after AuthMiddleware.
+func RequireUserToken(logger *zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(tokenKey).(*service.TokenInfo)
+			if !ok || token.IsAPIKey() {
+				logger.Warn().Str("path", r.URL.Path).Msg("API key used on user-only route")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func withToken(ctx context.Context, token *service.TokenInfo) context.Context {
+	ctx = policy.NewContext(ctx, policy.Subject{UserID: token.UserID, Role: token.Role})
+	ctx = context.WithValue(ctx, userIDKey, token.UserID)
+	return context.WithValue(ctx, tokenKey, token)
+}
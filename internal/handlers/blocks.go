@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chatapp/internal/service"
+	"github.com/chatapp/internal/websocket"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+type blockRequest struct {
+	UserID int `json:"user_id"`
+}
+
+func HandleListBlocks(blockService service.BlockService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		blocked, err := blockService.ListBlocked(ctx, userID)
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to list blocked users")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, blocked, logger)
+	}
+}
+
+// HandleBlockUser blocks a user and hides each user's presence from the
+// other right away. The blocked user is not notified.
+func HandleBlockUser(blockService service.BlockService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		var req blockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid block request body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		err := blockService.Block(ctx, userID, req.UserID)
+		switch {
+		case errors.Is(err, service.ErrUnknownUser):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrSelfBlock):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to block user")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		hub.Block(userID, req.UserID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func HandleUnblockUser(blockService service.BlockService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		blockedID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		err = blockService.Unblock(ctx, userID, blockedID)
+		if errors.Is(err, service.ErrNotBlocked) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to unblock user")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
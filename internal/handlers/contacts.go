@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/service"
+	"github.com/chatapp/internal/websocket"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+type contactRequestBody struct {
+	UserID int `json:"user_id"`
+}
+
+func HandleListContacts(contactService service.ContactService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		contacts, err := contactService.ListContacts(ctx, userID)
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to list contacts")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, contacts, logger)
+	}
+}
+
+func HandleRemoveContact(contactService service.ContactService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		contactID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := contactService.RemoveContact(ctx, userID, contactID); err != nil {
+			writeContactError(w, err, userID, logger)
+			return
+		}
+
+		hub.Notify(contactID, &models.Message{
+			Type:      "contact_removed",
+			SenderID:  userID,
+			Timestamp: time.Now(),
+		})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func HandleListContactRequests(contactService service.ContactService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		requests, err := contactService.ListRequests(ctx, userID)
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to list contact requests")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, requests, logger)
+	}
+}
+
+// HandleSendContactRequest asks another user to become a contact and
+// notifies them in real time.
+func HandleSendContactRequest(contactService service.ContactService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		var body contactRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			logger.Warn().Err(err).Msg("Invalid contact request body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		req, err := contactService.SendRequest(ctx, userID, body.UserID)
+		if err != nil {
+			writeContactError(w, err, userID, logger)
+			return
+		}
+
+		notifyContactRequest(hub, req.ReceiverID, "contact_request", req)
+		writeJSON(w, http.StatusCreated, req, logger)
+	}
+}
+
+// HandleAcceptContactRequest makes the users contacts and refreshes their
+// presence subscriptions, since contacts may see more of each other's status.
+func HandleAcceptContactRequest(contactService service.ContactService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return handleContactRequestAction(contactService.AcceptRequest, func(ctx context.Context, req *models.ContactRequest) {
+		notifyContactRequest(hub, req.SenderID, "contact_request_accepted", req)
+		for _, pair := range [][2]int{{req.SenderID, req.ReceiverID}, {req.ReceiverID, req.SenderID}} {
+			if err := hub.SubscribePresence(ctx, pair[0], []int{pair[1]}); err != nil {
+				logger.Error().Err(err).Int("user_id", pair[0]).Msg("Failed to subscribe to new contact")
+			}
+		}
+	}, logger)
+}
+
+func HandleDeclineContactRequest(contactService service.ContactService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return handleContactRequestAction(contactService.DeclineRequest, func(ctx context.Context, req *models.ContactRequest) {
+		notifyContactRequest(hub, req.SenderID, "contact_request_declined", req)
+	}, logger)
+}
+
+func HandleCancelContactRequest(contactService service.ContactService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return handleContactRequestAction(contactService.CancelRequest, func(ctx context.Context, req *models.ContactRequest) {
+		notifyContactRequest(hub, req.ReceiverID, "contact_request_cancelled", req)
+	}, logger)
+}
+
+// handleContactRequestAction runs action on the request in the path and calls
+// notify with the updated request.
+func handleContactRequestAction(
+	action func(ctx context.Context, userID int, requestID int64) (*models.ContactRequest, error),
+	notify func(ctx context.Context, req *models.ContactRequest),
+	logger *zerolog.Logger,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		requestID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid request ID", http.StatusBadRequest)
+			return
+		}
+
+		req, err := action(ctx, userID, requestID)
+		if err != nil {
+			writeContactError(w, err, userID, logger)
+			return
+		}
+
+		notify(ctx, req)
+		writeJSON(w, http.StatusOK, req, logger)
+	}
+}
+
+func HandleGetContactSettings(contactService service.ContactService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		settings, err := contactService.GetSettings(ctx, userID)
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get contact settings")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, settings, logger)
+	}
+}
+
+func HandleUpdateContactSettings(contactService service.ContactService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		var settings models.ContactSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			logger.Warn().Err(err).Msg("Invalid contact settings body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := contactService.UpdateSettings(ctx, userID, &settings); err != nil {
+			logger.Warn().Err(err).Int("user_id", userID).Msg("Failed to update contact settings")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, &settings, logger)
+	}
+}
+
+func notifyContactRequest(hub *websocket.Hub, userID int, eventType string, req *models.ContactRequest) {
+	hub.Notify(userID, &models.Message{
+		Type:           eventType,
+		ContactRequest: req,
+		Timestamp:      time.Now(),
+	})
+}
+
+func writeContactError(w http.ResponseWriter, err error, userID int, logger *zerolog.Logger) {
+	switch {
+	case errors.Is(err, service.ErrContactRequestNotFound),
+		errors.Is(err, service.ErrNotContact),
+		errors.Is(err, service.ErrUnknownUser):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrContactRequestPending), errors.Is(err, service.ErrAlreadyContacts):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrSelfContact):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrContactNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		logger.Error().Err(err).Int("user_id", userID).Msg("Contact operation failed")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
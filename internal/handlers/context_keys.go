@@ -4,4 +4,5 @@ type contextKey string
 
 const (
 	userIDKey contextKey = "userID"
+	tokenKey  contextKey = "token"
 )
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/service"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+// HandleListConversations lists the user's conversations, most recent first,
+// each with its unread count and the user's settings for it.
+func HandleListConversations(conversationService service.ConversationService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		limit := 50
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			var err error
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit < 1 || limit > 200 {
+				http.Error(w, "Invalid limit parameter (1-200)", http.StatusBadRequest)
+				return
+			}
+		}
+
+		conversations, err := conversationService.ListConversations(ctx, userID, limit)
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to list conversations")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, conversations, logger)
+	}
+}
+
+func HandleGetConversationSettings(conversationService service.ConversationService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		partnerID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		settings, err := conversationService.GetSettings(ctx, userID, partnerID)
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get conversation settings")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, settings, logger)
+	}
+}
+
+func HandleUpdateConversationSettings(conversationService service.ConversationService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		partnerID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		var settings models.ConversationSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			logger.Warn().Err(err).Msg("Invalid conversation settings body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := conversationService.UpdateSettings(ctx, userID, partnerID, &settings); err != nil {
+			logger.Warn().Err(err).Int("user_id", userID).Msg("Failed to update conversation settings")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, &settings, logger)
+	}
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/chatapp/pkg/jwt"
+	"github.com/rs/zerolog"
+)
+
+// HandleJWKS publishes the public keys that verify this server's tokens.
+func HandleJWKS(jwtService jwt.Service, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := json.NewEncoder(w).Encode(jwtService.JWKS()); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode JWKS response")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
@@ -63,3 +63,41 @@ func HandleMessageHistory(messageService service.MessageService, logger *zerolog
 		}
 	}
 }
+
+// HandleMentions lists the messages that mention the caller, newest first.
+// Pass the smallest ID seen as before to get the next page.
+func HandleMentions(messageService service.MessageService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		limit := 50
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			var err error
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit < 1 || limit > 200 {
+				http.Error(w, "Invalid limit parameter (1-200)", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var before int64
+		if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+			var err error
+			before, err = strconv.ParseInt(beforeStr, 10, 64)
+			if err != nil || before < 1 {
+				http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		messages, err := messageService.GetMentions(ctx, userID, before, limit)
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get mentions")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, messages, logger)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/chatapp/internal/service"
+	"github.com/rs/zerolog"
+)
+
+const (
+	oidcBindingCookie = "oidc_binding"
+	oidcCookiePath    = "/api/auth/oidc"
+)
+
+// HandleOIDCLogin sends the browser to the provider. The login is bound to
+// this browser by a cookie the callback must bring back.
+func HandleOIDCLogin(oidcService service.OIDCService, cookies CookieConfig, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		redirectURL, binding, err := oidcService.BeginLogin(r.Context())
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to start OIDC login")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Lax, since the provider sends the browser back with a top-level
+		// cross-site GET.
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcBindingCookie,
+			Value:    binding,
+			Path:     oidcCookiePath,
+			MaxAge:   int(service.OIDCStateTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   cookies.Secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+	}
+}
+
+// HandleOIDCCallback completes the login. With cookie auth and a post-login
+// redirect configured, the browser is sent back to the app with the session
+// cookies set; otherwise the tokens are returned as JSON.
+func HandleOIDCCallback(oidcService service.OIDCService, cookies CookieConfig, postLoginRedirect string, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if providerErr := query.Get("error"); providerErr != "" {
+			logger.Warn().Str("error", providerErr).Str("description", query.Get("error_description")).Msg("OIDC provider returned an error")
+			http.Error(w, "Login failed", http.StatusUnauthorized)
+			return
+		}
+
+		binding := cookieValue(r, oidcBindingCookie)
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcBindingCookie,
+			Path:     oidcCookiePath,
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   cookies.Secure,
+		})
+
+		tokens, err := oidcService.CompleteLogin(r.Context(), query.Get("state"), binding, query.Get("code"), deviceInfo(r, ""))
+		if errors.Is(err, service.ErrInvalidOIDCState) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Warn().Err(err).Msg("Failed to complete OIDC login")
+			http.Error(w, "Login failed", http.StatusUnauthorized)
+			return
+		}
+
+		if cookies.Enabled && postLoginRedirect != "" {
+			if err := setAuthCookies(w, cookies, tokens); err != nil {
+				logger.Error().Err(err).Msg("Failed to set auth cookies")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, postLoginRedirect, http.StatusFound)
+			return
+		}
+
+		writeTokens(w, cookies, tokens, logger)
+	}
+}
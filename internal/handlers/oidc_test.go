@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/service"
+	"github.com/rs/zerolog"
+)
+
+// fakeOIDC hands out one binding and completes logins that bring it back.
+type fakeOIDC struct {
+	service.OIDCService
+}
+
+func (fakeOIDC) BeginLogin(ctx context.Context) (string, string, error) {
+	return "https://idp.example/authorize?state=s", "binding", nil
+}
+
+func (fakeOIDC) CompleteLogin(ctx context.Context, state, binding, code string, device *models.DeviceInfo) (*models.AuthTokens, error) {
+	if binding != "binding" {
+		return nil, service.ErrInvalidOIDCState
+	}
+	return &models.AuthTokens{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+func TestOIDCLoginBindsBrowserWithCookie(t *testing.T) {
+	logger := zerolog.Nop()
+	cookies := CookieConfig{Secure: true}
+
+	rec := httptest.NewRecorder()
+	HandleOIDCLogin(fakeOIDC{}, cookies, &logger)(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status %d, want %d", rec.Code, http.StatusFound)
+	}
+	var binding *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcBindingCookie {
+			binding = cookie
+		}
+	}
+	if binding == nil || binding.Value != "binding" || !binding.HttpOnly || !binding.Secure || binding.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("binding cookie = %+v", binding)
+	}
+
+	callback := HandleOIDCCallback(fakeOIDC{}, cookies, "", &logger)
+	rec = httptest.NewRecorder()
+	callback(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?state=s&code=c", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback without the cookie: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?state=s&code=c", nil)
+	req.AddCookie(&http.Cookie{Name: oidcBindingCookie, Value: binding.Value})
+	rec = httptest.NewRecorder()
+	callback(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback with the cookie: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
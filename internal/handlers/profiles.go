@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/service"
+	"github.com/chatapp/internal/websocket"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+func HandleGetProfile(profileService service.ProfileService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		viewerID := ctx.Value(userIDKey).(int)
+
+		userID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		profile, err := profileService.GetProfile(ctx, viewerID, userID)
+		if errors.Is(err, service.ErrUnknownUser) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get profile")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, profile, logger)
+	}
+}
+
+// HandleUpdateProfile updates the caller's profile and pushes it to the users
+// they have conversations with.
+func HandleUpdateProfile(profileService service.ProfileService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		var update models.Profile
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			logger.Warn().Err(err).Msg("Invalid profile body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		profile, err := profileService.UpdateProfile(ctx, userID, &update)
+		if errors.Is(err, service.ErrUnknownUser) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Warn().Err(err).Int("user_id", userID).Msg("Failed to update profile")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := hub.BroadcastProfile(ctx, profile); err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to broadcast profile update")
+		}
+		writeJSON(w, http.StatusOK, profile, logger)
+	}
+}
+
+func HandleSearchUsers(profileService service.ProfileService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		limit := 0
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			var err error
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		profiles, err := profileService.Search(ctx, userID, r.URL.Query().Get("q"), limit)
+		if errors.Is(err, service.ErrSearchQueryTooShort) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to search users")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, profiles, logger)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/service"
+	"github.com/chatapp/internal/websocket"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+type roleRequest struct {
+	Role policy.Role `json:"role"`
+}
+
+// HandleSetRole lets an administrator change another user's role. The
+// user's open connections switch to the new role at once.
+func HandleSetRole(roleService service.RoleService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		adminID := ctx.Value(userIDKey).(int)
+
+		userID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		var req roleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid role body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		user, err := roleService.SetRole(ctx, userID, req.Role)
+		switch {
+		case errors.Is(err, policy.ErrForbidden):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		case errors.Is(err, service.ErrUnknownUser):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrInvalidRole), errors.Is(err, service.ErrOwnRole), errors.Is(err, service.ErrServiceRoled):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to set role")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		hub.UpdateRole(userID, req.Role)
+		logger.Info().Int("admin_id", adminID).Int("user_id", userID).Str("role", string(req.Role)).Msg("Role changed")
+		writeJSON(w, http.StatusOK, user, logger)
+	}
+}
@@ -2,33 +2,131 @@ package handlers
 
 import (
 	"encoding/json"
+	"expvar"
 	"net/http"
 
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
 	"github.com/chatapp/internal/service"
 	"github.com/chatapp/internal/websocket"
+	"github.com/chatapp/pkg/jwt"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
 )
 
-func SetupRoutes(
-	router *mux.Router,
-	hub *websocket.Hub,
-	authService service.AuthService,
-	messageService service.MessageService,
-	statusService service.StatusService,
-	logger *zerolog.Logger,
-) {
-	authMiddleware := AuthMiddleware(authService, logger)
+// Services are the dependencies the HTTP routes are built from. OIDC is nil
+// when OIDC login is disabled.
+type Services struct {
+	Hub           *websocket.Hub
+	Auth          service.AuthService
+	JWT           jwt.Service
+	Message       service.MessageService
+	Status        service.StatusService
+	Activity      service.ActivityService
+	OIDC          service.OIDCService
+	TwoFactor     service.TwoFactorService
+	APIKeys       service.APIKeyService
+	Roles         service.RoleService
+	Contacts      service.ContactService
+	Blocks        service.BlockService
+	Conversations service.ConversationService
+	Profiles      service.ProfileService
+}
+
+// RouteConfig holds the route settings that are not services.
+type RouteConfig struct {
+	Cookies           CookieConfig
+	PostLoginRedirect string
+}
+
+func SetupRoutes(router *mux.Router, svc Services, cfg RouteConfig, logger *zerolog.Logger) {
+	hub := svc.Hub
+	cookies := cfg.Cookies
+	authMiddleware := AuthMiddleware(svc.Auth, cookies, logger)
+	userOnly := RequireUserToken(logger)
+	scoped := func(scope string, handler http.HandlerFunc) http.Handler {
+		return RequireScope(scope, logger)(handler)
+	}
+	permitted := func(perm policy.Permission, handler http.Handler) http.Handler {
+		return RequirePermission(perm, logger)(handler)
+	}
 
-	router.Handle("/ws", authMiddleware(HandleWebSocket(hub, authService, logger))).Methods("GET")
+	authRouter := router.PathPrefix("/api/auth").Subrouter()
+	authRouter.HandleFunc("/register", HandleRegister(svc.Auth, logger)).Methods("POST")
+	authRouter.HandleFunc("/login", HandleLogin(svc.Auth, cookies, logger)).Methods("POST")
+	authRouter.HandleFunc("/2fa/verify", HandleVerifyMFA(svc.Auth, cookies, logger)).Methods("POST")
+	authRouter.HandleFunc("/refresh", HandleRefresh(svc.Auth, cookies, logger)).Methods("POST")
+	authRouter.HandleFunc("/logout", HandleLogout(svc.Auth, hub, cookies, logger)).Methods("POST")
+	if svc.OIDC != nil {
+		authRouter.HandleFunc("/oidc/login", HandleOIDCLogin(svc.OIDC, cookies, logger)).Methods("GET")
+		authRouter.HandleFunc("/oidc/callback", HandleOIDCCallback(svc.OIDC, cookies, cfg.PostLoginRedirect, logger)).Methods("GET")
+	}
+
+	// Frames sent over the socket are checked against the token's scopes
+	// by the client.
+	wsAuthMiddleware := WebSocketAuthMiddleware(svc.Auth, cookies, logger)
+	router.Handle("/ws", wsAuthMiddleware(scoped(models.ScopeMessagesRead, HandleWebSocket(hub, svc.Auth, logger)))).Methods("GET")
 
 	apiRouter := router.PathPrefix("/api").Subrouter()
 	apiRouter.Use(authMiddleware)
 
-	apiRouter.HandleFunc("/messages/history", HandleMessageHistory(messageService, logger)).Methods("GET")
-	apiRouter.HandleFunc("/users/status", HandleUserStatus(statusService, logger)).Methods("GET")
+	apiRouter.Handle("/ws-ticket", userOnly(HandleWSTicket(svc.Auth, logger))).Methods("POST")
+	apiRouter.Handle("/sessions", userOnly(HandleListSessions(svc.Auth, logger))).Methods("GET")
+	apiRouter.Handle("/sessions/{id:[0-9a-f]{32}}", userOnly(HandleRevokeSession(svc.Auth, hub, logger))).Methods("DELETE")
+	apiRouter.Handle("/users/me/2fa", userOnly(HandleEnrollTOTP(svc.TwoFactor, logger))).Methods("POST")
+	apiRouter.Handle("/users/me/2fa/confirm", userOnly(HandleConfirmTOTP(svc.TwoFactor, logger))).Methods("POST")
+	apiRouter.Handle("/users/me/2fa", userOnly(HandleDisableTOTP(svc.TwoFactor, logger))).Methods("DELETE")
+	apiRouter.Handle("/messages/history", scoped(models.ScopeMessagesRead, HandleMessageHistory(svc.Message, logger))).Methods("GET")
+	apiRouter.Handle("/mentions", scoped(models.ScopeMessagesRead, HandleMentions(svc.Message, logger))).Methods("GET")
+	apiRouter.Handle("/users/status", permitted(policy.PermPresenceRead, scoped(models.ScopeStatusRead, HandleUserStatus(svc.Status, logger)))).Methods("GET")
+	apiRouter.Handle("/users/status", permitted(policy.PermStatusWrite, scoped(models.ScopeStatusWrite, HandleUpdateStatus(hub, logger)))).Methods("PUT")
+	apiRouter.Handle("/users/privacy", scoped(models.ScopeStatusRead, HandleGetPrivacy(svc.Status, logger))).Methods("GET")
+	apiRouter.Handle("/users/privacy", permitted(policy.PermStatusWrite, scoped(models.ScopeStatusWrite, HandleUpdatePrivacy(hub, logger)))).Methods("PUT")
+	apiRouter.Handle("/users/{id:[0-9]+}/activity/sessions", scoped(models.ScopeStatusRead, HandleOnlineSessions(svc.Activity, logger))).Methods("GET")
+	apiRouter.Handle("/users/{id:[0-9]+}/activity/daily", scoped(models.ScopeStatusRead, HandleDailyActivity(svc.Activity, logger))).Methods("GET")
+
+	apiRouter.Handle("/users/search", scoped(models.ScopeMessagesRead, HandleSearchUsers(svc.Profiles, logger))).Methods("GET")
+	apiRouter.Handle("/users/me/profile", userOnly(HandleUpdateProfile(svc.Profiles, hub, logger))).Methods("PUT")
+	apiRouter.Handle("/users/{id:[0-9]+}", scoped(models.ScopeMessagesRead, HandleGetProfile(svc.Profiles, logger))).Methods("GET")
+	apiRouter.Handle("/conversations", scoped(models.ScopeMessagesRead, HandleListConversations(svc.Conversations, logger))).Methods("GET")
+	apiRouter.Handle("/conversations/{id:[0-9]+}/settings", scoped(models.ScopeMessagesRead, HandleGetConversationSettings(svc.Conversations, logger))).Methods("GET")
+	apiRouter.Handle("/conversations/{id:[0-9]+}/settings", userOnly(HandleUpdateConversationSettings(svc.Conversations, logger))).Methods("PUT")
+
+	contactsRouter := apiRouter.PathPrefix("/contacts").Subrouter()
+	contactsRouter.Use(userOnly)
+	contactsRouter.HandleFunc("", HandleListContacts(svc.Contacts, logger)).Methods("GET")
+	contactsRouter.HandleFunc("/{id:[0-9]+}", HandleRemoveContact(svc.Contacts, hub, logger)).Methods("DELETE")
+	contactsRouter.HandleFunc("/requests", HandleListContactRequests(svc.Contacts, logger)).Methods("GET")
+	contactsRouter.HandleFunc("/requests", HandleSendContactRequest(svc.Contacts, hub, logger)).Methods("POST")
+	contactsRouter.HandleFunc("/requests/{id:[0-9]+}/accept", HandleAcceptContactRequest(svc.Contacts, hub, logger)).Methods("POST")
+	contactsRouter.HandleFunc("/requests/{id:[0-9]+}/decline", HandleDeclineContactRequest(svc.Contacts, hub, logger)).Methods("POST")
+	contactsRouter.HandleFunc("/requests/{id:[0-9]+}", HandleCancelContactRequest(svc.Contacts, hub, logger)).Methods("DELETE")
+	contactsRouter.HandleFunc("/settings", HandleGetContactSettings(svc.Contacts, logger)).Methods("GET")
+	contactsRouter.HandleFunc("/settings", HandleUpdateContactSettings(svc.Contacts, logger)).Methods("PUT")
 
+	blocksRouter := apiRouter.PathPrefix("/blocks").Subrouter()
+	blocksRouter.Use(userOnly)
+	blocksRouter.HandleFunc("", HandleListBlocks(svc.Blocks, logger)).Methods("GET")
+	blocksRouter.HandleFunc("", HandleBlockUser(svc.Blocks, hub, logger)).Methods("POST")
+	blocksRouter.HandleFunc("/{id:[0-9]+}", HandleUnblockUser(svc.Blocks, logger)).Methods("DELETE")
+
+	accountsRouter := apiRouter.PathPrefix("/service-accounts").Subrouter()
+	accountsRouter.Use(userOnly, RequirePermission(policy.PermServiceAccountsManage, logger))
+	accountsRouter.HandleFunc("", HandleCreateServiceAccount(svc.APIKeys, logger)).Methods("POST")
+	accountsRouter.HandleFunc("", HandleListServiceAccounts(svc.APIKeys, logger)).Methods("GET")
+	accountsRouter.HandleFunc("/{id:[0-9]+}/keys", HandleCreateAPIKey(svc.APIKeys, logger)).Methods("POST")
+	accountsRouter.HandleFunc("/{id:[0-9]+}/keys", HandleListAPIKeys(svc.APIKeys, logger)).Methods("GET")
+	accountsRouter.HandleFunc("/{id:[0-9]+}/keys/{keyID:[0-9]+}/rotate", HandleRotateAPIKey(svc.APIKeys, hub, logger)).Methods("POST")
+	accountsRouter.HandleFunc("/{id:[0-9]+}/keys/{keyID:[0-9]+}", HandleRevokeAPIKey(svc.APIKeys, hub, logger)).Methods("DELETE")
+
+	adminRouter := apiRouter.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(userOnly)
+	adminRouter.Handle("/users/{id:[0-9]+}/2fa", permitted(policy.PermTwoFactorReset, HandleResetTOTP(svc.TwoFactor, logger))).Methods("DELETE")
+	adminRouter.Handle("/users/{id:[0-9]+}/role", permitted(policy.PermRolesManage, HandleSetRole(svc.Roles, hub, logger))).Methods("PUT")
+
+	router.HandleFunc("/.well-known/jwks.json", HandleJWKS(svc.JWT, logger)).Methods("GET")
 	router.HandleFunc("/health", healthCheck).Methods("GET")
+	router.Handle("/debug/vars", authMiddleware(userOnly(permitted(policy.PermMetricsRead, expvar.Handler())))).Methods("GET")
 }
 
 func healthCheck(w http.ResponseWriter, r *http.Request) {
@@ -36,3 +134,11 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}, logger *zerolog.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
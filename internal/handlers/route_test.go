@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/service"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+// fakeAuth accepts the tokens it was given. Other AuthService methods panic.
+type fakeAuth struct {
+	service.AuthService
+	tokens map[string]*service.TokenInfo
+}
+
+func (a fakeAuth) ValidateToken(ctx context.Context, token string) (*service.TokenInfo, error) {
+	if info, ok := a.tokens[token]; ok {
+		return info, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func TestDebugVarsRequiresAdmin(t *testing.T) {
+	logger := zerolog.Nop()
+	router := mux.NewRouter()
+	SetupRoutes(router, Services{
+		Auth: fakeAuth{tokens: map[string]*service.TokenInfo{
+			"user":  {UserID: 1, Role: policy.RoleUser},
+			"admin": {UserID: 2, Role: policy.RoleAdmin},
+			"key":   {UserID: 2, Role: policy.RoleAdmin, Scopes: []string{}},
+		}},
+		Contacts: service.NewContactService(nil, nil, nil),
+	}, RouteConfig{}, &logger)
+
+	for token, want := range map[string]int{
+		"":      http.StatusUnauthorized,
+		"user":  http.StatusForbidden,
+		"key":   http.StatusForbidden,
+		"admin": http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("token %q: status %d, want %d", token, rec.Code, want)
+		}
+	}
+}
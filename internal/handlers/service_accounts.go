@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chatapp/internal/service"
+	"github.com/chatapp/internal/websocket"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+type serviceAccountRequest struct {
+	Name string `json:"name"`
+}
+
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is the key lifetime in seconds; zero keeps it valid until
+	// revoked.
+	ExpiresIn int `json:"expires_in"`
+}
+
+func HandleCreateServiceAccount(apiKeyService service.APIKeyService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ownerID := ctx.Value(userIDKey).(int)
+
+		var req serviceAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid service account body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		account, err := apiKeyService.CreateServiceAccount(ctx, ownerID, req.Name)
+		switch {
+		case errors.Is(err, service.ErrUsernameTaken):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, service.ErrInvalidAccountName):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrNestedServiceAccount):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			logger.Error().Err(err).Int("owner_id", ownerID).Msg("Failed to create service account")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, account, logger)
+	}
+}
+
+func HandleListServiceAccounts(apiKeyService service.APIKeyService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ownerID := ctx.Value(userIDKey).(int)
+
+		accounts, err := apiKeyService.ListServiceAccounts(ctx, ownerID)
+		if err != nil {
+			logger.Error().Err(err).Int("owner_id", ownerID).Msg("Failed to list service accounts")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, accounts, logger)
+	}
+}
+
+func HandleCreateAPIKey(apiKeyService service.APIKeyService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ownerID := ctx.Value(userIDKey).(int)
+
+		accountID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid service account ID", http.StatusBadRequest)
+			return
+		}
+
+		var req apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid API key body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		key, err := apiKeyService.CreateKey(ctx, ownerID, accountID, req.Name, req.Scopes, time.Duration(req.ExpiresIn)*time.Second)
+		if err != nil {
+			writeAPIKeyError(w, err, ownerID, logger)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusCreated, key, logger)
+	}
+}
+
+func HandleListAPIKeys(apiKeyService service.APIKeyService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ownerID := ctx.Value(userIDKey).(int)
+
+		accountID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid service account ID", http.StatusBadRequest)
+			return
+		}
+
+		keys, err := apiKeyService.ListKeys(ctx, ownerID, accountID)
+		if err != nil {
+			writeAPIKeyError(w, err, ownerID, logger)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, keys, logger)
+	}
+}
+
+// HandleRotateAPIKey replaces the key and closes connections opened with the
+// old one.
+func HandleRotateAPIKey(apiKeyService service.APIKeyService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ownerID := ctx.Value(userIDKey).(int)
+
+		accountID, keyID, ok := parseAPIKeyPath(w, r)
+		if !ok {
+			return
+		}
+
+		key, err := apiKeyService.RotateKey(ctx, ownerID, accountID, keyID)
+		if err != nil {
+			writeAPIKeyError(w, err, ownerID, logger)
+			return
+		}
+		hub.RevokeToken(service.APIKeyTokenID(keyID))
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusCreated, key, logger)
+	}
+}
+
+func HandleRevokeAPIKey(apiKeyService service.APIKeyService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ownerID := ctx.Value(userIDKey).(int)
+
+		accountID, keyID, ok := parseAPIKeyPath(w, r)
+		if !ok {
+			return
+		}
+
+		if err := apiKeyService.RevokeKey(ctx, ownerID, accountID, keyID); err != nil {
+			writeAPIKeyError(w, err, ownerID, logger)
+			return
+		}
+		hub.RevokeToken(service.APIKeyTokenID(keyID))
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func parseAPIKeyPath(w http.ResponseWriter, r *http.Request) (int, int64, bool) {
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid service account ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	keyID, err := strconv.ParseInt(vars["keyID"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid key ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return accountID, keyID, true
+}
+
+func writeAPIKeyError(w http.ResponseWriter, err error, ownerID int, logger *zerolog.Logger) {
+	switch {
+	case errors.Is(err, service.ErrServiceAccountNotFound), errors.Is(err, service.ErrAPIKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidAPIKey):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrInvalidScope), errors.Is(err, service.ErrMissingScope),
+		errors.Is(err, service.ErrInvalidKeyName), errors.Is(err, service.ErrInvalidKeyExpiry):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		// Anything else is a server fault whose details stay in the log.
+		logger.Error().Err(err).Int("owner_id", ownerID).Msg("API key operation failed")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
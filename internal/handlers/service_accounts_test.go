@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/service"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+// failingAPIKeys fails every call with err.
+type failingAPIKeys struct {
+	service.APIKeyService
+	err error
+}
+
+func (s failingAPIKeys) CreateServiceAccount(ctx context.Context, ownerID int, name string) (*models.User, error) {
+	return nil, s.err
+}
+
+func (s failingAPIKeys) CreateKey(ctx context.Context, ownerID, accountID int, name string, scopes []string, expiresIn time.Duration) (*models.NewAPIKey, error) {
+	return nil, s.err
+}
+
+func serveAsOwner(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), userIDKey, 1))
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestServiceAccountErrorsDoNotLeakInternals(t *testing.T) {
+	logger := zerolog.Nop()
+	internal := errors.New("dial tcp 10.0.0.5:3306: connection refused")
+
+	for _, c := range []struct {
+		err    error
+		status int
+	}{
+		{service.ErrInvalidAccountName, http.StatusBadRequest},
+		{service.ErrNestedServiceAccount, http.StatusForbidden},
+		{service.ErrUsernameTaken, http.StatusConflict},
+		{internal, http.StatusInternalServerError},
+	} {
+		rec := serveAsOwner(HandleCreateServiceAccount(failingAPIKeys{err: c.err}, &logger), `{"name":"bot"}`)
+		if rec.Code != c.status {
+			t.Errorf("%v: status %d, want %d", c.err, rec.Code, c.status)
+		}
+		if strings.Contains(rec.Body.String(), "10.0.0.5") {
+			t.Errorf("%v: response leaks %q", c.err, rec.Body.String())
+		}
+	}
+}
+
+func TestAPIKeyErrorsDoNotLeakInternals(t *testing.T) {
+	logger := zerolog.Nop()
+	internal := errors.New("Error 1205: Lock wait timeout exceeded")
+
+	for _, c := range []struct {
+		err    error
+		status int
+	}{
+		{service.ErrServiceAccountNotFound, http.StatusNotFound},
+		{service.ErrInvalidScope, http.StatusBadRequest},
+		{service.ErrMissingScope, http.StatusBadRequest},
+		{service.ErrInvalidKeyName, http.StatusBadRequest},
+		{service.ErrInvalidKeyExpiry, http.StatusBadRequest},
+		{internal, http.StatusInternalServerError},
+	} {
+		rec := serveAsOwner(HandleCreateAPIKey(failingAPIKeys{err: c.err}, &logger), `{"name":"ci","scopes":["messages.send"]}`)
+		if rec.Code != c.status {
+			t.Errorf("%v: status %d, want %d", c.err, rec.Code, c.status)
+		}
+		if strings.Contains(rec.Body.String(), "Lock wait") {
+			t.Errorf("%v: response leaks %q", c.err, rec.Body.String())
+		}
+	}
+}
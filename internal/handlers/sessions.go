@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/service"
+	"github.com/chatapp/internal/websocket"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+// HandleListSessions lists the devices the user is logged in on.
+func HandleListSessions(authService service.AuthService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		currentID := ""
+		if token, ok := ctx.Value(tokenKey).(*service.TokenInfo); ok {
+			currentID = token.SessionID
+		}
+
+		sessions, err := authService.ListSessions(ctx, userID, currentID)
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to list sessions")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, sessions, logger)
+	}
+}
+
+// HandleRevokeSession logs one of the user's devices out and closes its
+// WebSockets.
+func HandleRevokeSession(authService service.AuthService, hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+		sessionID := mux.Vars(r)["id"]
+
+		err := authService.RevokeSession(ctx, userID, sessionID)
+		if errors.Is(err, service.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to revoke session")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		hub.RevokeSession(sessionID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deviceInfo describes the client making the request. name is optional and
+// supplied by the client.
+func deviceInfo(r *http.Request, name string) *models.DeviceInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return &models.DeviceInfo{
+		Name:      name,
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+	}
+}
@@ -4,42 +4,38 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/chatapp/internal/models"
 	"github.com/chatapp/internal/service"
+	"github.com/chatapp/internal/websocket"
 	"github.com/rs/zerolog"
 )
 
+// maxStatusLookup bounds how many users one status request may ask for.
+const maxStatusLookup = 100
+
 func HandleUserStatus(statusService service.StatusService, logger *zerolog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		callerID := ctx.Value(userIDKey).(int)
 
-		userIDStr := r.URL.Query().Get("user_id")
-		var statuses []*models.UserStatus
-		var err error
-
-		if userIDStr != "" {
-			userID, err := strconv.Atoi(userIDStr)
-			if err != nil {
-				logger.Warn().Err(err).Msg("Invalid user_id parameter")
-				http.Error(w, "Invalid user_id parameter", http.StatusBadRequest)
-				return
-			}
-
-			status, err := statusService.GetUserStatus(ctx, userID)
-			if err != nil {
-				logger.Error().Err(err).Msg("Failed to get user status")
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-			statuses = []*models.UserStatus{status}
-		} else {
-			statuses, err = statusService.GetAllStatuses(ctx)
-			if err != nil {
-				logger.Error().Err(err).Msg("Failed to get all user statuses")
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
+		userIDs, err := parseUserIDs(r.URL.Query().Get("user_id"), r.URL.Query().Get("user_ids"))
+		if err != nil {
+			logger.Warn().Err(err).Msg("Invalid user_id parameter")
+			http.Error(w, "Invalid user_id parameter", http.StatusBadRequest)
+			return
+		}
+		if len(userIDs) == 0 || len(userIDs) > maxStatusLookup {
+			http.Error(w, "Provide user_id or user_ids (up to 100)", http.StatusBadRequest)
+			return
+		}
+
+		statuses, err := statusService.GetStatusesFor(ctx, callerID, userIDs)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to get user statuses")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -49,3 +45,97 @@ func HandleUserStatus(statusService service.StatusService, logger *zerolog.Logge
 		}
 	}
 }
+
+func parseUserIDs(single, list string) ([]int, error) {
+	var fields []string
+	if single != "" {
+		fields = append(fields, single)
+	}
+	if list != "" {
+		fields = append(fields, strings.Split(list, ",")...)
+	}
+
+	userIDs := make([]int, 0, len(fields))
+	for _, field := range fields {
+		userID, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+func HandleUpdateStatus(hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		var update models.StatusUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			logger.Warn().Err(err).Msg("Invalid status update body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		status, err := hub.SetStatus(ctx, userID, &update)
+		if err != nil {
+			logger.Warn().Err(err).Int("user_id", userID).Msg("Failed to update status")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode status response")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+func HandleGetPrivacy(statusService service.StatusService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		status, err := statusService.GetUserStatus(ctx, userID)
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get privacy settings")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status.Privacy); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode privacy response")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+func HandleUpdatePrivacy(hub *websocket.Hub, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		var update models.PrivacyUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			logger.Warn().Err(err).Msg("Invalid privacy settings body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		settings, err := hub.UpdatePrivacy(ctx, userID, &update)
+		if err != nil {
+			logger.Warn().Err(err).Int("user_id", userID).Msg("Failed to update privacy settings")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(settings); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode privacy response")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
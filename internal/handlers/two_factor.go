@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/service"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+type mfaVerifyRequest struct {
+	MFAToken   string `json:"mfa_token"`
+	Code       string `json:"code"`
+	DeviceName string `json:"device_name,omitempty"`
+}
+
+func HandleEnrollTOTP(twoFactorService service.TwoFactorService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		enrollment, err := twoFactorService.Enroll(ctx, userID)
+		if errors.Is(err, service.ErrTOTPAlreadyEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", userID).Msg("Failed to enroll TOTP")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode enrollment response")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+func HandleConfirmTOTP(twoFactorService service.TwoFactorService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		var req codeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid TOTP confirm body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		codes, err := twoFactorService.Confirm(ctx, userID, req.Code)
+		if err != nil {
+			writeTwoFactorError(w, err, userID, logger)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes}); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode recovery codes")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+func HandleDisableTOTP(twoFactorService service.TwoFactorService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := ctx.Value(userIDKey).(int)
+
+		var req codeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid TOTP disable body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := twoFactorService.Disable(ctx, userID, req.Code); err != nil {
+			writeTwoFactorError(w, err, userID, logger)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleResetTOTP lets an administrator turn off 2FA for a user who lost
+// access to their authenticator and recovery codes.
+func HandleResetTOTP(twoFactorService service.TwoFactorService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		adminID := ctx.Value(userIDKey).(int)
+
+		userID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		err = twoFactorService.Reset(ctx, userID)
+		if errors.Is(err, policy.ErrForbidden) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			logger.Warn().Err(err).Int("user_id", userID).Msg("Failed to reset TOTP")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Info().Int("admin_id", adminID).Int("user_id", userID).Msg("Two-factor authentication reset")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleVerifyMFA exchanges the mfa_token from the password step and a TOTP
+// or recovery code for the session tokens.
+func HandleVerifyMFA(authService service.AuthService, cookies CookieConfig, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mfaVerifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Warn().Err(err).Msg("Invalid MFA verify body")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := authService.VerifyMFA(r.Context(), req.MFAToken, req.Code, deviceInfo(r, req.DeviceName))
+		switch {
+		case errors.Is(err, service.ErrInvalidMFAChallenge), errors.Is(err, service.ErrInvalidCode):
+			logger.Warn().Err(err).Msg("Failed MFA verification")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errors.Is(err, service.ErrAccountLocked):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			logger.Error().Err(err).Msg("Failed to verify MFA")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeTokens(w, cookies, tokens, logger)
+	}
+}
+
+func writeTwoFactorError(w http.ResponseWriter, err error, userID int, logger *zerolog.Logger) {
+	switch {
+	case errors.Is(err, service.ErrInvalidCode):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, service.ErrTOTPAlreadyEnabled),
+		errors.Is(err, service.ErrTOTPNotEnabled),
+		errors.Is(err, service.ErrTOTPNotEnrolled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		logger.Error().Err(err).Int("user_id", userID).Msg("Two-factor operation failed")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
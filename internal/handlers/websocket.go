@@ -25,7 +25,10 @@ func HandleWebSocket(hub *websocket.Hub, authService service.AuthService, logger
 		}
 
 		client := websocket.NewClient(hub, conn, userID)
-		hub.Register <- client
+		if token, ok := ctx.Value(tokenKey).(*service.TokenInfo); ok {
+			client.SetToken(token)
+		}
+		hub.Register(client)
 
 		go client.WritePump()
 		go client.ReadPump()
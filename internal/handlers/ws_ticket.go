@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/chatapp/internal/service"
+	"github.com/rs/zerolog"
+)
+
+type wsTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// HandleWSTicket exchanges the caller's access token for a ticket to pass as
+// /ws?ticket=..., since browsers cannot set headers on the handshake.
+func HandleWSTicket(authService service.AuthService, logger *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		token, ok := ctx.Value(tokenKey).(*service.TokenInfo)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ticket, expiresAt, err := authService.IssueWSTicket(ctx, token)
+		if errors.Is(err, service.ErrInvalidTicket) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Int("user_id", token.UserID).Msg("Failed to issue WebSocket ticket")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		response := wsTicketResponse{Ticket: ticket, ExpiresIn: int(time.Until(expiresAt).Round(time.Second).Seconds())}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode ticket response")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
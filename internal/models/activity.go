@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// StatusEventVisible marks the end of an invisible period in the presence
+// history. Besides the connection-driven statuses, the history records when
+// the user turns invisible (StatusInvisible) and visible again.
+const StatusEventVisible = "visible"
+
+// StatusEvent is one entry of the append-only presence history.
+type StatusEvent struct {
+	ID         int64     `json:"id"`
+	UserID     int       `json:"user_id"`
+	Status     string    `json:"status"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// OnlineSession is a span during which the user was connected.
+type OnlineSession struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Ongoing bool      `json:"ongoing,omitempty"`
+}
+
+type DailyActivity struct {
+	Date          string `json:"date"`
+	ActiveMinutes int    `json:"active_minutes"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// API key scopes. A user's own token is not scoped; an API key may only do
+// what its scopes allow.
+const (
+	ScopeMessagesRead = "messages:read"
+	ScopeMessagesSend = "messages:send"
+	ScopeStatusRead   = "status:read"
+	ScopeStatusWrite  = "status:write"
+)
+
+var ValidScopes = map[string]bool{
+	ScopeMessagesRead: true,
+	ScopeMessagesSend: true,
+	ScopeStatusRead:   true,
+	ScopeStatusWrite:  true,
+}
+
+// APIKey authenticates a service account. Only the hash of the key is
+// stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int        `json:"service_account_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// NewAPIKey is returned when a key is created or rotated; Key is shown only
+// this once.
+type NewAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
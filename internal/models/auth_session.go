@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// AuthSession is one login on one device. It lives as long as its refresh
+// token family and shares its ID.
+type AuthSession struct {
+	ID           string     `json:"id"`
+	UserID       int        `json:"-"`
+	DeviceName   string     `json:"device_name"`
+	IP           string     `json:"ip"`
+	UserAgent    string     `json:"user_agent"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastActiveAt time.Time  `json:"last_active_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RevokedAt    *time.Time `json:"-"`
+	// Current marks the session the listing was requested from.
+	Current bool `json:"current"`
+}
+
+// DeviceInfo describes the client a login comes from.
+type DeviceInfo struct {
+	Name      string
+	IP        string
+	UserAgent string
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// BlockedUser is a user the owner has blocked.
+type BlockedUser struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+const (
+	ContactRequestPending   = "pending"
+	ContactRequestAccepted  = "accepted"
+	ContactRequestDeclined  = "declined"
+	ContactRequestCancelled = "cancelled"
+)
+
+type ContactRequest struct {
+	ID          int64      `json:"id"`
+	SenderID    int        `json:"sender_id"`
+	ReceiverID  int        `json:"receiver_id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+}
+
+// ContactRequests are a user's pending requests, split by direction.
+type ContactRequests struct {
+	Incoming []*ContactRequest `json:"incoming"`
+	Outgoing []*ContactRequest `json:"outgoing"`
+}
+
+type Contact struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Since    time.Time `json:"since"`
+}
+
+// ContactSettings controls who may message the user: VisibilityEveryone or
+// VisibilityContacts.
+type ContactSettings struct {
+	MessagesFrom string `json:"messages_from"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Notification levels of a conversation.
+const (
+	NotifyAll      = "all"
+	NotifyMentions = "mentions"
+	NotifyNone     = "none"
+)
+
+// Conversation is a one-to-one conversation as listed for one of its users.
+type Conversation struct {
+	UserID        int                   `json:"user_id"`
+	Username      string                `json:"username"`
+	LastMessageAt time.Time             `json:"last_message_at"`
+	Unread        int                   `json:"unread"`
+	Settings      *ConversationSettings `json:"settings"`
+}
+
+// ConversationSettings are a user's preferences for one conversation.
+type ConversationSettings struct {
+	MutedUntil        *time.Time `json:"muted_until,omitempty"`
+	NotificationLevel string     `json:"notification_level"`
+	Nickname          string     `json:"nickname,omitempty"`
+}
+
+func DefaultConversationSettings() *ConversationSettings {
+	return &ConversationSettings{NotificationLevel: NotifyAll}
+}
+
+func (s *ConversationSettings) Muted(now time.Time) bool {
+	return s.MutedUntil != nil && now.Before(*s.MutedUntil)
+}
+
+// ShouldAlert reports whether a new message should alert the user. Every
+// notification path must go through it.
+func (s *ConversationSettings) ShouldAlert(now time.Time, mentioned bool) bool {
+	if s.Muted(now) {
+		return false
+	}
+	switch s.NotificationLevel {
+	case NotifyNone:
+		return false
+	case NotifyMentions:
+		return mentioned
+	default:
+		return true
+	}
+}
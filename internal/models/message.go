@@ -2,6 +2,41 @@ package models
 
 import "time"
 
+// MessageRejected is the status of a message the receiver does not accept.
+// It is reported to the sender in the ack and never delivered.
+const MessageRejected = "rejected"
+
+// Entity types. Mentions are always detected by the server; the others come
+// from Markdown or from the client.
+const (
+	EntityBold    = "bold"
+	EntityItalic  = "italic"
+	EntityCode    = "code"
+	EntityLink    = "link"
+	EntityMention = "mention"
+)
+
+// FormatMarkdown asks the server to parse Content as Markdown. Messages
+// without a format are plain text, optionally with client entities.
+const FormatMarkdown = "markdown"
+
+// MessageEntity marks a span of a message's content. Offset and Length count
+// Unicode code points. Entities nest but never cross, and nothing nests
+// inside code.
+type MessageEntity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	// URL is the target of an EntityLink.
+	URL string `json:"url,omitempty"`
+	// UserID is the mentioned user, for EntityMention.
+	UserID int `json:"user_id,omitempty"`
+}
+
+func (e MessageEntity) End() int {
+	return e.Offset + e.Length
+}
+
 type Message struct {
 	ID         int64     `json:"id,omitempty"`
 	SenderID   int       `json:"sender_id"`
@@ -10,6 +45,38 @@ type Message struct {
 	Timestamp  time.Time `json:"timestamp"`
 	Status     string    `json:"status"`
 	Type       string    `json:"type,omitempty"` // Para mensagens de sistema
+	// Format is only read from clients: the server turns Markdown into plain
+	// Content and Entities before storing the message.
+	Format   string          `json:"format,omitempty"`
+	Entities []MessageEntity `json:"entities,omitempty"`
+
+	// Presence carries status_update events and set_status requests.
+	Presence *StatusUpdate `json:"presence,omitempty"`
+	// UserIDs lists the targets of subscribe_presence/unsubscribe_presence.
+	UserIDs []int `json:"user_ids,omitempty"`
+	// Token carries a fresh access token in reauth frames.
+	Token string `json:"token,omitempty"`
+	// ContactRequest carries contact request events.
+	ContactRequest *ContactRequest `json:"contact_request,omitempty"`
+	// Profile carries profile_update events.
+	Profile *Profile `json:"profile,omitempty"`
+	// Silent tells the receiver's client not to alert for this message: the
+	// conversation is muted or its notifications are turned down.
+	Silent bool `json:"silent,omitempty"`
+	// Dropped marks a message to a receiver who blocked the sender. It is
+	// stored and acknowledged like any other, but hidden from the receiver
+	// and never delivered.
+	Dropped bool `json:"-"`
+}
+
+// Mentions reports whether the message mentions the user.
+func (m *Message) Mentions(userID int) bool {
+	for _, entity := range m.Entities {
+		if entity.Type == EntityMention && entity.UserID == userID {
+			return true
+		}
+	}
+	return false
 }
 
 type MessageRequest struct {
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Profile is the public face of a user. Every field but the username is
+// optional.
+type Profile struct {
+	UserID      int        `json:"user_id"`
+	Username    string     `json:"username"`
+	DisplayName string     `json:"display_name,omitempty"`
+	AvatarURL   string     `json:"avatar_url,omitempty"`
+	Bio         string     `json:"bio,omitempty"`
+	Timezone    string     `json:"timezone,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
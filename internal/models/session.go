@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Session is a single WebSocket connection owned by a backend node.
+type Session struct {
+	ID            string    `json:"id"`
+	UserID        int       `json:"user_id"`
+	NodeID        string    `json:"node_id"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	// LastInput is the last frame sent by the user, as opposed to a pong,
+	// recorded with heartbeat precision.
+	LastInput time.Time `json:"last_input"`
+}
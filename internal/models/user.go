@@ -0,0 +1,80 @@
+package models
+
+import "time"
+
+// User is a registered account. Credentials and lockout state never leave
+// the server.
+type User struct {
+	ID           int        `json:"id"`
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"-"`
+	FailedLogins int        `json:"-"`
+	LockedUntil  *time.Time `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"`
+
+	// Service accounts are bots owned by a user. They authenticate with API
+	// keys only.
+	IsServiceAccount bool `json:"is_service_account,omitempty"`
+	OwnerID          int  `json:"owner_id,omitempty"`
+
+	// Role is one of the roles defined by the policy package.
+	Role string `json:"role"`
+}
+
+// AuthTokens is returned by a successful login or refresh. When the account
+// has two-factor authentication, the password step returns only MFAToken,
+// to be exchanged for the tokens together with a TOTP or recovery code.
+type AuthTokens struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	User         *User  `json:"user,omitempty"`
+
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// TOTPEnrollment is returned when a user starts enrolling an authenticator.
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// RefreshToken is the server-side record of a refresh token. Only the hash of
+// the token is stored. Tokens rotated from the same login share a family.
+type RefreshToken struct {
+	TokenHash string
+	FamilyID  string
+	UserID    int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// WSTicket is a single-use credential for opening a WebSocket. It inherits
+// the identity and expiry of the access token it was issued for.
+type WSTicket struct {
+	TicketHash     string
+	UserID         int
+	TokenID        string
+	SessionID      string
+	TokenExpiresAt time.Time
+	ExpiresAt      time.Time
+}
+
+// OIDCState remembers an OIDC login between the redirect to the provider and
+// the callback. It is looked up by the hash of the state parameter.
+type OIDCState struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	// BindingHash ties the login to the browser that started it.
+	BindingHash string
+	ExpiresAt   time.Time
+}
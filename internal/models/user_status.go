@@ -1,11 +1,137 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	StatusOnline    = "online"
+	StatusAway      = "away"
+	StatusDND       = "dnd"
+	StatusInvisible = "invisible"
+	StatusOffline   = "offline"
+)
+
+const (
+	VisibilityEveryone = "everyone"
+	VisibilityContacts = "contacts"
+	VisibilityNobody   = "nobody"
+)
+
+// PrivacySettings controls who can see a user's online status and last seen
+// time. Empty values mean everyone.
+type PrivacySettings struct {
+	OnlineStatus string `json:"online_status"`
+	LastSeen     string `json:"last_seen"`
+}
+
+// PrivacyUpdate changes some of a user's privacy settings. Nil fields keep
+// their stored value.
+type PrivacyUpdate struct {
+	OnlineStatus *string `json:"online_status,omitempty"`
+	LastSeen     *string `json:"last_seen,omitempty"`
+}
+
+// Allows reports whether a viewer may see the protected field.
+func (p *PrivacySettings) Allows(visibility string, isContact bool) bool {
+	switch visibility {
+	case VisibilityNobody:
+		return false
+	case VisibilityContacts:
+		return isContact
+	default:
+		return true
+	}
+}
 
 type UserStatus struct {
-	UserID   int       `json:"user_id"`
-	Status   string    `json:"status"`
-	LastSeen time.Time `json:"last_seen,omitempty"`
+	UserID          int        `json:"user_id"`
+	Status          string     `json:"status"`
+	LastSeen        time.Time  `json:"last_seen,omitempty"`
+	StatusText      string     `json:"status_text,omitempty"`
+	StatusEmoji     string     `json:"status_emoji,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+
+	Privacy *PrivacySettings `json:"privacy,omitempty"`
+
+	// ManualStatus is the state chosen by the user (dnd, invisible, away),
+	// which overrides the connection-driven Status while they are connected.
+	ManualStatus string `json:"-"`
+}
+
+// MarshalJSON omits last_seen when it has been hidden.
+func (s UserStatus) MarshalJSON() ([]byte, error) {
+	type plain UserStatus
+	var lastSeen *time.Time
+	if !s.LastSeen.IsZero() {
+		lastSeen = &s.LastSeen
+	}
+	return json.Marshal(struct {
+		plain
+		LastSeen *time.Time `json:"last_seen,omitempty"`
+	}{plain(s), lastSeen})
+}
+
+// Public returns the status as any other user may see it: invisible users
+// appear offline, without their custom status.
+func (s *UserStatus) Public() *UserStatus {
+	public := *s
+	public.Privacy = nil
+	if public.Status == StatusInvisible {
+		public.hideOnline()
+	}
+	return &public
+}
+
+// ViewFor returns the status as seen by another user, applying the owner's
+// privacy settings on top of Public.
+func (s *UserStatus) ViewFor(isContact bool) *UserStatus {
+	view := s.Public()
+	if !s.OnlineVisibleTo(isContact) {
+		view.hideOnline()
+	}
+	if !s.LastSeenVisibleTo(isContact) {
+		view.LastSeen = time.Time{}
+	}
+	return view
+}
+
+// Hidden returns the status as seen by a user who may not see the owner's
+// presence at all.
+func (s *UserStatus) Hidden() *UserStatus {
+	view := s.Public()
+	view.hideOnline()
+	view.LastSeen = time.Time{}
+	return view
+}
+
+func (s *UserStatus) LastSeenVisibleTo(isContact bool) bool {
+	return s.Privacy == nil || s.Privacy.Allows(s.Privacy.LastSeen, isContact)
+}
+
+// OnlineVisibleTo reports whether the owner's settings let the viewer see
+// their online status at all.
+func (s *UserStatus) OnlineVisibleTo(isContact bool) bool {
+	return s.Privacy == nil || s.Privacy.Allows(s.Privacy.OnlineStatus, isContact)
+}
+
+func (s *UserStatus) hideOnline() {
+	s.Status = StatusOffline
+	s.StatusText = ""
+	s.StatusEmoji = ""
+	s.StatusExpiresAt = nil
+}
+
+// ToUpdate converts the status into the payload carried by presence events.
+func (s *UserStatus) ToUpdate() *StatusUpdate {
+	return &StatusUpdate{
+		UserID:      s.UserID,
+		Status:      s.Status,
+		StatusText:  s.StatusText,
+		StatusEmoji: s.StatusEmoji,
+		ExpiresAt:   s.StatusExpiresAt,
+	}
 }
 
 type UserStatusResponse struct {
@@ -15,6 +141,9 @@ type UserStatusResponse struct {
 }
 
 type StatusUpdate struct {
-	UserID int    `json:"user_id"`
-	Status string `json:"status"`
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	StatusText  string     `json:"status_text,omitempty"`
+	StatusEmoji string     `json:"status_emoji,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
 }
@@ -0,0 +1,114 @@
+// Package policy decides what a user's role allows. HTTP middleware, the
+// WebSocket hub and services all ask it, so a permission is enforced the same
+// way whichever path an action takes.
+package policy
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrForbidden = errors.New("permission denied")
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+type Permission string
+
+const (
+	PermMessagesSend          Permission = "messages.send"
+	PermStatusWrite           Permission = "status.write"
+	PermPresenceRead          Permission = "presence.read"
+	PermServiceAccountsManage Permission = "service_accounts.manage"
+	// PermActivityViewAny sees presence history regardless of the owner's
+	// privacy settings.
+	PermActivityViewAny Permission = "activity.view_any"
+	PermTwoFactorReset  Permission = "users.reset_2fa"
+	PermRolesManage     Permission = "users.manage_roles"
+	// PermMetricsRead reads the process metrics served at /debug/vars.
+	PermMetricsRead Permission = "metrics.read"
+)
+
+// Each role has the permissions of the roles below it.
+var (
+	userPermissions = []Permission{
+		PermMessagesSend,
+		PermStatusWrite,
+		PermPresenceRead,
+		PermServiceAccountsManage,
+	}
+	moderatorPermissions = []Permission{
+		PermActivityViewAny,
+	}
+	adminPermissions = []Permission{
+		PermTwoFactorReset,
+		PermRolesManage,
+		PermMetricsRead,
+	}
+)
+
+var grants = map[Role]map[Permission]bool{
+	RoleUser:      permissionSet(userPermissions),
+	RoleModerator: permissionSet(userPermissions, moderatorPermissions),
+	RoleAdmin:     permissionSet(userPermissions, moderatorPermissions, adminPermissions),
+}
+
+func permissionSet(lists ...[]Permission) map[Permission]bool {
+	set := make(map[Permission]bool)
+	for _, perms := range lists {
+		for _, perm := range perms {
+			set[perm] = true
+		}
+	}
+	return set
+}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	_, ok := grants[r]
+	return ok
+}
+
+// Can reports whether the role grants perm. Unknown roles grant nothing.
+func Can(role Role, perm Permission) bool {
+	return grants[role][perm]
+}
+
+// Subject is the user an action is performed as.
+type Subject struct {
+	UserID int
+	Role   Role
+}
+
+type subjectKey struct{}
+
+// NewContext returns a context that carries the acting subject.
+func NewContext(ctx context.Context, subject Subject) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// FromContext returns the acting subject, if the context carries one.
+func FromContext(ctx context.Context) (Subject, bool) {
+	subject, ok := ctx.Value(subjectKey{}).(Subject)
+	return subject, ok
+}
+
+// Allowed reports whether the context's subject has perm. A context without
+// a subject is allowed nothing.
+func Allowed(ctx context.Context, perm Permission) bool {
+	subject, ok := FromContext(ctx)
+	return ok && Can(subject.Role, perm)
+}
+
+// Authorize returns ErrForbidden unless the context's subject has perm.
+func Authorize(ctx context.Context, perm Permission) error {
+	if !Allowed(ctx, perm) {
+		return ErrForbidden
+	}
+	return nil
+}
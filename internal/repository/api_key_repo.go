@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	GetByID(ctx context.Context, keyID int64) (*models.APIKey, error)
+	GetByUser(ctx context.Context, userID int) ([]*models.APIKey, error)
+	Revoke(ctx context.Context, keyID int64, at time.Time) error
+	TouchLastUsed(ctx context.Context, keyID int64, at time.Time) error
+}
+
+type apiKeyRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewAPIKeyRepository(db *sql.DB, logger *zerolog.Logger) APIKeyRepository {
+	return &apiKeyRepository{db: db, logger: logger}
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, created_at, expires_at, last_used_at, revoked_at`
+
+func (r *apiKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := r.db.ExecContext(ctx, query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		strings.Join(key.Scopes, ","),
+		key.CreatedAt,
+		nullTime(key.ExpiresAt),
+	)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", key.UserID).Msg("Failed to create API key")
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to get last insert ID")
+		return err
+	}
+	key.ID = id
+	return nil
+}
+
+func (r *apiKeyRepository) GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = ?`
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, keyHash))
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Msg("Failed to get API key")
+	}
+	return key, err
+}
+
+func (r *apiKeyRepository) GetByID(ctx context.Context, keyID int64) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = ?`
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, keyID))
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Int64("key_id", keyID).Msg("Failed to get API key")
+	}
+	return key, err
+}
+
+func (r *apiKeyRepository) GetByUser(ctx context.Context, userID int) ([]*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = ? ORDER BY id`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get API keys")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan API key")
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *apiKeyRepository) Revoke(ctx context.Context, keyID int64, at time.Time) error {
+	query := `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+	_, err := r.db.ExecContext(ctx, query, at, keyID)
+	if err != nil {
+		r.logger.Error().Err(err).Int64("key_id", keyID).Msg("Failed to revoke API key")
+		return err
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) TouchLastUsed(ctx context.Context, keyID int64, at time.Time) error {
+	query := `UPDATE api_keys SET last_used_at = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, query, at, keyID)
+	if err != nil {
+		r.logger.Error().Err(err).Int64("key_id", keyID).Msg("Failed to update API key last use")
+		return err
+	}
+	return nil
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+// AuthSessionRepository stores the devices users are logged in on. These are
+// unrelated to the presence sessions of individual WebSocket connections.
+type AuthSessionRepository interface {
+	Create(ctx context.Context, session *models.AuthSession) error
+	Get(ctx context.Context, sessionID string) (*models.AuthSession, error)
+	// GetActive returns the user's sessions that are neither revoked nor
+	// expired, most recently active first.
+	GetActive(ctx context.Context, userID int, now time.Time) ([]*models.AuthSession, error)
+	// Touch records activity from ip. A non-zero expiresAt also extends the
+	// session, as a refresh does.
+	Touch(ctx context.Context, sessionID, ip string, at, expiresAt time.Time) error
+	Revoke(ctx context.Context, sessionID string, at time.Time) (bool, error)
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+type authSessionRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewAuthSessionRepository(db *sql.DB, logger *zerolog.Logger) AuthSessionRepository {
+	return &authSessionRepository{db: db, logger: logger}
+}
+
+const authSessionColumns = `id, user_id, device_name, ip, user_agent, created_at, last_active_at, expires_at, revoked_at`
+
+func (r *authSessionRepository) Create(ctx context.Context, session *models.AuthSession) error {
+	query := `
+		INSERT INTO auth_sessions (id, user_id, device_name, ip, user_agent, created_at, last_active_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		session.ID,
+		session.UserID,
+		session.DeviceName,
+		session.IP,
+		session.UserAgent,
+		session.CreatedAt,
+		session.LastActiveAt,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", session.UserID).Msg("Failed to create auth session")
+		return err
+	}
+	return nil
+}
+
+func (r *authSessionRepository) Get(ctx context.Context, sessionID string) (*models.AuthSession, error) {
+	query := `SELECT ` + authSessionColumns + ` FROM auth_sessions WHERE id = ?`
+	session, err := scanAuthSession(r.db.QueryRowContext(ctx, query, sessionID))
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Str("session_id", sessionID).Msg("Failed to get auth session")
+	}
+	return session, err
+}
+
+func (r *authSessionRepository) GetActive(ctx context.Context, userID int, now time.Time) ([]*models.AuthSession, error) {
+	query := `
+		SELECT ` + authSessionColumns + `
+		FROM auth_sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_active_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID, now)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get auth sessions")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.AuthSession
+	for rows.Next() {
+		session, err := scanAuthSession(rows)
+		if err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan auth session")
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (r *authSessionRepository) Touch(ctx context.Context, sessionID, ip string, at, expiresAt time.Time) error {
+	query := `
+		UPDATE auth_sessions
+		SET last_active_at = ?, ip = ?, expires_at = GREATEST(expires_at, ?)
+		WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := r.db.ExecContext(ctx, query, at, ip, expiresAt, sessionID)
+	if err != nil {
+		r.logger.Error().Err(err).Str("session_id", sessionID).Msg("Failed to update auth session")
+		return err
+	}
+	return nil
+}
+
+func (r *authSessionRepository) Revoke(ctx context.Context, sessionID string, at time.Time) (bool, error) {
+	query := `UPDATE auth_sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, at, sessionID)
+	if err != nil {
+		r.logger.Error().Err(err).Str("session_id", sessionID).Msg("Failed to revoke auth session")
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// DeleteExpired removes sessions that expired or were revoked before the
+// given time.
+func (r *authSessionRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM auth_sessions WHERE expires_at < ? OR revoked_at < ?`
+	result, err := r.db.ExecContext(ctx, query, before, before)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to delete expired auth sessions")
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func scanAuthSession(row rowScanner) (*models.AuthSession, error) {
+	var session models.AuthSession
+	var revokedAt sql.NullTime
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.DeviceName,
+		&session.IP,
+		&session.UserAgent,
+		&session.CreatedAt,
+		&session.LastActiveAt,
+		&session.ExpiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return &session, nil
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type BlockRepository interface {
+	// Block stores the block and removes any contact or pending contact
+	// request between the two users.
+	Block(ctx context.Context, userID, blockedID int, at time.Time) error
+	Unblock(ctx context.Context, userID, blockedID int) (bool, error)
+	GetBlocked(ctx context.Context, userID int) ([]*models.BlockedUser, error)
+	// FilterBlocked returns the candidates with a block in either direction.
+	FilterBlocked(ctx context.Context, userID int, candidates []int) ([]int, error)
+	// FilterBlockPairs returns the pairs, user_id → blocked_id, that are
+	// blocks. Only the given direction of each pair is looked up.
+	FilterBlockPairs(ctx context.Context, pairs [][2]int) ([][2]int, error)
+}
+
+type blockRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewBlockRepository(db *sql.DB, logger *zerolog.Logger) BlockRepository {
+	return &blockRepository{db: db, logger: logger}
+}
+
+func (r *blockRepository) Block(ctx context.Context, userID, blockedID int, at time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT IGNORE INTO user_blocks (user_id, blocked_id, created_at) VALUES (?, ?, ?)`,
+		userID, blockedID, at)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to block user")
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM contacts WHERE (user_id = ? AND contact_id = ?) OR (user_id = ? AND contact_id = ?)`,
+		userID, blockedID, blockedID, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to remove blocked contact")
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE contact_requests
+		SET status = CASE WHEN sender_id = ? THEN ? ELSE ? END, responded_at = ?
+		WHERE status = ?
+		  AND ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))
+	`, userID, models.ContactRequestCancelled, models.ContactRequestDeclined, at,
+		models.ContactRequestPending, userID, blockedID, blockedID, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to close contact requests of blocked user")
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *blockRepository) Unblock(ctx context.Context, userID, blockedID int) (bool, error) {
+	query := `DELETE FROM user_blocks WHERE user_id = ? AND blocked_id = ?`
+	result, err := r.db.ExecContext(ctx, query, userID, blockedID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to unblock user")
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *blockRepository) GetBlocked(ctx context.Context, userID int) ([]*models.BlockedUser, error) {
+	query := `
+		SELECT b.blocked_id, u.username, b.created_at
+		FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.user_id = ?
+		ORDER BY b.created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get blocked users")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocked []*models.BlockedUser
+	for rows.Next() {
+		var user models.BlockedUser
+		if err := rows.Scan(&user.UserID, &user.Username, &user.BlockedAt); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan blocked user")
+			return nil, err
+		}
+		blocked = append(blocked, &user)
+	}
+	return blocked, rows.Err()
+}
+
+func (r *blockRepository) FilterBlocked(ctx context.Context, userID int, candidates []int) ([]int, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(candidates)), ", ")
+	query := `
+		SELECT blocked_id FROM user_blocks WHERE user_id = ? AND blocked_id IN (` + placeholders + `)
+		UNION
+		SELECT user_id FROM user_blocks WHERE blocked_id = ? AND user_id IN (` + placeholders + `)
+	`
+	args := make([]interface{}, 0, 2*len(candidates)+2)
+	for i := 0; i < 2; i++ {
+		args = append(args, userID)
+		for _, id := range candidates {
+			args = append(args, id)
+		}
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to filter blocked users")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocked []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan blocked user ID")
+			return nil, err
+		}
+		blocked = append(blocked, id)
+	}
+	return blocked, rows.Err()
+}
+
+func (r *blockRepository) FilterBlockPairs(ctx context.Context, pairs [][2]int) ([][2]int, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("(?, ?), ", len(pairs)), ", ")
+	query := `SELECT user_id, blocked_id FROM user_blocks WHERE (user_id, blocked_id) IN (` + placeholders + `)`
+	args := make([]interface{}, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		args = append(args, pair[0], pair[1])
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Int("pairs", len(pairs)).Msg("Failed to filter block pairs")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks [][2]int
+	for rows.Next() {
+		var pair [2]int
+		if err := rows.Scan(&pair[0], &pair[1]); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan block pair")
+			return nil, err
+		}
+		blocks = append(blocks, pair)
+	}
+	return blocks, rows.Err()
+}
@@ -0,0 +1,362 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type ContactRepository interface {
+	// CreateRequest stores a pending request, reusing the row of an earlier
+	// request between the same two users in the same direction.
+	CreateRequest(ctx context.Context, req *models.ContactRequest) error
+	GetRequest(ctx context.Context, requestID int64) (*models.ContactRequest, error)
+	// GetPendingBetween returns the pending request between the users in
+	// either direction.
+	GetPendingBetween(ctx context.Context, userID, otherID int) (*models.ContactRequest, error)
+	GetPendingRequests(ctx context.Context, userID int) ([]*models.ContactRequest, error)
+	// CloseRequest moves a pending request to status. It reports false if the
+	// request was no longer pending.
+	CloseRequest(ctx context.Context, requestID int64, status string, at time.Time) (bool, error)
+	// AcceptRequest closes the request and makes the two users contacts.
+	AcceptRequest(ctx context.Context, req *models.ContactRequest, at time.Time) (bool, error)
+	GetContacts(ctx context.Context, userID int) ([]*models.Contact, error)
+	FilterContacts(ctx context.Context, userID int, candidates []int) ([]int, error)
+	RemoveContact(ctx context.Context, userID, contactID int) (bool, error)
+	// FilterContactPairs returns the pairs, user_id → contact_id, that are
+	// contacts.
+	FilterContactPairs(ctx context.Context, pairs [][2]int) ([][2]int, error)
+	GetMessagesFrom(ctx context.Context, userID int) (string, error)
+	// FilterContactsOnly returns the users who accept messages only from
+	// their contacts.
+	FilterContactsOnly(ctx context.Context, userIDs []int) ([]int, error)
+	SetMessagesFrom(ctx context.Context, userID int, messagesFrom string) error
+}
+
+type contactRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewContactRepository(db *sql.DB, logger *zerolog.Logger) ContactRepository {
+	return &contactRepository{db: db, logger: logger}
+}
+
+const contactRequestColumns = `id, sender_id, receiver_id, status, created_at, responded_at`
+
+func (r *contactRepository) CreateRequest(ctx context.Context, req *models.ContactRequest) error {
+	query := `
+		INSERT INTO contact_requests (sender_id, receiver_id, status, created_at)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			id = LAST_INSERT_ID(id),
+			status = VALUES(status),
+			created_at = VALUES(created_at),
+			responded_at = NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, req.SenderID, req.ReceiverID, req.Status, req.CreatedAt)
+	if err != nil {
+		r.logger.Error().Err(err).Int("sender_id", req.SenderID).Msg("Failed to create contact request")
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to get last insert ID")
+		return err
+	}
+	req.ID = id
+	return nil
+}
+
+func (r *contactRepository) GetRequest(ctx context.Context, requestID int64) (*models.ContactRequest, error) {
+	query := `SELECT ` + contactRequestColumns + ` FROM contact_requests WHERE id = ?`
+	req, err := scanContactRequest(r.db.QueryRowContext(ctx, query, requestID))
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Int64("request_id", requestID).Msg("Failed to get contact request")
+	}
+	return req, err
+}
+
+func (r *contactRepository) GetPendingBetween(ctx context.Context, userID, otherID int) (*models.ContactRequest, error) {
+	query := `
+		SELECT ` + contactRequestColumns + `
+		FROM contact_requests
+		WHERE status = ?
+		  AND ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))
+		LIMIT 1
+	`
+	req, err := scanContactRequest(r.db.QueryRowContext(ctx, query,
+		models.ContactRequestPending, userID, otherID, otherID, userID))
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get pending contact request")
+	}
+	return req, err
+}
+
+func (r *contactRepository) GetPendingRequests(ctx context.Context, userID int) ([]*models.ContactRequest, error) {
+	query := `
+		SELECT ` + contactRequestColumns + `
+		FROM contact_requests
+		WHERE status = ? AND (sender_id = ? OR receiver_id = ?)
+		ORDER BY created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, models.ContactRequestPending, userID, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get contact requests")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []*models.ContactRequest
+	for rows.Next() {
+		req, err := scanContactRequest(rows)
+		if err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan contact request")
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+func (r *contactRepository) CloseRequest(ctx context.Context, requestID int64, status string, at time.Time) (bool, error) {
+	query := `UPDATE contact_requests SET status = ?, responded_at = ? WHERE id = ? AND status = ?`
+	result, err := r.db.ExecContext(ctx, query, status, at, requestID, models.ContactRequestPending)
+	if err != nil {
+		r.logger.Error().Err(err).Int64("request_id", requestID).Msg("Failed to close contact request")
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (r *contactRepository) AcceptRequest(ctx context.Context, req *models.ContactRequest, at time.Time) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE contact_requests SET status = ?, responded_at = ? WHERE id = ? AND status = ?`,
+		models.ContactRequestAccepted, at, req.ID, models.ContactRequestPending)
+	if err != nil {
+		r.logger.Error().Err(err).Int64("request_id", req.ID).Msg("Failed to accept contact request")
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected != 1 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT IGNORE INTO contacts (user_id, contact_id, created_at) VALUES (?, ?, ?), (?, ?, ?)`,
+		req.SenderID, req.ReceiverID, at, req.ReceiverID, req.SenderID, at)
+	if err != nil {
+		r.logger.Error().Err(err).Int64("request_id", req.ID).Msg("Failed to add contacts")
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func (r *contactRepository) GetContacts(ctx context.Context, userID int) ([]*models.Contact, error) {
+	query := `
+		SELECT c.contact_id, u.username, c.created_at
+		FROM contacts c
+		JOIN users u ON u.id = c.contact_id
+		WHERE c.user_id = ?
+		ORDER BY u.username
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get contacts")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []*models.Contact
+	for rows.Next() {
+		var contact models.Contact
+		if err := rows.Scan(&contact.UserID, &contact.Username, &contact.Since); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan contact")
+			return nil, err
+		}
+		contacts = append(contacts, &contact)
+	}
+	return contacts, rows.Err()
+}
+
+func (r *contactRepository) FilterContacts(ctx context.Context, userID int, candidates []int) ([]int, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(candidates)), ", ")
+	query := `SELECT contact_id FROM contacts WHERE user_id = ? AND contact_id IN (` + placeholders + `)`
+	args := make([]interface{}, 0, len(candidates)+1)
+	args = append(args, userID)
+	for _, id := range candidates {
+		args = append(args, id)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to filter contacts")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan contact ID")
+			return nil, err
+		}
+		contacts = append(contacts, id)
+	}
+	return contacts, rows.Err()
+}
+
+func (r *contactRepository) FilterContactPairs(ctx context.Context, pairs [][2]int) ([][2]int, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("(?, ?), ", len(pairs)), ", ")
+	query := `SELECT user_id, contact_id FROM contacts WHERE (user_id, contact_id) IN (` + placeholders + `)`
+	args := make([]interface{}, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		args = append(args, pair[0], pair[1])
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Int("pairs", len(pairs)).Msg("Failed to filter contact pairs")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts [][2]int
+	for rows.Next() {
+		var pair [2]int
+		if err := rows.Scan(&pair[0], &pair[1]); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan contact pair")
+			return nil, err
+		}
+		contacts = append(contacts, pair)
+	}
+	return contacts, rows.Err()
+}
+
+func (r *contactRepository) RemoveContact(ctx context.Context, userID, contactID int) (bool, error) {
+	query := `
+		DELETE FROM contacts
+		WHERE (user_id = ? AND contact_id = ?) OR (user_id = ? AND contact_id = ?)
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, contactID, contactID, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to remove contact")
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// GetMessagesFrom returns the user's setting, VisibilityEveryone by default.
+func (r *contactRepository) GetMessagesFrom(ctx context.Context, userID int) (string, error) {
+	query := `SELECT messages_from FROM contact_settings WHERE user_id = ?`
+	var messagesFrom string
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&messagesFrom)
+	if err == sql.ErrNoRows {
+		return models.VisibilityEveryone, nil
+	}
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get contact settings")
+		return "", err
+	}
+	return messagesFrom, nil
+}
+
+func (r *contactRepository) FilterContactsOnly(ctx context.Context, userIDs []int) ([]int, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(userIDs)), ", ")
+	query := `SELECT user_id FROM contact_settings WHERE messages_from = ? AND user_id IN (` + placeholders + `)`
+	args := make([]interface{}, 0, len(userIDs)+1)
+	args = append(args, models.VisibilityContacts)
+	for _, id := range userIDs {
+		args = append(args, id)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Int("users", len(userIDs)).Msg("Failed to filter contact settings")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var restricted []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan user ID")
+			return nil, err
+		}
+		restricted = append(restricted, id)
+	}
+	return restricted, rows.Err()
+}
+
+func (r *contactRepository) SetMessagesFrom(ctx context.Context, userID int, messagesFrom string) error {
+	query := `
+		INSERT INTO contact_settings (user_id, messages_from) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE messages_from = VALUES(messages_from)
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, messagesFrom)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to update contact settings")
+		return err
+	}
+	return nil
+}
+
+func scanContactRequest(row rowScanner) (*models.ContactRequest, error) {
+	var req models.ContactRequest
+	var respondedAt sql.NullTime
+	err := row.Scan(
+		&req.ID,
+		&req.SenderID,
+		&req.ReceiverID,
+		&req.Status,
+		&req.CreatedAt,
+		&respondedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if respondedAt.Valid {
+		req.RespondedAt = &respondedAt.Time
+	}
+	return &req, nil
+}
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type ConversationRepository interface {
+	// GetConversations lists the user's conversations, most recent first,
+	// with their settings.
+	GetConversations(ctx context.Context, userID, limit int) ([]*models.Conversation, error)
+	GetSettings(ctx context.Context, userID, partnerID int) (*models.ConversationSettings, error)
+	// GetSettingsFor returns the stored settings of the user's conversations
+	// with the partners. Partners without settings are left out.
+	GetSettingsFor(ctx context.Context, userID int, partnerIDs []int) (map[int]*models.ConversationSettings, error)
+	SaveSettings(ctx context.Context, userID, partnerID int, settings *models.ConversationSettings) error
+}
+
+type conversationRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewConversationRepository(db *sql.DB, logger *zerolog.Logger) ConversationRepository {
+	return &conversationRepository{db: db, logger: logger}
+}
+
+func (r *conversationRepository) GetConversations(ctx context.Context, userID, limit int) ([]*models.Conversation, error) {
+	query := `
+		SELECT p.partner_id, u.username, p.last_message_at,
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.sender_id = p.partner_id AND m.receiver_id = ? AND m.status <> 'read' AND NOT m.hidden) AS unread,
+		       s.muted_until, s.notification_level, s.nickname
+		FROM (
+			SELECT partner_id, MAX(last_message) AS last_message_at
+			FROM (
+				SELECT receiver_id AS partner_id, MAX(timestamp) AS last_message
+				FROM messages WHERE sender_id = ? GROUP BY receiver_id
+				UNION ALL
+				SELECT sender_id AS partner_id, MAX(timestamp) AS last_message
+				FROM messages WHERE receiver_id = ? AND NOT hidden GROUP BY sender_id
+			) partners
+			GROUP BY partner_id
+		) p
+		JOIN users u ON u.id = p.partner_id
+		LEFT JOIN conversation_settings s ON s.user_id = ? AND s.partner_id = p.partner_id
+		ORDER BY p.last_message_at DESC
+		LIMIT ?
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID, userID, userID, userID, limit)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get conversations")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []*models.Conversation
+	for rows.Next() {
+		var conversation models.Conversation
+		var mutedUntil sql.NullTime
+		var level, nickname sql.NullString
+		err := rows.Scan(
+			&conversation.UserID,
+			&conversation.Username,
+			&conversation.LastMessageAt,
+			&conversation.Unread,
+			&mutedUntil,
+			&level,
+			&nickname,
+		)
+		if err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan conversation")
+			return nil, err
+		}
+
+		conversation.Settings = models.DefaultConversationSettings()
+		if level.Valid {
+			conversation.Settings = conversationSettings(mutedUntil, level.String, nickname.String)
+		}
+		conversations = append(conversations, &conversation)
+	}
+	return conversations, rows.Err()
+}
+
+// GetSettings returns the defaults when the user has not changed the
+// conversation's settings.
+func (r *conversationRepository) GetSettings(ctx context.Context, userID, partnerID int) (*models.ConversationSettings, error) {
+	query := `
+		SELECT muted_until, notification_level, nickname
+		FROM conversation_settings
+		WHERE user_id = ? AND partner_id = ?
+	`
+	var mutedUntil sql.NullTime
+	var level, nickname string
+	err := r.db.QueryRowContext(ctx, query, userID, partnerID).Scan(&mutedUntil, &level, &nickname)
+	if err == sql.ErrNoRows {
+		return models.DefaultConversationSettings(), nil
+	}
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get conversation settings")
+		return nil, err
+	}
+	return conversationSettings(mutedUntil, level, nickname), nil
+}
+
+func (r *conversationRepository) GetSettingsFor(ctx context.Context, userID int, partnerIDs []int) (map[int]*models.ConversationSettings, error) {
+	if len(partnerIDs) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(partnerIDs)), ", ")
+	query := `
+		SELECT partner_id, muted_until, notification_level, nickname
+		FROM conversation_settings
+		WHERE user_id = ? AND partner_id IN (` + placeholders + `)
+	`
+	args := make([]interface{}, 0, len(partnerIDs)+1)
+	args = append(args, userID)
+	for _, id := range partnerIDs {
+		args = append(args, id)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get conversation settings")
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[int]*models.ConversationSettings)
+	for rows.Next() {
+		var partnerID int
+		var mutedUntil sql.NullTime
+		var level, nickname string
+		if err := rows.Scan(&partnerID, &mutedUntil, &level, &nickname); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan conversation settings")
+			return nil, err
+		}
+		settings[partnerID] = conversationSettings(mutedUntil, level, nickname)
+	}
+	return settings, rows.Err()
+}
+
+func (r *conversationRepository) SaveSettings(ctx context.Context, userID, partnerID int, settings *models.ConversationSettings) error {
+	query := `
+		INSERT INTO conversation_settings (user_id, partner_id, muted_until, notification_level, nickname, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			muted_until = VALUES(muted_until),
+			notification_level = VALUES(notification_level),
+			nickname = VALUES(nickname),
+			updated_at = VALUES(updated_at)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		userID,
+		partnerID,
+		settings.MutedUntil,
+		settings.NotificationLevel,
+		settings.Nickname,
+		time.Now(),
+	)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Int("partner_id", partnerID).Msg("Failed to save conversation settings")
+		return err
+	}
+	return nil
+}
+
+func conversationSettings(mutedUntil sql.NullTime, level, nickname string) *models.ConversationSettings {
+	settings := &models.ConversationSettings{NotificationLevel: level, Nickname: nickname}
+	if mutedUntil.Valid {
+		settings.MutedUntil = &mutedUntil.Time
+	}
+	return settings
+}
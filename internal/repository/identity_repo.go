@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+// IdentityRepository stores pending OIDC logins and the links between
+// external identities and internal users.
+type IdentityRepository interface {
+	CreateState(ctx context.Context, state *models.OIDCState) error
+	ConsumeState(ctx context.Context, stateHash string, now time.Time) (*models.OIDCState, error)
+	GetUserID(ctx context.Context, issuer, subject string) (int, error)
+	Link(ctx context.Context, issuer, subject string, userID int) error
+	DeleteExpiredStates(ctx context.Context, before time.Time) (int64, error)
+}
+
+type identityRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewIdentityRepository(db *sql.DB, logger *zerolog.Logger) IdentityRepository {
+	return &identityRepository{db: db, logger: logger}
+}
+
+func (r *identityRepository) CreateState(ctx context.Context, state *models.OIDCState) error {
+	query := `
+		INSERT INTO oidc_states (state_hash, nonce, code_verifier, binding_hash, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := r.db.ExecContext(ctx, query, state.StateHash, state.Nonce, state.CodeVerifier, state.BindingHash, state.ExpiresAt)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to create OIDC state")
+		return err
+	}
+	return nil
+}
+
+// ConsumeState returns an unexpired login state and deletes it, so each
+// callback can only be completed once. It returns sql.ErrNoRows otherwise.
+func (r *identityRepository) ConsumeState(ctx context.Context, stateHash string, now time.Time) (*models.OIDCState, error) {
+	query := `
+		SELECT state_hash, nonce, code_verifier, binding_hash, expires_at
+		FROM oidc_states
+		WHERE state_hash = ? AND expires_at > ?
+	`
+	var state models.OIDCState
+	err := r.db.QueryRowContext(ctx, query, stateHash, now).Scan(
+		&state.StateHash,
+		&state.Nonce,
+		&state.CodeVerifier,
+		&state.BindingHash,
+		&state.ExpiresAt,
+	)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			r.logger.Error().Err(err).Msg("Failed to get OIDC state")
+		}
+		return nil, err
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM oidc_states WHERE state_hash = ?`, stateHash)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to consume OIDC state")
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &state, nil
+}
+
+func (r *identityRepository) GetUserID(ctx context.Context, issuer, subject string) (int, error) {
+	query := `SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?`
+	var userID int
+	err := r.db.QueryRowContext(ctx, query, issuer, subject).Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Str("issuer", issuer).Msg("Failed to get linked user")
+	}
+	return userID, err
+}
+
+// Link returns ErrUserExists if the identity is already linked.
+func (r *identityRepository) Link(ctx context.Context, issuer, subject string, userID int) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, created_at)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err := r.db.ExecContext(ctx, query, issuer, subject, userID, time.Now())
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return ErrUserExists
+		}
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to link identity")
+		return err
+	}
+	return nil
+}
+
+func (r *identityRepository) DeleteExpiredStates(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM oidc_states WHERE expires_at < ?`, before)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to delete expired OIDC states")
+		return 0, err
+	}
+	return result.RowsAffected()
+}
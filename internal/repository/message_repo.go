@@ -3,7 +3,8 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"time"
+	"encoding/json"
+	"strings"
 
 	"github.com/chatapp/internal/models"
 	"github.com/rs/zerolog"
@@ -11,12 +12,19 @@ import (
 
 type MessageRepository interface {
 	Create(ctx context.Context, message *models.Message) (int64, error)
+	CreateBatch(ctx context.Context, messages []*models.Message) error
 	GetByID(ctx context.Context, id int64) (*models.Message, error)
 	GetConversation(ctx context.Context, user1ID, user2ID int, limit int) ([]*models.Message, error)
 	GetUserMessages(ctx context.Context, userID int, limit int) ([]*models.Message, error)
 	GetUndeliveredMessages(ctx context.Context, userID int) ([]*models.Message, error)
+	// GetMentions returns the messages that mention the user, newest first,
+	// starting below the before ID when it is positive.
+	GetMentions(ctx context.Context, userID int, before int64, limit int) ([]*models.Message, error)
+	GetConversationPartners(ctx context.Context, userID int, limit int) ([]int, error)
+	FilterConversationPartners(ctx context.Context, userID int, candidates []int) ([]int, error)
 	UpdateStatus(ctx context.Context, id int64, status string) error
 	MarkAsDelivered(ctx context.Context, receiverID int) error
+	MarkAsDeliveredByIDs(ctx context.Context, ids []int64) error
 	MarkAsRead(ctx context.Context, senderID, receiverID int) error
 }
 
@@ -29,36 +37,108 @@ func NewMessageRepository(db *sql.DB, logger *zerolog.Logger) MessageRepository
 	return &messageRepository{db: db, logger: logger}
 }
 
+const messageColumns = `id, sender_id, receiver_id, content, entities, timestamp, status`
+
 func (r *messageRepository) Create(ctx context.Context, message *models.Message) (int64, error) {
-	query := `
-		INSERT INTO messages (sender_id, receiver_id, content, timestamp, status)
-		VALUES (?, ?, ?, ?, ?)
-	`
-	result, err := r.db.ExecContext(ctx, query,
-		message.SenderID,
-		message.ReceiverID,
-		message.Content,
-		message.Timestamp,
-		message.Status,
-	)
-	if err != nil {
-		r.logger.Error().Err(err).Msg("Failed to create message")
+	if err := r.CreateBatch(ctx, []*models.Message{message}); err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+	return message.ID, nil
+}
+
+// CreateBatch inserts all messages with a single multi-row INSERT and assigns
+// their IDs in order. InnoDB hands out consecutive auto-increment values for a
+// simple multi-row insert, so the IDs are derived from LastInsertId. Mentions
+// are indexed in the same transaction. Dropped messages are stored hidden from
+// their receiver, and their mentions are not indexed.
+func (r *messageRepository) CreateBatch(ctx context.Context, messages []*models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(messages))
+	args := make([]interface{}, 0, len(messages)*7)
+	for _, message := range messages {
+		entities, err := encodeEntities(message.Entities)
+		if err != nil {
+			return err
+		}
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			message.SenderID,
+			message.ReceiverID,
+			message.Content,
+			entities,
+			message.Timestamp,
+			message.Status,
+			message.Dropped,
+		)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO messages (sender_id, receiver_id, content, entities, timestamp, status, hidden)
+		VALUES ` + strings.Join(placeholders, ", ")
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Int("batch_size", len(messages)).Msg("Failed to create message batch")
+		return err
+	}
+
+	firstID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for i, message := range messages {
+		message.ID = firstID + int64(i)
+	}
+
+	if err := r.insertMentions(ctx, tx, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *messageRepository) insertMentions(ctx context.Context, tx *sql.Tx, messages []*models.Message) error {
+	var placeholders []string
+	var args []interface{}
+	for _, message := range messages {
+		if message.Dropped {
+			continue
+		}
+		for _, entity := range message.Entities {
+			if entity.Type == models.EntityMention && entity.UserID > 0 {
+				placeholders = append(placeholders, "(?, ?)")
+				args = append(args, message.ID, entity.UserID)
+			}
+		}
+	}
+	if len(placeholders) == 0 {
+		return nil
+	}
+
+	query := `INSERT IGNORE INTO message_mentions (message_id, user_id) VALUES ` + strings.Join(placeholders, ", ")
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		r.logger.Error().Err(err).Msg("Failed to index mentions")
+		return err
+	}
+	return nil
 }
 
 func (r *messageRepository) GetByID(ctx context.Context, id int64) (*models.Message, error) {
 	query := `
-		SELECT id, sender_id, receiver_id, content, timestamp, status
+		SELECT ` + messageColumns + `
 		FROM messages
 		WHERE id = ?
 	`
-	row := r.db.QueryRowContext(ctx, query, id)
-
-	var msg models.Message
-	var timestamp time.Time
-	err := row.Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &timestamp, &msg.Status)
+	msg, err := scanMessage(r.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -66,16 +146,16 @@ func (r *messageRepository) GetByID(ctx context.Context, id int64) (*models.Mess
 		r.logger.Error().Err(err).Int64("message_id", id).Msg("Failed to get message by ID")
 		return nil, err
 	}
-
-	msg.Timestamp = timestamp
-	return &msg, nil
+	return msg, nil
 }
 
+// GetConversation returns the conversation as user1 sees it: messages user2
+// sent that were hidden from user1 are left out.
 func (r *messageRepository) GetConversation(ctx context.Context, user1ID, user2ID int, limit int) ([]*models.Message, error) {
 	query := `
-		SELECT id, sender_id, receiver_id, content, timestamp, status
+		SELECT ` + messageColumns + `
 		FROM messages
-		WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)
+		WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ? AND NOT hidden)
 		ORDER BY timestamp DESC
 		LIMIT ?
 	`
@@ -91,15 +171,12 @@ func (r *messageRepository) GetConversation(ctx context.Context, user1ID, user2I
 
 	var messages []*models.Message
 	for rows.Next() {
-		var msg models.Message
-		var timestamp time.Time
-		err := rows.Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &timestamp, &msg.Status)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			r.logger.Error().Err(err).Msg("Failed to scan message row")
 			continue
 		}
-		msg.Timestamp = timestamp
-		messages = append(messages, &msg)
+		messages = append(messages, msg)
 	}
 
 	return messages, nil
@@ -107,9 +184,9 @@ func (r *messageRepository) GetConversation(ctx context.Context, user1ID, user2I
 
 func (r *messageRepository) GetUserMessages(ctx context.Context, userID int, limit int) ([]*models.Message, error) {
 	query := `
-		SELECT id, sender_id, receiver_id, content, timestamp, status
+		SELECT ` + messageColumns + `
 		FROM messages
-		WHERE sender_id = ? OR receiver_id = ?
+		WHERE sender_id = ? OR (receiver_id = ? AND NOT hidden)
 		ORDER BY timestamp DESC
 		LIMIT ?
 	`
@@ -122,15 +199,12 @@ func (r *messageRepository) GetUserMessages(ctx context.Context, userID int, lim
 
 	var messages []*models.Message
 	for rows.Next() {
-		var msg models.Message
-		var timestamp time.Time
-		err := rows.Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &timestamp, &msg.Status)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			r.logger.Error().Err(err).Msg("Failed to scan message row")
 			continue
 		}
-		msg.Timestamp = timestamp
-		messages = append(messages, &msg)
+		messages = append(messages, msg)
 	}
 
 	return messages, nil
@@ -138,9 +212,9 @@ func (r *messageRepository) GetUserMessages(ctx context.Context, userID int, lim
 
 func (r *messageRepository) GetUndeliveredMessages(ctx context.Context, userID int) ([]*models.Message, error) {
 	query := `
-		SELECT id, sender_id, receiver_id, content, timestamp, status
+		SELECT ` + messageColumns + `
 		FROM messages
-		WHERE receiver_id = ? AND status = 'sent'
+		WHERE receiver_id = ? AND status = 'sent' AND NOT hidden
 		ORDER BY timestamp ASC
 	`
 	rows, err := r.db.QueryContext(ctx, query, userID)
@@ -152,20 +226,126 @@ func (r *messageRepository) GetUndeliveredMessages(ctx context.Context, userID i
 
 	var messages []*models.Message
 	for rows.Next() {
-		var msg models.Message
-		var timestamp time.Time
-		err := rows.Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &timestamp, &msg.Status)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			r.logger.Error().Err(err).Msg("Failed to scan message row")
 			continue
 		}
-		msg.Timestamp = timestamp
-		messages = append(messages, &msg)
+		messages = append(messages, msg)
 	}
 
 	return messages, nil
 }
 
+func (r *messageRepository) GetMentions(ctx context.Context, userID int, before int64, limit int) ([]*models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE id IN (
+			SELECT message_id FROM message_mentions
+			WHERE user_id = ? AND (? <= 0 OR message_id < ?)
+		)
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID, before, before, limit)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get mentions")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan message row")
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// GetConversationPartners returns the users the given user has exchanged
+// messages with, most recent conversation first.
+func (r *messageRepository) GetConversationPartners(ctx context.Context, userID int, limit int) ([]int, error) {
+	query := `
+		SELECT partner_id
+		FROM (
+			SELECT receiver_id AS partner_id, MAX(timestamp) AS last_message
+			FROM messages WHERE sender_id = ? GROUP BY receiver_id
+			UNION ALL
+			SELECT sender_id AS partner_id, MAX(timestamp) AS last_message
+			FROM messages WHERE receiver_id = ? AND NOT hidden GROUP BY sender_id
+		) partners
+		GROUP BY partner_id
+		ORDER BY MAX(last_message) DESC
+		LIMIT ?
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID, userID, limit)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get conversation partners")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partners []int
+	for rows.Next() {
+		var partnerID int
+		if err := rows.Scan(&partnerID); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan conversation partner row")
+			continue
+		}
+		partners = append(partners, partnerID)
+	}
+
+	return partners, nil
+}
+
+// FilterConversationPartners returns the candidates that have exchanged at
+// least one message with the user.
+func (r *messageRepository) FilterConversationPartners(ctx context.Context, userID int, candidates []int) ([]int, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(candidates)), ", ")
+	args := make([]interface{}, 0, len(candidates)*2+2)
+	args = append(args, userID)
+	for _, id := range candidates {
+		args = append(args, id)
+	}
+	args = append(args, userID)
+	for _, id := range candidates {
+		args = append(args, id)
+	}
+
+	query := `
+		SELECT receiver_id FROM messages WHERE sender_id = ? AND receiver_id IN (` + placeholders + `)
+		UNION
+		SELECT sender_id FROM messages WHERE receiver_id = ? AND NOT hidden AND sender_id IN (` + placeholders + `)
+	`
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to filter conversation partners")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partners []int
+	for rows.Next() {
+		var partnerID int
+		if err := rows.Scan(&partnerID); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan conversation partner row")
+			continue
+		}
+		partners = append(partners, partnerID)
+	}
+
+	return partners, nil
+}
+
 func (r *messageRepository) UpdateStatus(ctx context.Context, id int64, status string) error {
 	query := `UPDATE messages SET status = ? WHERE id = ?`
 	_, err := r.db.ExecContext(ctx, query, status, id)
@@ -177,7 +357,7 @@ func (r *messageRepository) UpdateStatus(ctx context.Context, id int64, status s
 }
 
 func (r *messageRepository) MarkAsDelivered(ctx context.Context, receiverID int) error {
-	query := `UPDATE messages SET status = 'delivered' WHERE receiver_id = ? AND status = 'sent'`
+	query := `UPDATE messages SET status = 'delivered' WHERE receiver_id = ? AND status = 'sent' AND NOT hidden`
 	_, err := r.db.ExecContext(ctx, query, receiverID)
 	if err != nil {
 		r.logger.Error().Err(err).Int("receiver_id", receiverID).Msg("Failed to mark messages as delivered")
@@ -186,11 +366,31 @@ func (r *messageRepository) MarkAsDelivered(ctx context.Context, receiverID int)
 	return nil
 }
 
+func (r *messageRepository) MarkAsDeliveredByIDs(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	query := `UPDATE messages SET status = 'delivered' WHERE status = 'sent' AND id IN (` + placeholders + `)`
+	_, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Int("batch_size", len(ids)).Msg("Failed to mark message batch as delivered")
+		return err
+	}
+	return nil
+}
+
 func (r *messageRepository) MarkAsRead(ctx context.Context, senderID, receiverID int) error {
 	query := `
 		UPDATE messages 
 		SET status = 'read' 
-		WHERE sender_id = ? AND receiver_id = ? AND status = 'delivered'
+		WHERE sender_id = ? AND receiver_id = ? AND status = 'delivered' AND NOT hidden
 	`
 	_, err := r.db.ExecContext(ctx, query, senderID, receiverID)
 	if err != nil {
@@ -202,3 +402,31 @@ func (r *messageRepository) MarkAsRead(ctx context.Context, senderID, receiverID
 	}
 	return nil
 }
+
+func scanMessage(row rowScanner) (*models.Message, error) {
+	var msg models.Message
+	var entities []byte
+	err := row.Scan(&msg.ID, &msg.SenderID, &msg.ReceiverID, &msg.Content, &entities, &msg.Timestamp, &msg.Status)
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) > 0 {
+		if err := json.Unmarshal(entities, &msg.Entities); err != nil {
+			return nil, err
+		}
+	}
+	return &msg, nil
+}
+
+// encodeEntities returns the value stored in the entities column: NULL when
+// the message has none.
+func encodeEntities(entities []models.MessageEntity) (interface{}, error) {
+	if len(entities) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(entities)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
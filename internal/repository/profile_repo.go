@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type ProfileRepository interface {
+	// Get returns the user's profile, empty if they never set one. It
+	// returns sql.ErrNoRows if the user does not exist.
+	Get(ctx context.Context, userID int) (*models.Profile, error)
+	Save(ctx context.Context, profile *models.Profile) error
+	// Search matches the query against usernames and display names. Prefix
+	// matches come first, then matches anywhere in the name.
+	Search(ctx context.Context, query string, limit int) ([]*models.Profile, error)
+}
+
+type profileRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewProfileRepository(db *sql.DB, logger *zerolog.Logger) ProfileRepository {
+	return &profileRepository{db: db, logger: logger}
+}
+
+const profileColumns = `
+	u.id, u.username,
+	COALESCE(p.display_name, ''), COALESCE(p.avatar_url, ''),
+	COALESCE(p.bio, ''), COALESCE(p.timezone, ''), p.updated_at`
+
+func (r *profileRepository) Get(ctx context.Context, userID int) (*models.Profile, error) {
+	query := `
+		SELECT ` + profileColumns + `
+		FROM users u
+		LEFT JOIN user_profiles p ON p.user_id = u.id
+		WHERE u.id = ?
+	`
+	profile, err := scanProfile(r.db.QueryRowContext(ctx, query, userID))
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get profile")
+	}
+	return profile, err
+}
+
+func (r *profileRepository) Save(ctx context.Context, profile *models.Profile) error {
+	query := `
+		INSERT INTO user_profiles (user_id, display_name, avatar_url, bio, timezone, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			display_name = VALUES(display_name),
+			avatar_url = VALUES(avatar_url),
+			bio = VALUES(bio),
+			timezone = VALUES(timezone),
+			updated_at = VALUES(updated_at)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		profile.UserID,
+		profile.DisplayName,
+		profile.AvatarURL,
+		profile.Bio,
+		profile.Timezone,
+		profile.UpdatedAt,
+	)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", profile.UserID).Msg("Failed to save profile")
+		return err
+	}
+	return nil
+}
+
+func (r *profileRepository) Search(ctx context.Context, query string, limit int) ([]*models.Profile, error) {
+	escaped := likeEscaper.Replace(query)
+	prefix := escaped + "%"
+	contains := "%" + escaped + "%"
+
+	sqlQuery := `
+		SELECT ` + profileColumns + `
+		FROM users u
+		LEFT JOIN user_profiles p ON p.user_id = u.id
+		WHERE u.username LIKE ? OR p.display_name LIKE ?
+		ORDER BY
+			CASE WHEN u.username LIKE ? OR p.display_name LIKE ? THEN 0 ELSE 1 END,
+			u.username
+		LIMIT ?
+	`
+	rows, err := r.db.QueryContext(ctx, sqlQuery, contains, contains, prefix, prefix, limit)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to search profiles")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []*models.Profile
+	for rows.Next() {
+		profile, err := scanProfile(rows)
+		if err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan profile")
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
+
+// likeEscaper escapes the LIKE wildcards in user input.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func scanProfile(row rowScanner) (*models.Profile, error) {
+	var profile models.Profile
+	var updatedAt sql.NullTime
+	err := row.Scan(
+		&profile.UserID,
+		&profile.Username,
+		&profile.DisplayName,
+		&profile.AvatarURL,
+		&profile.Bio,
+		&profile.Timezone,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if updatedAt.Valid {
+		profile.UpdatedAt = &updatedAt.Time
+	}
+	return &profile, nil
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *models.Session) error
+	Delete(ctx context.Context, sessionID string) error
+	Touch(ctx context.Context, sessionIDs []string, at time.Time) error
+	TouchInput(ctx context.Context, sessionIDs []string, at time.Time) error
+	CountActiveByUser(ctx context.Context, userID int, since time.Time) (int, error)
+	// LastInputByUser returns the latest input among the user's sessions that
+	// heartbeated since the cutoff, or the zero time if there are none.
+	LastInputByUser(ctx context.Context, userID int, since time.Time) (time.Time, error)
+	HeartbeatNode(ctx context.Context, nodeID string, at time.Time) error
+	DeleteByNode(ctx context.Context, nodeID string) ([]int, error)
+	DeleteExpired(ctx context.Context, before time.Time) ([]int, error)
+}
+
+type sessionRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewSessionRepository(db *sql.DB, logger *zerolog.Logger) SessionRepository {
+	return &sessionRepository{db: db, logger: logger}
+}
+
+func (r *sessionRepository) Create(ctx context.Context, session *models.Session) error {
+	query := `
+		INSERT INTO user_sessions (session_id, user_id, node_id, connected_at, last_heartbeat, last_input)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		session.ID,
+		session.UserID,
+		session.NodeID,
+		session.ConnectedAt,
+		session.LastHeartbeat,
+		session.LastInput,
+	)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", session.UserID).Msg("Failed to create session")
+		return err
+	}
+	return nil
+}
+
+func (r *sessionRepository) Delete(ctx context.Context, sessionID string) error {
+	query := `DELETE FROM user_sessions WHERE session_id = ?`
+	_, err := r.db.ExecContext(ctx, query, sessionID)
+	if err != nil {
+		r.logger.Error().Err(err).Str("session_id", sessionID).Msg("Failed to delete session")
+		return err
+	}
+	return nil
+}
+
+func (r *sessionRepository) Touch(ctx context.Context, sessionIDs []string, at time.Time) error {
+	if err := r.setTime(ctx, "last_heartbeat", sessionIDs, at); err != nil {
+		r.logger.Error().Err(err).Int("batch_size", len(sessionIDs)).Msg("Failed to refresh session heartbeats")
+		return err
+	}
+	return nil
+}
+
+func (r *sessionRepository) TouchInput(ctx context.Context, sessionIDs []string, at time.Time) error {
+	if err := r.setTime(ctx, "last_input", sessionIDs, at); err != nil {
+		r.logger.Error().Err(err).Int("batch_size", len(sessionIDs)).Msg("Failed to record session input")
+		return err
+	}
+	return nil
+}
+
+// setTime sets a timestamp column of the sessions. column is never user input.
+func (r *sessionRepository) setTime(ctx context.Context, column string, sessionIDs []string, at time.Time) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(sessionIDs)), ", ")
+	args := make([]interface{}, 0, len(sessionIDs)+1)
+	args = append(args, at)
+	for _, id := range sessionIDs {
+		args = append(args, id)
+	}
+
+	query := `UPDATE user_sessions SET ` + column + ` = ? WHERE session_id IN (` + placeholders + `)`
+	_, err := r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *sessionRepository) CountActiveByUser(ctx context.Context, userID int, since time.Time) (int, error) {
+	query := `SELECT COUNT(*) FROM user_sessions WHERE user_id = ? AND last_heartbeat >= ?`
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, userID, since).Scan(&count); err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to count active sessions")
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *sessionRepository) LastInputByUser(ctx context.Context, userID int, since time.Time) (time.Time, error) {
+	query := `
+		SELECT MAX(COALESCE(last_input, connected_at))
+		FROM user_sessions
+		WHERE user_id = ? AND last_heartbeat >= ?
+	`
+	var lastInput sql.NullTime
+	if err := r.db.QueryRowContext(ctx, query, userID, since).Scan(&lastInput); err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get last session input")
+		return time.Time{}, err
+	}
+	return lastInput.Time, nil
+}
+
+func (r *sessionRepository) HeartbeatNode(ctx context.Context, nodeID string, at time.Time) error {
+	query := `
+		INSERT INTO presence_nodes (node_id, last_heartbeat)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE
+		last_heartbeat = VALUES(last_heartbeat)
+	`
+	_, err := r.db.ExecContext(ctx, query, nodeID, at)
+	if err != nil {
+		r.logger.Error().Err(err).Str("node_id", nodeID).Msg("Failed to record node heartbeat")
+		return err
+	}
+	return nil
+}
+
+// DeleteByNode removes every session owned by the node, and the node itself,
+// returning the distinct users that had a session there.
+func (r *sessionRepository) DeleteByNode(ctx context.Context, nodeID string) ([]int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	userIDs, err := queryUserIDs(ctx, tx,
+		`SELECT DISTINCT user_id FROM user_sessions WHERE node_id = ? FOR UPDATE`, nodeID)
+	if err != nil {
+		r.logger.Error().Err(err).Str("node_id", nodeID).Msg("Failed to list node sessions")
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_sessions WHERE node_id = ?`, nodeID); err != nil {
+		r.logger.Error().Err(err).Str("node_id", nodeID).Msg("Failed to delete node sessions")
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM presence_nodes WHERE node_id = ?`, nodeID); err != nil {
+		r.logger.Error().Err(err).Str("node_id", nodeID).Msg("Failed to delete node")
+		return nil, err
+	}
+
+	return userIDs, tx.Commit()
+}
+
+// DeleteExpired removes sessions that stopped heartbeating before the cutoff
+// and every session of nodes that did, returning the affected users.
+func (r *sessionRepository) DeleteExpired(ctx context.Context, before time.Time) ([]int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	userIDs, err := queryUserIDs(ctx, tx, `
+		SELECT DISTINCT s.user_id
+		FROM user_sessions s
+		LEFT JOIN presence_nodes n ON n.node_id = s.node_id
+		WHERE s.last_heartbeat < ? OR n.last_heartbeat < ?
+		FOR UPDATE
+	`, before, before)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to list expired sessions")
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE s FROM user_sessions s
+		LEFT JOIN presence_nodes n ON n.node_id = s.node_id
+		WHERE s.last_heartbeat < ? OR n.last_heartbeat < ?
+	`, before, before)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to delete expired sessions")
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM presence_nodes WHERE last_heartbeat < ?`, before); err != nil {
+		r.logger.Error().Err(err).Msg("Failed to delete expired nodes")
+		return nil, err
+	}
+
+	return userIDs, tx.Commit()
+}
+
+func queryUserIDs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
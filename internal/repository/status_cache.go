@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chatapp/internal/models"
+)
+
+// StatusInvalidator is implemented by status repositories that keep a local
+// copy of the data and must be told when another node changed it.
+type StatusInvalidator interface {
+	Invalidate(userIDs ...int)
+}
+
+type StatusCacheStats struct {
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+	Entries  int     `json:"entries"`
+}
+
+type cachedStatus struct {
+	status    *models.UserStatus
+	expiresAt time.Time
+}
+
+// StatusCache serves presence reads from memory in front of another
+// StatusRepository. Writes go through to the underlying repository and then
+// update or drop the cached entry; entries also expire after a TTL to bound
+// staleness if an invalidation is missed.
+type StatusCache interface {
+	StatusRepository
+	StatusInvalidator
+	Stats() StatusCacheStats
+}
+
+type statusCache struct {
+	next       StatusRepository
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.RWMutex
+	entries map[int]cachedStatus
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewStatusCache(next StatusRepository, ttl time.Duration, maxEntries int) StatusCache {
+	return &statusCache{
+		next:       next,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[int]cachedStatus),
+	}
+}
+
+func (c *statusCache) Update(ctx context.Context, status *models.UserStatus) error {
+	if err := c.next.Update(ctx, status); err != nil {
+		c.Invalidate(status.UserID)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[status.UserID]; ok {
+		updated := cloneStatus(entry.status)
+		updated.Status = status.Status
+		updated.LastSeen = status.LastSeen
+		c.entries[status.UserID] = cachedStatus{status: updated, expiresAt: time.Now().Add(c.ttl)}
+	}
+	return nil
+}
+
+func (c *statusCache) UpdateCustom(ctx context.Context, status *models.UserStatus) error {
+	defer c.Invalidate(status.UserID)
+	return c.next.UpdateCustom(ctx, status)
+}
+
+func (c *statusCache) UpdatePrivacy(ctx context.Context, userID int, settings *models.PrivacySettings) error {
+	defer c.Invalidate(userID)
+	return c.next.UpdatePrivacy(ctx, userID, settings)
+}
+
+func (c *statusCache) GetByUserID(ctx context.Context, userID int) (*models.UserStatus, error) {
+	if status, ok := c.lookup(userID); ok {
+		c.hits.Add(1)
+		return status, nil
+	}
+	c.misses.Add(1)
+
+	status, err := c.next.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	c.store(status)
+	return cloneStatus(status), nil
+}
+
+func (c *statusCache) GetByUserIDs(ctx context.Context, userIDs []int) ([]*models.UserStatus, error) {
+	statuses := make([]*models.UserStatus, len(userIDs))
+	var missing []int
+	var missingIdx []int
+	for i, userID := range userIDs {
+		if status, ok := c.lookup(userID); ok {
+			statuses[i] = status
+			continue
+		}
+		missing = append(missing, userID)
+		missingIdx = append(missingIdx, i)
+	}
+	c.hits.Add(uint64(len(userIDs) - len(missing)))
+	c.misses.Add(uint64(len(missing)))
+
+	if len(missing) > 0 {
+		loaded, err := c.next.GetByUserIDs(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for i, status := range loaded {
+			c.store(status)
+			statuses[missingIdx[i]] = cloneStatus(status)
+		}
+	}
+	return statuses, nil
+}
+
+func (c *statusCache) ClearExpiredCustom(ctx context.Context, now time.Time) ([]int, error) {
+	userIDs, err := c.next.ClearExpiredCustom(ctx, now)
+	c.Invalidate(userIDs...)
+	return userIDs, err
+}
+
+func (c *statusCache) Invalidate(userIDs ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, userID := range userIDs {
+		delete(c.entries, userID)
+	}
+}
+
+func (c *statusCache) Stats() StatusCacheStats {
+	c.mu.RLock()
+	entries := len(c.entries)
+	c.mu.RUnlock()
+
+	stats := StatusCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Entries: entries}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+func (c *statusCache) lookup(userID int) (*models.UserStatus, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[userID]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return cloneStatus(entry.status), true
+}
+
+func (c *statusCache) store(status *models.UserStatus) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		for userID, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, userID)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			c.entries = make(map[int]cachedStatus)
+		}
+	}
+	c.entries[status.UserID] = cachedStatus{status: cloneStatus(status), expiresAt: now.Add(c.ttl)}
+}
+
+// cloneStatus copies the status so callers may modify what they get back
+// without touching the cached entry.
+func cloneStatus(status *models.UserStatus) *models.UserStatus {
+	clone := *status
+	if status.StatusExpiresAt != nil {
+		expiresAt := *status.StatusExpiresAt
+		clone.StatusExpiresAt = &expiresAt
+	}
+	if status.Privacy != nil {
+		privacy := *status.Privacy
+		clone.Privacy = &privacy
+	}
+	return &clone
+}
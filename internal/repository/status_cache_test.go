@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+)
+
+// countingStatusRepo stands in for MySQL and counts the reads that reach it.
+type countingStatusRepo struct {
+	StatusRepository
+	statuses map[int]*models.UserStatus
+	reads    int
+}
+
+func newCountingStatusRepo() *countingStatusRepo {
+	return &countingStatusRepo{statuses: make(map[int]*models.UserStatus)}
+}
+
+func (r *countingStatusRepo) GetByUserID(ctx context.Context, userID int) (*models.UserStatus, error) {
+	r.reads++
+	return r.load(userID), nil
+}
+
+func (r *countingStatusRepo) load(userID int) *models.UserStatus {
+	if status, ok := r.statuses[userID]; ok {
+		return cloneStatus(status)
+	}
+	return &models.UserStatus{UserID: userID, Status: models.StatusOffline, Privacy: defaultPrivacy()}
+}
+
+func (r *countingStatusRepo) GetByUserIDs(ctx context.Context, userIDs []int) ([]*models.UserStatus, error) {
+	statuses := make([]*models.UserStatus, 0, len(userIDs))
+	for _, userID := range userIDs {
+		status, _ := r.GetByUserID(ctx, userID)
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (r *countingStatusRepo) Update(ctx context.Context, status *models.UserStatus) error {
+	current := r.load(status.UserID)
+	current.Status = status.Status
+	current.LastSeen = status.LastSeen
+	r.statuses[status.UserID] = current
+	return nil
+}
+
+func (r *countingStatusRepo) UpdatePrivacy(ctx context.Context, userID int, settings *models.PrivacySettings) error {
+	current := r.load(userID)
+	privacy := *settings
+	current.Privacy = &privacy
+	r.statuses[userID] = current
+	return nil
+}
+
+func TestStatusCacheServesReadsFromMemory(t *testing.T) {
+	ctx := context.Background()
+	next := newCountingStatusRepo()
+	cache := NewStatusCache(next, time.Minute, 100)
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetByUserID(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next.reads != 1 {
+		t.Fatalf("underlying reads = %d, want 1", next.reads)
+	}
+
+	statuses, err := cache.GetByUserIDs(ctx, []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].UserID != 1 || statuses[1].UserID != 2 {
+		t.Fatalf("GetByUserIDs returned users %d, %d", statuses[0].UserID, statuses[1].UserID)
+	}
+	if next.reads != 2 {
+		t.Fatalf("underlying reads = %d, want only the missing user loaded", next.reads)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 3 || stats.Misses != 2 || stats.Entries != 2 || stats.HitRatio != 0.6 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestStatusCacheWritesThrough(t *testing.T) {
+	ctx := context.Background()
+	next := newCountingStatusRepo()
+	cache := NewStatusCache(next, time.Minute, 100)
+	cache.GetByUserID(ctx, 1)
+
+	now := time.Now()
+	if err := cache.Update(ctx, &models.UserStatus{UserID: 1, Status: models.StatusOnline, LastSeen: now}); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := cache.GetByUserID(ctx, 1)
+	if status.Status != models.StatusOnline || next.statuses[1].Status != models.StatusOnline {
+		t.Fatal("update did not reach both the cache and the repository")
+	}
+	if next.reads != 1 {
+		t.Fatalf("underlying reads = %d, want the update served from memory", next.reads)
+	}
+
+	// Privacy changes drop the entry instead of patching it.
+	settings := &models.PrivacySettings{OnlineStatus: models.VisibilityNobody, LastSeen: models.VisibilityNobody}
+	if err := cache.UpdatePrivacy(ctx, 1, settings); err != nil {
+		t.Fatal(err)
+	}
+	status, _ = cache.GetByUserID(ctx, 1)
+	if status.Privacy.OnlineStatus != models.VisibilityNobody || next.reads != 2 {
+		t.Fatalf("privacy = %+v after %d reads, want a reload", status.Privacy, next.reads)
+	}
+}
+
+func TestStatusCacheInvalidationAndExpiry(t *testing.T) {
+	ctx := context.Background()
+	next := newCountingStatusRepo()
+	cache := NewStatusCache(next, time.Minute, 100)
+	cache.GetByUserID(ctx, 1)
+
+	// Another node changed the status.
+	next.statuses[1] = &models.UserStatus{UserID: 1, Status: models.StatusOnline, Privacy: defaultPrivacy()}
+	cache.Invalidate(1)
+	if status, _ := cache.GetByUserID(ctx, 1); status.Status != models.StatusOnline {
+		t.Fatalf("status after invalidation = %q, want online", status.Status)
+	}
+
+	short := NewStatusCache(next, time.Millisecond, 100)
+	short.GetByUserID(ctx, 1)
+	time.Sleep(5 * time.Millisecond)
+	reads := next.reads
+	short.GetByUserID(ctx, 1)
+	if next.reads != reads+1 {
+		t.Fatal("expired entry was served")
+	}
+}
+
+func TestStatusCacheReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	cache := NewStatusCache(newCountingStatusRepo(), time.Minute, 100)
+
+	status, _ := cache.GetByUserID(ctx, 1)
+	status.Status = models.StatusOnline
+	status.Privacy.LastSeen = models.VisibilityNobody
+
+	cached, _ := cache.GetByUserID(ctx, 1)
+	if cached.Status != models.StatusOffline || cached.Privacy.LastSeen != models.VisibilityEveryone {
+		t.Fatal("changing a returned status changed the cached entry")
+	}
+}
+
+func TestStatusCacheBoundsEntries(t *testing.T) {
+	ctx := context.Background()
+	cache := NewStatusCache(newCountingStatusRepo(), time.Minute, 10)
+	for userID := 1; userID <= 25; userID++ {
+		cache.GetByUserID(ctx, userID)
+	}
+	if entries := cache.Stats().Entries; entries > 10 {
+		t.Fatalf("cache holds %d entries, want at most 10", entries)
+	}
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type StatusEventRepository interface {
+	Create(ctx context.Context, event *models.StatusEvent) error
+	GetRange(ctx context.Context, userID int, from, to time.Time) ([]*models.StatusEvent, error)
+	// GetLastBefore returns the user's latest event before the time whose
+	// status is one of statuses, or nil if there is none.
+	GetLastBefore(ctx context.Context, userID int, before time.Time, statuses []string) (*models.StatusEvent, error)
+	DeleteBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+type statusEventRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewStatusEventRepository(db *sql.DB, logger *zerolog.Logger) StatusEventRepository {
+	return &statusEventRepository{db: db, logger: logger}
+}
+
+func (r *statusEventRepository) Create(ctx context.Context, event *models.StatusEvent) error {
+	query := `INSERT INTO status_events (user_id, status, occurred_at) VALUES (?, ?, ?)`
+	result, err := r.db.ExecContext(ctx, query, event.UserID, event.Status, event.OccurredAt)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", event.UserID).Msg("Failed to record status event")
+		return err
+	}
+
+	event.ID, err = result.LastInsertId()
+	return err
+}
+
+func (r *statusEventRepository) GetRange(ctx context.Context, userID int, from, to time.Time) ([]*models.StatusEvent, error) {
+	query := `
+		SELECT id, user_id, status, occurred_at
+		FROM status_events
+		WHERE user_id = ? AND occurred_at >= ? AND occurred_at < ?
+		ORDER BY occurred_at ASC, id ASC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID, from, to)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get status events")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.StatusEvent
+	for rows.Next() {
+		var event models.StatusEvent
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Status, &event.OccurredAt); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan status event row")
+			continue
+		}
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+func (r *statusEventRepository) GetLastBefore(ctx context.Context, userID int, before time.Time, statuses []string) (*models.StatusEvent, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(statuses)), ", ")
+	query := `
+		SELECT id, user_id, status, occurred_at
+		FROM status_events
+		WHERE user_id = ? AND occurred_at < ? AND status IN (` + placeholders + `)
+		ORDER BY occurred_at DESC, id DESC
+		LIMIT 1
+	`
+	args := []interface{}{userID, before}
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+
+	var event models.StatusEvent
+	err := r.db.QueryRowContext(ctx, query, args...).
+		Scan(&event.ID, &event.UserID, &event.Status, &event.OccurredAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get last status event")
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (r *statusEventRepository) DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM status_events WHERE occurred_at < ?`
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to purge status events")
+		return 0, err
+	}
+	return result.RowsAffected()
+}
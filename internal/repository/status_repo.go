@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/chatapp/internal/models"
@@ -11,9 +12,11 @@ import (
 
 type StatusRepository interface {
 	Update(ctx context.Context, status *models.UserStatus) error
+	UpdateCustom(ctx context.Context, status *models.UserStatus) error
 	GetByUserID(ctx context.Context, userID int) (*models.UserStatus, error)
-	GetAll(ctx context.Context) ([]*models.UserStatus, error)
-	UpdateAllOffline(ctx context.Context) error
+	GetByUserIDs(ctx context.Context, userIDs []int) ([]*models.UserStatus, error)
+	UpdatePrivacy(ctx context.Context, userID int, settings *models.PrivacySettings) error
+	ClearExpiredCustom(ctx context.Context, now time.Time) ([]int, error)
 }
 
 type statusRepository struct {
@@ -25,6 +28,9 @@ func NewStatusRepository(db *sql.DB, logger *zerolog.Logger) StatusRepository {
 	return &statusRepository{db: db, logger: logger}
 }
 
+const statusColumns = `user_id, status, last_seen, manual_status, status_text, status_emoji, status_expires_at,
+	online_visibility, last_seen_visibility`
+
 func (r *statusRepository) Update(ctx context.Context, status *models.UserStatus) error {
 	query := `
 		INSERT INTO user_status (user_id, status, last_seen)
@@ -45,60 +51,186 @@ func (r *statusRepository) Update(ctx context.Context, status *models.UserStatus
 	return nil
 }
 
+// UpdateCustom stores the user-chosen part of the status without touching
+// the connection-driven status.
+func (r *statusRepository) UpdateCustom(ctx context.Context, status *models.UserStatus) error {
+	query := `
+		INSERT INTO user_status (user_id, status, last_seen, manual_status, status_text, status_emoji, status_expires_at)
+		VALUES (?, 'offline', ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+		manual_status = VALUES(manual_status),
+		status_text = VALUES(status_text),
+		status_emoji = VALUES(status_emoji),
+		status_expires_at = VALUES(status_expires_at)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		status.UserID,
+		time.Now(),
+		nullString(status.ManualStatus),
+		nullString(status.StatusText),
+		nullString(status.StatusEmoji),
+		status.StatusExpiresAt,
+	)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", status.UserID).Msg("Failed to update custom status")
+		return err
+	}
+	return nil
+}
+
 func (r *statusRepository) GetByUserID(ctx context.Context, userID int) (*models.UserStatus, error) {
-	query := `SELECT user_id, status, last_seen FROM user_status WHERE user_id = ?`
+	query := `SELECT ` + statusColumns + ` FROM user_status WHERE user_id = ?`
 	row := r.db.QueryRowContext(ctx, query, userID)
 
-	var status models.UserStatus
-	var lastSeen time.Time
-	err := row.Scan(&status.UserID, &status.Status, &lastSeen)
+	status, err := scanStatus(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return &models.UserStatus{
 				UserID:   userID,
-				Status:   "offline",
+				Status:   models.StatusOffline,
 				LastSeen: time.Now(),
+				Privacy:  defaultPrivacy(),
 			}, nil
 		}
 		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get user status")
 		return nil, err
 	}
 
-	status.LastSeen = lastSeen
-	return &status, nil
+	return status, nil
 }
 
-func (r *statusRepository) GetAll(ctx context.Context) ([]*models.UserStatus, error) {
-	query := `SELECT user_id, status, last_seen FROM user_status`
-	rows, err := r.db.QueryContext(ctx, query)
+// GetByUserIDs returns one status per requested user, in request order.
+// Users without a row are reported offline.
+func (r *statusRepository) GetByUserIDs(ctx context.Context, userIDs []int) ([]*models.UserStatus, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(userIDs)), ", ")
+	args := make([]interface{}, len(userIDs))
+	for i, id := range userIDs {
+		args[i] = id
+	}
+
+	query := `SELECT ` + statusColumns + ` FROM user_status WHERE user_id IN (` + placeholders + `)`
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		r.logger.Error().Err(err).Msg("Failed to get all user statuses")
+		r.logger.Error().Err(err).Int("batch_size", len(userIDs)).Msg("Failed to get user statuses")
 		return nil, err
 	}
 	defer rows.Close()
 
-	var statuses []*models.UserStatus
+	found := make(map[int]*models.UserStatus, len(userIDs))
 	for rows.Next() {
-		var status models.UserStatus
-		var lastSeen time.Time
-		err := rows.Scan(&status.UserID, &status.Status, &lastSeen)
+		status, err := scanStatus(rows)
 		if err != nil {
 			r.logger.Error().Err(err).Msg("Failed to scan user status row")
 			continue
 		}
-		status.LastSeen = lastSeen
-		statuses = append(statuses, &status)
+		found[status.UserID] = status
 	}
 
+	statuses := make([]*models.UserStatus, 0, len(userIDs))
+	for _, userID := range userIDs {
+		status, ok := found[userID]
+		if !ok {
+			status = &models.UserStatus{UserID: userID, Status: models.StatusOffline, LastSeen: time.Now(), Privacy: defaultPrivacy()}
+		}
+		statuses = append(statuses, status)
+	}
 	return statuses, nil
 }
 
-func (r *statusRepository) UpdateAllOffline(ctx context.Context) error {
-	query := `UPDATE user_status SET status = 'offline', last_seen = ? WHERE status = 'online'`
-	_, err := r.db.ExecContext(ctx, query, time.Now())
+func (r *statusRepository) UpdatePrivacy(ctx context.Context, userID int, settings *models.PrivacySettings) error {
+	query := `
+		INSERT INTO user_status (user_id, status, last_seen, online_visibility, last_seen_visibility)
+		VALUES (?, 'offline', ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+		online_visibility = VALUES(online_visibility),
+		last_seen_visibility = VALUES(last_seen_visibility)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		userID,
+		time.Now(),
+		nullString(settings.OnlineStatus),
+		nullString(settings.LastSeen),
+	)
 	if err != nil {
-		r.logger.Error().Err(err).Msg("Failed to update all users to offline")
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to update privacy settings")
 		return err
 	}
 	return nil
 }
+
+// ClearExpiredCustom resets custom statuses whose expiry has passed and
+// returns the users they belonged to.
+func (r *statusRepository) ClearExpiredCustom(ctx context.Context, now time.Time) ([]int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	userIDs, err := queryUserIDs(ctx, tx,
+		`SELECT user_id FROM user_status WHERE status_expires_at <= ? FOR UPDATE`, now)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to list expired custom statuses")
+		return nil, err
+	}
+
+	query := `
+		UPDATE user_status
+		SET manual_status = NULL, status_text = NULL, status_emoji = NULL, status_expires_at = NULL
+		WHERE status_expires_at <= ?
+	`
+	if _, err := tx.ExecContext(ctx, query, now); err != nil {
+		r.logger.Error().Err(err).Msg("Failed to clear expired custom statuses")
+		return nil, err
+	}
+
+	return userIDs, tx.Commit()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanStatus(row rowScanner) (*models.UserStatus, error) {
+	var status models.UserStatus
+	var lastSeen time.Time
+	var manual, text, emoji, onlineVisibility, lastSeenVisibility sql.NullString
+	var expiresAt sql.NullTime
+	err := row.Scan(&status.UserID, &status.Status, &lastSeen, &manual, &text, &emoji, &expiresAt,
+		&onlineVisibility, &lastSeenVisibility)
+	if err != nil {
+		return nil, err
+	}
+
+	status.LastSeen = lastSeen
+	status.ManualStatus = manual.String
+	status.StatusText = text.String
+	status.StatusEmoji = emoji.String
+	if expiresAt.Valid {
+		status.StatusExpiresAt = &expiresAt.Time
+	}
+	status.Privacy = &models.PrivacySettings{
+		OnlineStatus: defaultVisibility(onlineVisibility.String),
+		LastSeen:     defaultVisibility(lastSeenVisibility.String),
+	}
+	return &status, nil
+}
+
+func defaultPrivacy() *models.PrivacySettings {
+	return &models.PrivacySettings{OnlineStatus: models.VisibilityEveryone, LastSeen: models.VisibilityEveryone}
+}
+
+func defaultVisibility(visibility string) string {
+	if visibility == "" {
+		return models.VisibilityEveryone
+	}
+	return visibility
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	UseRefreshToken(ctx context.Context, tokenHash string, at time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string, at time.Time) error
+	RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsRevoked reports whether any of the token or session IDs is on the
+	// denylist.
+	IsRevoked(ctx context.Context, ids ...string) (bool, error)
+	CreateTicket(ctx context.Context, ticket *models.WSTicket) error
+	ConsumeTicket(ctx context.Context, ticketHash string, now time.Time) (*models.WSTicket, error)
+	CreateMFAChallenge(ctx context.Context, challengeHash string, userID int, expiresAt time.Time) error
+	GetMFAChallenge(ctx context.Context, challengeHash string, now time.Time) (int, error)
+	DeleteMFAChallenge(ctx context.Context, challengeHash string) (bool, error)
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+type tokenRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewTokenRepository(db *sql.DB, logger *zerolog.Logger) TokenRepository {
+	return &tokenRepository{db: db, logger: logger}
+}
+
+func (r *tokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (token_hash, family_id, user_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		token.TokenHash,
+		token.FamilyID,
+		token.UserID,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", token.UserID).Msg("Failed to create refresh token")
+		return err
+	}
+	return nil
+}
+
+func (r *tokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query := `
+		SELECT token_hash, family_id, user_id, expires_at, used_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = ?
+	`
+	var token models.RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.TokenHash,
+		&token.FamilyID,
+		&token.UserID,
+		&token.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			r.logger.Error().Err(err).Msg("Failed to get refresh token")
+		}
+		return nil, err
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks the token as used. It reports false if the token was
+// already used or revoked, so two concurrent refreshes cannot both succeed.
+func (r *tokenRepository) UseRefreshToken(ctx context.Context, tokenHash string, at time.Time) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND revoked_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, at, tokenHash)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to use refresh token")
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (r *tokenRepository) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	query := `UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`
+	_, err := r.db.ExecContext(ctx, query, at, familyID)
+	if err != nil {
+		r.logger.Error().Err(err).Str("family_id", familyID).Msg("Failed to revoke refresh token family")
+		return err
+	}
+	return nil
+}
+
+func (r *tokenRepository) RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	query := `INSERT IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)`
+	_, err := r.db.ExecContext(ctx, query, jti, expiresAt)
+	if err != nil {
+		r.logger.Error().Err(err).Str("jti", jti).Msg("Failed to revoke token")
+		return err
+	}
+	return nil
+}
+
+func (r *tokenRepository) IsRevoked(ctx context.Context, ids ...string) (bool, error) {
+	if len(ids) == 0 {
+		return false, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	query := `SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti IN (` + placeholders + `))`
+	var revoked bool
+	if err := r.db.QueryRowContext(ctx, query, args...).Scan(&revoked); err != nil {
+		r.logger.Error().Err(err).Strs("ids", ids).Msg("Failed to check revoked token")
+		return false, err
+	}
+	return revoked, nil
+}
+
+func (r *tokenRepository) CreateTicket(ctx context.Context, ticket *models.WSTicket) error {
+	query := `
+		INSERT INTO ws_tickets (ticket_hash, user_id, token_id, session_id, token_expires_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		ticket.TicketHash,
+		ticket.UserID,
+		ticket.TokenID,
+		ticket.SessionID,
+		ticket.TokenExpiresAt,
+		ticket.ExpiresAt,
+	)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", ticket.UserID).Msg("Failed to create WebSocket ticket")
+		return err
+	}
+	return nil
+}
+
+// ConsumeTicket returns an unexpired ticket and deletes it, so it can be
+// redeemed once. It returns sql.ErrNoRows if the ticket is unknown, expired or
+// was redeemed concurrently.
+func (r *tokenRepository) ConsumeTicket(ctx context.Context, ticketHash string, now time.Time) (*models.WSTicket, error) {
+	query := `
+		SELECT ticket_hash, user_id, token_id, session_id, token_expires_at, expires_at
+		FROM ws_tickets
+		WHERE ticket_hash = ? AND expires_at > ?
+	`
+	var ticket models.WSTicket
+	err := r.db.QueryRowContext(ctx, query, ticketHash, now).Scan(
+		&ticket.TicketHash,
+		&ticket.UserID,
+		&ticket.TokenID,
+		&ticket.SessionID,
+		&ticket.TokenExpiresAt,
+		&ticket.ExpiresAt,
+	)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			r.logger.Error().Err(err).Msg("Failed to get WebSocket ticket")
+		}
+		return nil, err
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM ws_tickets WHERE ticket_hash = ?`, ticketHash)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to consume WebSocket ticket")
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &ticket, nil
+}
+
+func (r *tokenRepository) CreateMFAChallenge(ctx context.Context, challengeHash string, userID int, expiresAt time.Time) error {
+	query := `INSERT INTO mfa_challenges (challenge_hash, user_id, expires_at) VALUES (?, ?, ?)`
+	_, err := r.db.ExecContext(ctx, query, challengeHash, userID, expiresAt)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to create MFA challenge")
+		return err
+	}
+	return nil
+}
+
+// GetMFAChallenge returns the user of an unexpired challenge, or
+// sql.ErrNoRows. The challenge stays valid until deleted, so a mistyped code
+// can be retried.
+func (r *tokenRepository) GetMFAChallenge(ctx context.Context, challengeHash string, now time.Time) (int, error) {
+	query := `SELECT user_id FROM mfa_challenges WHERE challenge_hash = ? AND expires_at > ?`
+	var userID int
+	err := r.db.QueryRowContext(ctx, query, challengeHash, now).Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Msg("Failed to get MFA challenge")
+	}
+	return userID, err
+}
+
+// DeleteMFAChallenge reports whether the challenge existed, so only one of
+// two concurrent verifications can complete the login.
+func (r *tokenRepository) DeleteMFAChallenge(ctx context.Context, challengeHash string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM mfa_challenges WHERE challenge_hash = ?`, challengeHash)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to delete MFA challenge")
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// DeleteExpired removes refresh tokens, denylist entries, WebSocket tickets
+// and MFA challenges that have expired, since none can be presented
+// successfully anymore.
+func (r *tokenRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	var total int64
+	for _, query := range []string{
+		`DELETE FROM refresh_tokens WHERE expires_at < ?`,
+		`DELETE FROM revoked_tokens WHERE expires_at < ?`,
+		`DELETE FROM ws_tickets WHERE expires_at < ?`,
+		`DELETE FROM mfa_challenges WHERE expires_at < ?`,
+	} {
+		result, err := r.db.ExecContext(ctx, query, before)
+		if err != nil {
+			r.logger.Error().Err(err).Msg("Failed to delete expired tokens")
+			return total, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += affected
+	}
+	return total, nil
+}
@@ -0,0 +1,334 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/zerolog"
+)
+
+// ErrUserExists is returned by Create when the username is already taken.
+var ErrUserExists = errors.New("user already exists")
+
+// mysqlDuplicateEntry is the MySQL error number for a unique key violation.
+const mysqlDuplicateEntry = 1062
+
+type UserRepository interface {
+	Create(ctx context.Context, user *models.User) error
+	GetByID(ctx context.Context, userID int) (*models.User, error)
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	RecordFailedLogin(ctx context.Context, userID, maxAttempts int, lockedUntil time.Time) error
+	ResetFailedLogins(ctx context.Context, userID int) error
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID int) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string, at time.Time) (bool, error)
+	GetServiceAccounts(ctx context.Context, ownerID int) ([]*models.User, error)
+	SetRole(ctx context.Context, userID int, role string) error
+	// GetUsernames returns the usernames of the users that exist among ids.
+	GetUsernames(ctx context.Context, ids []int) (map[int]string, error)
+}
+
+type userRepository struct {
+	db     *sql.DB
+	logger *zerolog.Logger
+}
+
+func NewUserRepository(db *sql.DB, logger *zerolog.Logger) UserRepository {
+	return &userRepository{db: db, logger: logger}
+}
+
+const userColumns = `id, username, password_hash, failed_logins, locked_until, created_at,
+	totp_secret, totp_enabled, totp_last_step, is_service_account, owner_id, role`
+
+func (r *userRepository) Create(ctx context.Context, user *models.User) error {
+	query := `
+		INSERT INTO users (username, password_hash, created_at, is_service_account, owner_id, role)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	var ownerID sql.NullInt64
+	if user.OwnerID > 0 {
+		ownerID = sql.NullInt64{Int64: int64(user.OwnerID), Valid: true}
+	}
+	result, err := r.db.ExecContext(ctx, query,
+		user.Username,
+		user.PasswordHash,
+		user.CreatedAt,
+		user.IsServiceAccount,
+		ownerID,
+		user.Role,
+	)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return ErrUserExists
+		}
+		r.logger.Error().Err(err).Str("username", user.Username).Msg("Failed to create user")
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to get last insert ID")
+		return err
+	}
+	user.ID = int(id)
+	return nil
+}
+
+func (r *userRepository) GetByID(ctx context.Context, userID int) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, userID))
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get user")
+	}
+	return user, err
+}
+
+func (r *userRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = ?`
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, username))
+	if err != nil && err != sql.ErrNoRows {
+		r.logger.Error().Err(err).Str("username", username).Msg("Failed to get user")
+	}
+	return user, err
+}
+
+// RecordFailedLogin counts a failed attempt. Reaching maxAttempts locks the
+// account until lockedUntil and starts the count again. MySQL evaluates the
+// assignments left to right, so locked_until still sees the old count.
+func (r *userRepository) RecordFailedLogin(ctx context.Context, userID, maxAttempts int, lockedUntil time.Time) error {
+	query := `
+		UPDATE users
+		SET locked_until = IF(failed_logins + 1 >= ?, ?, locked_until),
+			failed_logins = IF(failed_logins + 1 >= ?, 0, failed_logins + 1)
+		WHERE id = ?
+	`
+	_, err := r.db.ExecContext(ctx, query, maxAttempts, lockedUntil, maxAttempts, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to record failed login")
+		return err
+	}
+	return nil
+}
+
+func (r *userRepository) ResetFailedLogins(ctx context.Context, userID int) error {
+	query := `UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to reset failed logins")
+		return err
+	}
+	return nil
+}
+
+// SetTOTPSecret stores a secret that is not enforced until EnableTOTP.
+func (r *userRepository) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	query := `UPDATE users SET totp_secret = ?, totp_last_step = NULL WHERE id = ? AND totp_enabled = FALSE`
+	_, err := r.db.ExecContext(ctx, query, secret, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to set TOTP secret")
+		return err
+	}
+	return nil
+}
+
+// EnableTOTP turns on two-factor authentication and replaces the user's
+// recovery codes in one transaction.
+func (r *userRepository) EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET totp_enabled = TRUE WHERE id = ?`, userID); err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to enable TOTP")
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to delete recovery codes")
+		return err
+	}
+	if len(recoveryCodeHashes) > 0 {
+		placeholders := make([]string, len(recoveryCodeHashes))
+		args := make([]interface{}, 0, 2*len(recoveryCodeHashes))
+		for i, hash := range recoveryCodeHashes {
+			placeholders[i] = "(?, ?)"
+			args = append(args, userID, hash)
+		}
+		query := `INSERT INTO recovery_codes (user_id, code_hash) VALUES ` + strings.Join(placeholders, ", ")
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to store recovery codes")
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP removes the secret and the recovery codes.
+func (r *userRepository) DisableTOTP(ctx context.Context, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to disable TOTP")
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to delete recovery codes")
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted code. It reports false if
+// that step or a later one was already used, so a code cannot be replayed.
+func (r *userRepository) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	query := `
+		UPDATE users SET totp_last_step = ?
+		WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)
+	`
+	result, err := r.db.ExecContext(ctx, query, step, userID, step)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to record TOTP step")
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It reports false if
+// the code does not exist or was already used.
+func (r *userRepository) UseRecoveryCode(ctx context.Context, userID int, codeHash string, at time.Time) (bool, error) {
+	query := `
+		UPDATE recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, at, userID, codeHash)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to use recovery code")
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (r *userRepository) GetServiceAccounts(ctx context.Context, ownerID int) ([]*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE owner_id = ? AND is_service_account = TRUE ORDER BY id`
+	rows, err := r.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("owner_id", ownerID).Msg("Failed to get service accounts")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan service account")
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (r *userRepository) GetUsernames(ctx context.Context, ids []int) (map[int]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	query := `SELECT id, username FROM users WHERE id IN (` + placeholders + `)`
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to get usernames")
+		return nil, err
+	}
+	defer rows.Close()
+
+	usernames := make(map[int]string, len(ids))
+	for rows.Next() {
+		var id int
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			r.logger.Error().Err(err).Msg("Failed to scan username")
+			return nil, err
+		}
+		usernames[id] = username
+	}
+	return usernames, rows.Err()
+}
+
+func (r *userRepository) SetRole(ctx context.Context, userID int, role string) error {
+	query := `UPDATE users SET role = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, query, role, userID)
+	if err != nil {
+		r.logger.Error().Err(err).Int("user_id", userID).Msg("Failed to set role")
+		return err
+	}
+	return nil
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	var lockedUntil sql.NullTime
+	var totpSecret sql.NullString
+	var totpLastStep sql.NullInt64
+	var ownerID sql.NullInt64
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.PasswordHash,
+		&user.FailedLogins,
+		&lockedUntil,
+		&user.CreatedAt,
+		&totpSecret,
+		&user.TOTPEnabled,
+		&totpLastStep,
+		&user.IsServiceAccount,
+		&ownerID,
+		&user.Role,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		user.LockedUntil = &lockedUntil.Time
+	}
+	user.TOTPSecret = totpSecret.String
+	user.TOTPLastStep = totpLastStep.Int64
+	user.OwnerID = int(ownerID.Int64)
+	return &user, nil
+}
+
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry
+}
@@ -0,0 +1,322 @@
+package richtext
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/chatapp/internal/models"
+)
+
+// ParseMarkdown converts the small Markdown subset supported in messages into
+// plain text and entities:
+//
+//	**bold**  *italic*  _italic_  `code`  ```code```  [label](url)
+//
+// Bare http and https URLs become links, and a backslash escapes the next
+// punctuation character. Markup that is not closed, or a link with an
+// unsupported URL, is kept as literal text.
+func ParseMarkdown(text string) (string, []models.MessageEntity) {
+	p := &markdownParser{}
+	p.parse([]rune(text), false)
+	SortEntities(p.entities)
+	return string(p.out), p.entities
+}
+
+type markdownParser struct {
+	out      []rune
+	entities []models.MessageEntity
+}
+
+func (p *markdownParser) parse(src []rune, inLink bool) {
+	for i := 0; i < len(src); {
+		r := src[i]
+		switch {
+		case r == '\\' && i+1 < len(src) && isMarkdownPunct(src[i+1]):
+			p.out = append(p.out, src[i+1])
+			i += 2
+			continue
+		case r == '`':
+			if next, ok := p.code(src, i); ok {
+				i = next
+				continue
+			}
+		case r == '*':
+			if next, ok := p.emphasis(src, i, "**", models.EntityBold, inLink); ok {
+				i = next
+				continue
+			}
+			if next, ok := p.emphasis(src, i, "*", models.EntityItalic, inLink); ok {
+				i = next
+				continue
+			}
+		case r == '_':
+			if i == 0 || !isWordRune(src[i-1]) {
+				if next, ok := p.emphasis(src, i, "_", models.EntityItalic, inLink); ok {
+					i = next
+					continue
+				}
+			}
+		case r == '[' && !inLink:
+			if next, ok := p.link(src, i); ok {
+				i = next
+				continue
+			}
+		case r == 'h' && !inLink && (i == 0 || !isWordRune(src[i-1])):
+			if next, ok := p.autolink(src, i); ok {
+				i = next
+				continue
+			}
+		}
+		p.out = append(p.out, r)
+		i++
+	}
+}
+
+// code handles a span opened by a run of backticks and closed by a run of
+// the same length. Its content is kept verbatim.
+func (p *markdownParser) code(src []rune, start int) (int, bool) {
+	n := runLength(src, start, '`')
+	for j := start + n; j < len(src); {
+		if src[j] != '`' {
+			j++
+			continue
+		}
+		m := runLength(src, j, '`')
+		if m != n {
+			j += m
+			continue
+		}
+
+		content := src[start+n : j]
+		if n >= 3 {
+			// Fenced blocks may start and end on their own lines.
+			if len(content) > 0 && content[0] == '\n' {
+				content = content[1:]
+			}
+			if len(content) > 0 && content[len(content)-1] == '\n' {
+				content = content[:len(content)-1]
+			}
+		}
+		if len(content) == 0 {
+			return 0, false
+		}
+
+		offset := len(p.out)
+		p.out = append(p.out, content...)
+		p.add(models.MessageEntity{Type: models.EntityCode, Offset: offset, Length: len(content)})
+		return j + n, true
+	}
+	return 0, false
+}
+
+// emphasis handles bold and italic. The opening delimiter must be followed by
+// a non-space and the closing one preceded by one; an underscore only closes
+// at the end of a word. Closing delimiters are matched against whole runs of
+// the delimiter character, so that "***x***" and "*a **b** c*" nest.
+func (p *markdownParser) emphasis(src []rune, start int, delim string, entityType string, inLink bool) (int, bool) {
+	d := []rune(delim)
+	open := start + len(d)
+	if !hasPrefix(src, start, d) || open >= len(src) || unicode.IsSpace(src[open]) {
+		return 0, false
+	}
+	if d[0] == '*' && len(d) == 1 && src[open] == '*' {
+		return 0, false
+	}
+
+	for j := open + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+			continue
+		case '`':
+			// Delimiters inside code do not count.
+			if end := closingRun(src, j); end > 0 {
+				j = end - 1
+			}
+			continue
+		case d[0]:
+		default:
+			continue
+		}
+
+		run := runLength(src, j, d[0])
+		closeAt := -1
+		switch {
+		case unicode.IsSpace(src[j-1]):
+		case d[0] == '_':
+			if run == 1 && (j+1 >= len(src) || !isWordRune(src[j+1])) {
+				closeAt = j
+			}
+		case len(d) == 1 && run != 2:
+			closeAt = j + run - 1
+		case len(d) == 2 && run >= 2:
+			closeAt = j + run - 2
+		}
+		if closeAt < 0 {
+			j += run - 1
+			continue
+		}
+
+		offset := len(p.out)
+		p.parse(src[open:closeAt], inLink)
+		if len(p.out) == offset {
+			return 0, false
+		}
+		p.add(models.MessageEntity{Type: entityType, Offset: offset, Length: len(p.out) - offset})
+		return closeAt + len(d), true
+	}
+	return 0, false
+}
+
+// link handles [label](url). The label may contain other formatting but not
+// another link.
+func (p *markdownParser) link(src []rune, start int) (int, bool) {
+	closeLabel := -1
+	for j := start + 1; j < len(src); j++ {
+		if src[j] == '\\' {
+			j++
+			continue
+		}
+		if src[j] == ']' {
+			closeLabel = j
+			break
+		}
+		if src[j] == '[' || src[j] == '\n' {
+			return 0, false
+		}
+	}
+	if closeLabel <= start+1 || closeLabel+1 >= len(src) || src[closeLabel+1] != '(' {
+		return 0, false
+	}
+
+	// Parentheses inside the URL must balance, as in a_(b).
+	closeURL := -1
+	depth := 0
+	for j := closeLabel + 2; j < len(src) && closeURL < 0; j++ {
+		switch {
+		case unicode.IsSpace(src[j]):
+			return 0, false
+		case src[j] == '(':
+			depth++
+		case src[j] == ')' && depth == 0:
+			closeURL = j
+		case src[j] == ')':
+			depth--
+		}
+	}
+	if closeURL < 0 {
+		return 0, false
+	}
+	target := string(src[closeLabel+2 : closeURL])
+	if !ValidURL(target) {
+		return 0, false
+	}
+
+	offset := len(p.out)
+	mark := len(p.entities)
+	p.parse(src[start+1:closeLabel], true)
+	if len(p.out) == offset {
+		p.entities = p.entities[:mark]
+		return 0, false
+	}
+	p.add(models.MessageEntity{Type: models.EntityLink, Offset: offset, Length: len(p.out) - offset, URL: target})
+	return closeURL + 1, true
+}
+
+// autolink turns a bare http or https URL into a link. Trailing punctuation
+// is left out, so a URL may end a sentence, except for a closing parenthesis
+// that balances one inside the URL.
+func (p *markdownParser) autolink(src []rune, start int) (int, bool) {
+	rest := string(src[start:min(len(src), start+8)])
+	if !strings.HasPrefix(rest, "http://") && !strings.HasPrefix(rest, "https://") {
+		return 0, false
+	}
+
+	end := start
+	for end < len(src) && !unicode.IsSpace(src[end]) {
+		end++
+	}
+	for end > start && strings.ContainsRune(".,;:!?)]'\"", src[end-1]) {
+		if src[end-1] == ')' && balanced(src[start:end]) {
+			break
+		}
+		end--
+	}
+
+	target := string(src[start:end])
+	if !ValidURL(target) {
+		return 0, false
+	}
+
+	offset := len(p.out)
+	p.out = append(p.out, src[start:end]...)
+	p.add(models.MessageEntity{Type: models.EntityLink, Offset: offset, Length: end - start, URL: target})
+	return end, true
+}
+
+func (p *markdownParser) add(entity models.MessageEntity) {
+	p.entities = append(p.entities, entity)
+}
+
+// closingRun returns the index after the backtick run that closes the code
+// span opened at start, or 0 if it is never closed.
+func closingRun(src []rune, start int) int {
+	n := runLength(src, start, '`')
+	for j := start + n; j < len(src); {
+		if src[j] != '`' {
+			j++
+			continue
+		}
+		m := runLength(src, j, '`')
+		if m == n {
+			return j + m
+		}
+		j += m
+	}
+	return 0
+}
+
+// balanced reports whether every closing parenthesis in s has an opening one.
+func balanced(s []rune) bool {
+	depth := 0
+	for _, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func runLength(src []rune, start int, r rune) int {
+	n := 0
+	for start+n < len(src) && src[start+n] == r {
+		n++
+	}
+	return n
+}
+
+func hasPrefix(src []rune, at int, prefix []rune) bool {
+	if at+len(prefix) > len(src) {
+		return false
+	}
+	for i, r := range prefix {
+		if src[at+i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func isMarkdownPunct(r rune) bool {
+	return strings.ContainsRune("\\`*_[]()", r)
+}
@@ -0,0 +1,95 @@
+package richtext
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chatapp/internal/models"
+)
+
+func bold(offset, length int) models.MessageEntity {
+	return models.MessageEntity{Type: models.EntityBold, Offset: offset, Length: length}
+}
+
+func italic(offset, length int) models.MessageEntity {
+	return models.MessageEntity{Type: models.EntityItalic, Offset: offset, Length: length}
+}
+
+func code(offset, length int) models.MessageEntity {
+	return models.MessageEntity{Type: models.EntityCode, Offset: offset, Length: length}
+}
+
+func link(offset, length int, url string) models.MessageEntity {
+	return models.MessageEntity{Type: models.EntityLink, Offset: offset, Length: length, URL: url}
+}
+
+func TestParseMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		text     string
+		entities []models.MessageEntity
+	}{
+		{"plain", "oi", "oi", nil},
+		{"bold", "**oi**", "oi", []models.MessageEntity{bold(0, 2)}},
+		{"italic", "*oi* e _tchau_", "oi e tchau", []models.MessageEntity{italic(0, 2), italic(5, 5)}},
+		{"offsets count code points", "olá **😀**", "olá 😀", []models.MessageEntity{bold(4, 1)}},
+
+		// Nesting.
+		{"bold in italic", "*a **b** c*", "a b c", []models.MessageEntity{italic(0, 5), bold(2, 1)}},
+		{"bold and italic", "***x***", "x", []models.MessageEntity{italic(0, 1), bold(0, 1)}},
+		{"code in italic", "*a `*` b*", "a * b", []models.MessageEntity{italic(0, 5), code(2, 1)}},
+		{"formatted link label", "[**b**](https://e.com)", "b", []models.MessageEntity{bold(0, 1), link(0, 1, "https://e.com")}},
+
+		// Unclosed markup stays literal.
+		{"unclosed bold", "**oi", "**oi", nil},
+		{"unclosed italic", "_oi", "_oi", nil},
+		{"unclosed code", "`oi", "`oi", nil},
+		{"unclosed link", "[oi](https://e.com", "[oi](https://e.com", []models.MessageEntity{link(5, 13, "https://e.com")}},
+		{"link without target", "[oi] depois", "[oi] depois", nil},
+		{"space after opener", "* nada*", "* nada*", nil},
+		{"underscore inside a word", "snake_case_name", "snake_case_name", nil},
+		{"bold closed as italic", "**a*", "*a", []models.MessageEntity{italic(1, 1)}},
+
+		// Escapes.
+		{"escaped delimiters", `\*literal\*`, "*literal*", nil},
+		{"escaped backslash", `a\\b`, `a\b`, nil},
+		{"backslash before letter", `a\b`, `a\b`, nil},
+		{"escaped bracket", `\[x](https://e.com)`, "[x](https://e.com)", []models.MessageEntity{link(4, 13, "https://e.com")}},
+
+		// Code.
+		{"code keeps markup", "`a*b*c`", "a*b*c", []models.MessageEntity{code(0, 5)}},
+		{"longer fence holds backticks", "``a`b``", "a`b", []models.MessageEntity{code(0, 3)}},
+		{"fenced block", "```\nfmt.Println()\n```", "fmt.Println()", []models.MessageEntity{code(0, 13)}},
+		{"empty code", "``````", "``````", nil},
+
+		// Links.
+		{"link", "[site](https://example.com)", "site", []models.MessageEntity{link(0, 4, "https://example.com")}},
+		{"mailto link", "[mail](mailto:ana@example.com)", "mail", []models.MessageEntity{link(0, 4, "mailto:ana@example.com")}},
+		{"unsafe link", "[x](javascript:alert(1))", "[x](javascript:alert(1))", nil},
+		{"data link", "[x](data:text/html,oi)", "[x](data:text/html,oi)", nil},
+		{"relative link", "[x](/admin)", "[x](/admin)", nil},
+		{"link with parentheses", "[wiki](https://example.com/a_(b))", "wiki", []models.MessageEntity{link(0, 4, "https://example.com/a_(b)")}},
+
+		// Bare URLs.
+		{"autolink", "veja https://example.com", "veja https://example.com", []models.MessageEntity{link(5, 19, "https://example.com")}},
+		{"autolink ends a sentence", "veja https://example.com.", "veja https://example.com.", []models.MessageEntity{link(5, 19, "https://example.com")}},
+		{"autolink in parentheses", "(https://example.com)", "(https://example.com)", []models.MessageEntity{link(1, 19, "https://example.com")}},
+		{"autolink with parentheses", "https://example.com/a_(b)", "https://example.com/a_(b)", []models.MessageEntity{link(0, 25, "https://example.com/a_(b)")}},
+		{"autolink with parentheses in parentheses", "(veja https://example.com/a_(b)).", "(veja https://example.com/a_(b)).", []models.MessageEntity{link(6, 25, "https://example.com/a_(b)")}},
+		{"other scheme", "ftp://example.com", "ftp://example.com", nil},
+		{"inside a word", "xhttps://example.com", "xhttps://example.com", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities := ParseMarkdown(tt.markdown)
+			if text != tt.text || !reflect.DeepEqual(entities, tt.entities) {
+				t.Fatalf("ParseMarkdown(%q) = %q, %+v; want %q, %+v", tt.markdown, text, entities, tt.text, tt.entities)
+			}
+			if err := ValidateEntities(text, entities); err != nil {
+				t.Fatalf("entities of %q are invalid: %v", tt.markdown, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Package richtext extracts structure from message text.
+package richtext
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+)
+
+// Mention is an @username found in a text. Offset and Length count Unicode
+// code points and include the @.
+type Mention struct {
+	Username string
+	Offset   int
+	Length   int
+}
+
+// ParseMentions finds the @username tokens in text. An @ preceded by a
+// username character, as in an email address, does not start a mention, and
+// trailing dots are left out so that a mention may end a sentence.
+func ParseMentions(text string) []Mention {
+	runes := []rune(text)
+
+	var mentions []Mention
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && (isUsernameRune(runes[i-1]) || runes[i-1] == '@')) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isUsernameRune(runes[end]) {
+			end++
+		}
+		next := end
+		for end > i+1 && runes[end-1] == '.' {
+			end--
+		}
+
+		if n := end - i - 1; n >= minUsernameLength && n <= maxUsernameLength {
+			mentions = append(mentions, Mention{
+				Username: string(runes[i+1 : end]),
+				Offset:   i,
+				Length:   end - i,
+			})
+		}
+		i = next - 1
+	}
+	return mentions
+}
+
+// isUsernameRune reports whether r may appear in a username.
+func isUsernameRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		r == '_' || r == '.' || r == '-'
+}
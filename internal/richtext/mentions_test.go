@@ -0,0 +1,45 @@
+package richtext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Mention
+	}{
+		{"none", "oi", nil},
+		{"single", "oi @ana", []Mention{{Username: "ana", Offset: 3, Length: 4}}},
+		{"start of text", "@ana oi", []Mention{{Username: "ana", Offset: 0, Length: 4}}},
+		{"several", "@ana e @bruno", []Mention{
+			{Username: "ana", Offset: 0, Length: 4},
+			{Username: "bruno", Offset: 7, Length: 6},
+		}},
+		{"username characters", "@ana.souza_2-x", []Mention{{Username: "ana.souza_2-x", Offset: 0, Length: 14}}},
+		{"trailing dots", "falou com @ana...", []Mention{{Username: "ana", Offset: 10, Length: 4}}},
+		{"punctuation", "(@ana), @bruno!", []Mention{
+			{Username: "ana", Offset: 1, Length: 4},
+			{Username: "bruno", Offset: 8, Length: 6},
+		}},
+		{"email address", "ana@example.com", nil},
+		{"double at", "@@ana", nil},
+		{"too short", "@an", nil},
+		{"too long", "@" + strings.Repeat("a", 33), nil},
+		{"longest", "@" + strings.Repeat("a", 32), []Mention{{Username: strings.Repeat("a", 32), Offset: 0, Length: 33}}},
+		{"offsets count code points", "olá 😀 @ana", []Mention{{Username: "ana", Offset: 6, Length: 4}}},
+		{"non-ASCII ends the name", "@anaé", []Mention{{Username: "ana", Offset: 0, Length: 4}}},
+		{"bare at", "@ e @", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseMentions(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package richtext
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/chatapp/internal/models"
+)
+
+const (
+	// MaxLength caps the plain text of a message, in code points.
+	MaxLength     = 4096
+	maxEntities   = 100
+	maxURLLength  = 2048
+	maxBlankLines = 2
+)
+
+var (
+	ErrEmptyContent    = errors.New("message content is empty")
+	ErrContentTooLong  = fmt.Errorf("message content exceeds %d characters", MaxLength)
+	ErrTooManyEntities = fmt.Errorf("message has more than %d entities", maxEntities)
+	ErrInvalidEntity   = errors.New("invalid message entity")
+)
+
+// Prepare turns the content a client sent into the stored form: sanitized
+// plain text and validated entities. Markdown is parsed into entities;
+// otherwise the client's entities are checked against the sanitized text.
+// Mention entities from the client are discarded, since the server detects
+// mentions itself.
+func Prepare(content, format string, entities []models.MessageEntity) (string, []models.MessageEntity, error) {
+	text, index := Sanitize(content)
+
+	switch format {
+	case models.FormatMarkdown:
+		text, entities = ParseMarkdown(text)
+	case "":
+		remapped := make([]models.MessageEntity, 0, len(entities))
+		size := len([]rune(content))
+		for _, entity := range entities {
+			if entity.Type == models.EntityMention {
+				continue
+			}
+			if entity.Offset < 0 || entity.Length <= 0 || entity.End() > size {
+				return "", nil, fmt.Errorf("%w: %s at %d is out of range", ErrInvalidEntity, entity.Type, entity.Offset)
+			}
+			start, end := index[entity.Offset], index[entity.End()]
+			if start == end {
+				continue
+			}
+			entity.Offset, entity.Length = start, end-start
+			remapped = append(remapped, entity)
+		}
+		entities = remapped
+	default:
+		return "", nil, fmt.Errorf("unknown message format %q", format)
+	}
+
+	size := len([]rune(text))
+	if size == 0 {
+		return "", nil, ErrEmptyContent
+	}
+	if size > MaxLength {
+		return "", nil, ErrContentTooLong
+	}
+	if err := ValidateEntities(text, entities); err != nil {
+		return "", nil, err
+	}
+	return text, entities, nil
+}
+
+// Sanitize removes control characters other than newlines and tabs, carriage
+// returns, bidirectional overrides and surrounding whitespace, and collapses
+// runs of blank lines. index maps each code point offset of text, and its
+// length, to the matching offset in the result, so that entities can follow.
+func Sanitize(text string) (clean string, index []int) {
+	runes := []rune(text)
+	keep := make([]bool, len(runes))
+	for i, r := range runes {
+		keep[i] = r == '\n' || r == '\t' || !(unicode.IsControl(r) || isBidiControl(r))
+	}
+
+	// Trim whitespace at both ends.
+	for i := 0; i < len(runes) && (!keep[i] || unicode.IsSpace(runes[i])); i++ {
+		keep[i] = false
+	}
+	for i := len(runes) - 1; i >= 0 && (!keep[i] || unicode.IsSpace(runes[i])); i-- {
+		keep[i] = false
+	}
+
+	// Allow at most maxBlankLines empty lines in a row.
+	newlines := 0
+	for i, r := range runes {
+		if !keep[i] {
+			continue
+		}
+		switch {
+		case r == '\n':
+			newlines++
+			if newlines > maxBlankLines+1 {
+				keep[i] = false
+			}
+		case !unicode.IsSpace(r):
+			newlines = 0
+		}
+	}
+
+	var b strings.Builder
+	index = make([]int, len(runes)+1)
+	n := 0
+	for i, r := range runes {
+		index[i] = n
+		if keep[i] {
+			b.WriteRune(r)
+			n++
+		}
+	}
+	index[len(runes)] = n
+	return b.String(), index
+}
+
+func isBidiControl(r rune) bool {
+	return r >= '\u202A' && r <= '\u202E' || r >= '\u2066' && r <= '\u2069'
+}
+
+// ValidateEntities checks that the entities fit the text, have a known type
+// and a safe link target, and nest properly. It sorts them by offset, outer
+// entities first.
+func ValidateEntities(text string, entities []models.MessageEntity) error {
+	if len(entities) > maxEntities {
+		return ErrTooManyEntities
+	}
+	size := len([]rune(text))
+
+	SortEntities(entities)
+	var open []models.MessageEntity
+	for _, entity := range entities {
+		if entity.Offset < 0 || entity.Length <= 0 || entity.End() > size {
+			return fmt.Errorf("%w: %s at %d is out of range", ErrInvalidEntity, entity.Type, entity.Offset)
+		}
+
+		switch entity.Type {
+		case models.EntityBold, models.EntityItalic, models.EntityCode:
+		case models.EntityLink:
+			if !ValidURL(entity.URL) {
+				return fmt.Errorf("%w: link at %d has an unsupported URL", ErrInvalidEntity, entity.Offset)
+			}
+		case models.EntityMention:
+			if entity.UserID <= 0 {
+				return fmt.Errorf("%w: mention at %d has no user", ErrInvalidEntity, entity.Offset)
+			}
+		default:
+			return fmt.Errorf("%w: unknown type %q", ErrInvalidEntity, entity.Type)
+		}
+
+		for len(open) > 0 && open[len(open)-1].End() <= entity.Offset {
+			open = open[:len(open)-1]
+		}
+		if len(open) > 0 {
+			parent := open[len(open)-1]
+			if entity.End() > parent.End() || parent.Type == models.EntityCode {
+				return fmt.Errorf("%w: %s at %d overlaps %s", ErrInvalidEntity, entity.Type, entity.Offset, parent.Type)
+			}
+		}
+		open = append(open, entity)
+	}
+	return nil
+}
+
+// Fits reports whether entity can be added to the valid entities without
+// crossing one of them or landing inside code.
+func Fits(entities []models.MessageEntity, entity models.MessageEntity) bool {
+	for _, other := range entities {
+		if entity.Offset >= other.End() || other.Offset >= entity.End() {
+			continue
+		}
+		inside := entity.Offset >= other.Offset && entity.End() <= other.End()
+		contains := other.Offset >= entity.Offset && other.End() <= entity.End()
+		if inside && other.Type == models.EntityCode {
+			return false
+		}
+		if !inside && !contains {
+			return false
+		}
+	}
+	return true
+}
+
+// SortEntities orders entities by offset, outer entities first.
+func SortEntities(entities []models.MessageEntity) {
+	sort.SliceStable(entities, func(i, j int) bool {
+		if entities[i].Offset != entities[j].Offset {
+			return entities[i].Offset < entities[j].Offset
+		}
+		return entities[i].Length > entities[j].Length
+	})
+}
+
+// ValidURL accepts absolute http, https and mailto URLs.
+func ValidURL(raw string) bool {
+	if raw == "" || len(raw) > maxURLLength || strings.ContainsFunc(raw, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) {
+		return false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	default:
+		return false
+	}
+}
@@ -0,0 +1,131 @@
+package richtext
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chatapp/internal/models"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		clean string
+		index []int
+	}{
+		{"unchanged", "oi", "oi", []int{0, 1, 2}},
+		{"surrounding whitespace", "  oi\n ", "oi", []int{0, 0, 0, 1, 2, 2, 2}},
+		{"control characters", "a\x00b\x07", "ab", []int{0, 1, 1, 2, 2}},
+		{"bidi overrides", "a\u202Eb\u2066", "ab", []int{0, 1, 1, 2, 2}},
+		{"carriage returns", "a\r\nb", "a\nb", []int{0, 1, 1, 2, 3}},
+		{"tabs and newlines kept", "a\tb\nc", "a\tb\nc", []int{0, 1, 2, 3, 4, 5}},
+		{"blank lines collapsed", "a\n\n\n\n\nb", "a\n\n\nb", []int{0, 1, 2, 3, 4, 4, 4, 5}},
+		{"blank lines with spaces", "a\n \n \n \nb", "a\n \n \n b", []int{0, 1, 2, 3, 4, 5, 6, 7, 7, 8}},
+		{"only whitespace", " \n\t ", "", []int{0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clean, index := Sanitize(tt.text)
+			if clean != tt.clean || !reflect.DeepEqual(index, tt.index) {
+				t.Fatalf("Sanitize(%q) = %q, %v; want %q, %v", tt.text, clean, index, tt.clean, tt.index)
+			}
+		})
+	}
+}
+
+func TestPrepareRemapsEntitiesAfterSanitize(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		entities []models.MessageEntity
+		text     string
+		want     []models.MessageEntity
+	}{
+		{
+			name:     "leading whitespace and controls",
+			content:  " \u202Ebold\x00 e link",
+			entities: []models.MessageEntity{bold(2, 5), link(10, 4, "https://e.com")},
+			text:     "bold e link",
+			want:     []models.MessageEntity{bold(0, 4), link(7, 4, "https://e.com")},
+		},
+		{
+			name:     "collapsed blank lines",
+			content:  "a\n\n\n\n\nb",
+			entities: []models.MessageEntity{italic(6, 1)},
+			text:     "a\n\n\nb",
+			want:     []models.MessageEntity{italic(4, 1)},
+		},
+		{
+			name:     "entity over removed characters only",
+			content:  "oi  ",
+			entities: []models.MessageEntity{bold(2, 2)},
+			text:     "oi",
+			want:     []models.MessageEntity{},
+		},
+		{
+			name:     "mentions from the client",
+			content:  "oi @ana",
+			entities: []models.MessageEntity{{Type: models.EntityMention, Offset: 3, Length: 4, UserID: 9}},
+			text:     "oi @ana",
+			want:     []models.MessageEntity{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities, err := Prepare(tt.content, "", tt.entities)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.text || !reflect.DeepEqual(entities, tt.want) {
+				t.Fatalf("Prepare(%q) = %q, %+v; want %q, %+v", tt.content, text, entities, tt.text, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareMarkdownIsSanitizedFirst(t *testing.T) {
+	text, entities, err := Prepare("  \u202E**oi**\x00  ", models.FormatMarkdown, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "oi" || !reflect.DeepEqual(entities, []models.MessageEntity{bold(0, 2)}) {
+		t.Fatalf("Prepare = %q, %+v", text, entities)
+	}
+}
+
+func TestPrepareRejectsInvalidContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		format   string
+		entities []models.MessageEntity
+		want     error
+	}{
+		{"empty", " \x00\n ", "", nil, ErrEmptyContent},
+		{"too long", strings.Repeat("a", MaxLength+1), "", nil, ErrContentTooLong},
+		{"out of range", "oi", "", []models.MessageEntity{bold(1, 5)}, ErrInvalidEntity},
+		{"negative offset", "oi", "", []models.MessageEntity{bold(-1, 1)}, ErrInvalidEntity},
+		{"unsafe link", "oi", "", []models.MessageEntity{link(0, 2, "javascript:alert(1)")}, ErrInvalidEntity},
+		{"relative link", "oi", "", []models.MessageEntity{link(0, 2, "/admin")}, ErrInvalidEntity},
+		{"unknown type", "oi", "", []models.MessageEntity{{Type: "spoiler", Offset: 0, Length: 2}}, ErrInvalidEntity},
+		{"crossing entities", "abcd", "", []models.MessageEntity{bold(0, 3), italic(1, 3)}, ErrInvalidEntity},
+		{"formatting inside code", "abcd", "", []models.MessageEntity{code(0, 4), bold(1, 1)}, ErrInvalidEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Prepare(tt.content, tt.format, tt.entities); !errors.Is(err, tt.want) {
+				t.Fatalf("Prepare(%q) = %v, want %v", tt.content, err, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := Prepare("oi", "html", nil); err == nil {
+		t.Fatal("Prepare accepted an unknown format")
+	}
+}
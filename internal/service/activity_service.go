@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/repository"
+)
+
+// maxActivityRange bounds how much history one request may cover.
+const maxActivityRange = 92 * 24 * time.Hour
+
+var ErrActivityHidden = errors.New("activity not visible")
+
+// ActivityService answers questions about when users are usually reachable,
+// based on the status_events history.
+type ActivityService interface {
+	GetOnlineSessions(ctx context.Context, viewerID, userID int, from, to time.Time) ([]*models.OnlineSession, error)
+	GetDailyActiveMinutes(ctx context.Context, viewerID, userID int, from, to time.Time, loc *time.Location) ([]*models.DailyActivity, error)
+	PurgeExpiredEvents(ctx context.Context) (int64, error)
+}
+
+type activityService struct {
+	events    repository.StatusEventRepository
+	statuses  StatusService
+	retention time.Duration
+}
+
+func NewActivityService(events repository.StatusEventRepository, statuses StatusService, retention time.Duration) ActivityService {
+	return &activityService{events: events, statuses: statuses, retention: retention}
+}
+
+// GetOnlineSessions returns the spans in [from, to) during which the user was
+// connected, whatever their status.
+func (s *activityService) GetOnlineSessions(ctx context.Context, viewerID, userID int, from, to time.Time) ([]*models.OnlineSession, error) {
+	spans, err := s.loadSpans(ctx, viewerID, userID, from, to, func(status string) bool {
+		return status != models.StatusOffline
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*models.OnlineSession, 0, len(spans))
+	for _, span := range spans {
+		sessions = append(sessions, &models.OnlineSession{Start: span.start, End: span.end, Ongoing: span.ongoing})
+	}
+	return sessions, nil
+}
+
+// GetDailyActiveMinutes sums, per calendar day in loc, the minutes the user
+// spent online and not away.
+func (s *activityService) GetDailyActiveMinutes(ctx context.Context, viewerID, userID int, from, to time.Time, loc *time.Location) ([]*models.DailyActivity, error) {
+	spans, err := s.loadSpans(ctx, viewerID, userID, from, to, func(status string) bool {
+		return status == models.StatusOnline
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	active := make(map[string]time.Duration)
+	for _, span := range spans {
+		start := span.start.In(loc)
+		for start.Before(span.end) {
+			year, month, day := start.Date()
+			nextDay := time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+			end := span.end
+			if nextDay.Before(end) {
+				end = nextDay
+			}
+			active[start.Format("2006-01-02")] += end.Sub(start)
+			start = nextDay
+		}
+	}
+
+	var days []*models.DailyActivity
+	first := from.In(loc)
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		days = append(days, &models.DailyActivity{Date: key, ActiveMinutes: int(active[key] / time.Minute)})
+	}
+	return days, nil
+}
+
+func (s *activityService) PurgeExpiredEvents(ctx context.Context) (int64, error) {
+	return s.events.DeleteBefore(ctx, time.Now().Add(-s.retention))
+}
+
+type activitySpan struct {
+	start, end time.Time
+	ongoing    bool
+}
+
+// connectionStatuses are the history entries written by UpdateUserStatus;
+// visibilityStatuses mark invisible periods.
+var (
+	connectionStatuses = []string{models.StatusOnline, models.StatusAway, models.StatusOffline}
+	visibilityStatuses = []string{models.StatusInvisible, models.StatusEventVisible}
+)
+
+// loadSpans replays the history over [from, to) and returns the spans during
+// which matches held. The state at from comes from the last earlier events.
+// Unless the viewer may see everything, periods the user spent invisible are
+// left out.
+func (s *activityService) loadSpans(
+	ctx context.Context,
+	viewerID, userID int,
+	from, to time.Time,
+	matches func(status string) bool,
+) ([]activitySpan, error) {
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+	if !from.Before(to) || to.Sub(from) > maxActivityRange {
+		return nil, errors.New("invalid time range")
+	}
+	full, err := s.checkVisible(ctx, viewerID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if to.After(now) {
+		to = now
+	}
+
+	previous, err := s.events.GetLastBefore(ctx, userID, from, connectionStatuses)
+	if err != nil {
+		return nil, err
+	}
+	hidden := false
+	if !full {
+		visibility, err := s.events.GetLastBefore(ctx, userID, from, visibilityStatuses)
+		if err != nil {
+			return nil, err
+		}
+		hidden = visibility != nil && visibility.Status == models.StatusInvisible
+	}
+	events, err := s.events.GetRange(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	status := ""
+	if previous != nil {
+		status = previous.Status
+	}
+	active := func() bool {
+		return status != "" && matches(status) && !hidden
+	}
+
+	var spans []activitySpan
+	var openedAt *time.Time
+	if active() {
+		start := from
+		openedAt = &start
+	}
+	for _, event := range events {
+		switch event.Status {
+		case models.StatusInvisible:
+			hidden = !full
+		case models.StatusEventVisible:
+			hidden = false
+		default:
+			status = event.Status
+		}
+
+		switch {
+		case active() && openedAt == nil:
+			start := event.OccurredAt
+			openedAt = &start
+		case !active() && openedAt != nil:
+			spans = append(spans, activitySpan{start: *openedAt, end: event.OccurredAt})
+			openedAt = nil
+		}
+	}
+	if openedAt != nil && openedAt.Before(to) {
+		spans = append(spans, activitySpan{start: *openedAt, end: to, ongoing: to.Equal(now)})
+	}
+	return spans, nil
+}
+
+// checkVisible applies the owner's privacy settings: history reveals both
+// online status and last seen, so the viewer must be allowed to see both.
+// Blocks hide it either way, and so does being invisible now. It reports
+// whether the viewer sees the full history, invisible periods included:
+// the owner does, and moderators may see anyone's.
+func (s *activityService) checkVisible(ctx context.Context, viewerID, userID int) (bool, error) {
+	if viewerID == userID || policy.Allowed(ctx, policy.PermActivityViewAny) {
+		return true, nil
+	}
+
+	status, err := s.statuses.GetUserStatus(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if status.ManualStatus == models.StatusInvisible {
+		return false, ErrActivityHidden
+	}
+	blocked, err := s.statuses.BlockedAmong(ctx, viewerID, []int{userID})
+	if err != nil {
+		return false, err
+	}
+	if blocked[userID] {
+		return false, ErrActivityHidden
+	}
+	contacts, err := s.statuses.ContactsAmong(ctx, viewerID, []int{userID})
+	if err != nil {
+		return false, err
+	}
+
+	isContact := contacts[userID]
+	if !status.OnlineVisibleTo(isContact) || !status.LastSeenVisibleTo(isContact) {
+		return false, ErrActivityHidden
+	}
+	return false, nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+)
+
+func newTestActivity(events *fakeStatusEvents) (ActivityService, StatusService) {
+	statuses := NewStatusService(newFakeStatusRepo(), events, fakeContacts{}, newFakeBlocks())
+	return NewActivityService(events, statuses, 24*time.Hour), statuses
+}
+
+// history records the user's events at the given offsets from base.
+func history(events *fakeStatusEvents, userID int, base time.Time, entries ...interface{}) {
+	for i := 0; i < len(entries); i += 2 {
+		events.Create(context.Background(), &models.StatusEvent{
+			UserID:     userID,
+			Status:     entries[i].(string),
+			OccurredAt: base.Add(entries[i+1].(time.Duration)),
+		})
+	}
+}
+
+func spans(sessions []*models.OnlineSession, base time.Time) [][2]time.Duration {
+	var got [][2]time.Duration
+	for _, session := range sessions {
+		got = append(got, [2]time.Duration{session.Start.Sub(base), session.End.Sub(base)})
+	}
+	return got
+}
+
+func TestActivityClipsInvisiblePeriods(t *testing.T) {
+	ctx := context.Background()
+	events := &fakeStatusEvents{}
+	activity, _ := newTestActivity(events)
+
+	base := time.Now().Add(-10 * time.Hour).Truncate(time.Second)
+	history(events, 1, base,
+		models.StatusOnline, time.Hour,
+		models.StatusInvisible, 2*time.Hour,
+		models.StatusEventVisible, 3*time.Hour,
+		models.StatusOffline, 4*time.Hour,
+		// Connected while invisible: nothing to show.
+		models.StatusInvisible, 5*time.Hour,
+		models.StatusOnline, 6*time.Hour,
+		models.StatusOffline, 7*time.Hour,
+		models.StatusEventVisible, 8*time.Hour,
+	)
+	from, to := base, base.Add(9*time.Hour)
+
+	sessions, err := activity.GetOnlineSessions(ctx, 2, 1, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]time.Duration{{time.Hour, 2 * time.Hour}, {3 * time.Hour, 4 * time.Hour}}
+	if got := spans(sessions, base); !reflect.DeepEqual(got, want) {
+		t.Fatalf("other viewer sees %v, want %v", got, want)
+	}
+
+	// A range that starts inside an invisible period.
+	sessions, err = activity.GetOnlineSessions(ctx, 2, 1, base.Add(150*time.Minute), to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = [][2]time.Duration{{3 * time.Hour, 4 * time.Hour}}
+	if got := spans(sessions, base); !reflect.DeepEqual(got, want) {
+		t.Fatalf("range starting while invisible shows %v, want %v", got, want)
+	}
+
+	// The owner and moderators see everything.
+	full := [][2]time.Duration{{time.Hour, 4 * time.Hour}, {6 * time.Hour, 7 * time.Hour}}
+	sessions, _ = activity.GetOnlineSessions(ctx, 1, 1, from, to)
+	if got := spans(sessions, base); !reflect.DeepEqual(got, full) {
+		t.Fatalf("owner sees %v, want %v", got, full)
+	}
+	moderator := policy.NewContext(ctx, policy.Subject{UserID: 3, Role: policy.RoleModerator})
+	sessions, _ = activity.GetOnlineSessions(moderator, 3, 1, from, to)
+	if got := spans(sessions, base); !reflect.DeepEqual(got, full) {
+		t.Fatalf("moderator sees %v, want %v", got, full)
+	}
+}
+
+func TestActivityHiddenWhileInvisible(t *testing.T) {
+	ctx := context.Background()
+	events := &fakeStatusEvents{}
+	activity, statuses := newTestActivity(events)
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	if _, err := statuses.SetCustomStatus(ctx, 1, &models.StatusUpdate{Status: models.StatusInvisible}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := activity.GetOnlineSessions(ctx, 2, 1, from, to); !errors.Is(err, ErrActivityHidden) {
+		t.Fatalf("GetOnlineSessions while invisible = %v, want ErrActivityHidden", err)
+	}
+	if _, err := activity.GetDailyActiveMinutes(ctx, 2, 1, from, to, time.UTC); !errors.Is(err, ErrActivityHidden) {
+		t.Fatalf("GetDailyActiveMinutes while invisible = %v, want ErrActivityHidden", err)
+	}
+
+	if _, err := statuses.SetCustomStatus(ctx, 1, &models.StatusUpdate{Status: models.StatusDND}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := activity.GetOnlineSessions(ctx, 2, 1, from, to); err != nil {
+		t.Fatalf("GetOnlineSessions after leaving invisible = %v", err)
+	}
+
+	want := []string{models.StatusInvisible, models.StatusEventVisible}
+	if got := events.statuses(1); !reflect.DeepEqual(got, want) {
+		t.Fatalf("history = %v, want %v", got, want)
+	}
+}
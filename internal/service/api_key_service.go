@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/repository"
+)
+
+var (
+	ErrInvalidAPIKey          = errors.New("invalid or revoked API key")
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	ErrAPIKeyNotFound         = errors.New("API key not found")
+	ErrInvalidScope           = errors.New("unknown scope")
+	ErrMissingScope           = errors.New("at least one scope is required")
+	ErrInvalidAccountName     = errors.New("name must be 3-32 letters, digits, '.', '_' or '-'")
+	ErrNestedServiceAccount   = errors.New("service accounts cannot own service accounts")
+	ErrInvalidKeyName         = errors.New("name must be between 1 and 64 characters")
+	ErrInvalidKeyExpiry       = errors.New("expiry must not be negative")
+)
+
+const (
+	// APIKeyPrefix marks a bearer token as an API key rather than a JWT.
+	APIKeyPrefix = "cak_"
+	// apiKeyDisplayLength is how much of the key is kept to identify it.
+	apiKeyDisplayLength = 12
+	// lastUsedInterval limits how often a key or session records its last
+	// use.
+	lastUsedInterval = time.Minute
+)
+
+type APIKeyService interface {
+	CreateServiceAccount(ctx context.Context, ownerID int, name string) (*models.User, error)
+	ListServiceAccounts(ctx context.Context, ownerID int) ([]*models.User, error)
+	// CreateKey issues a key for one of the owner's service accounts. A zero
+	// expiresIn creates a key that does not expire.
+	CreateKey(ctx context.Context, ownerID, accountID int, name string, scopes []string, expiresIn time.Duration) (*models.NewAPIKey, error)
+	ListKeys(ctx context.Context, ownerID, accountID int) ([]*models.APIKey, error)
+	// RotateKey revokes the key and issues a replacement with the same name,
+	// scopes and lifetime.
+	RotateKey(ctx context.Context, ownerID, accountID int, keyID int64) (*models.NewAPIKey, error)
+	RevokeKey(ctx context.Context, ownerID, accountID int, keyID int64) error
+	// Authenticate resolves a presented key to the token of its service
+	// account.
+	Authenticate(ctx context.Context, key string) (*TokenInfo, error)
+}
+
+type apiKeyService struct {
+	users    repository.UserRepository
+	keys     repository.APIKeyRepository
+	lastUsed *throttle
+}
+
+func NewAPIKeyService(users repository.UserRepository, keys repository.APIKeyRepository) APIKeyService {
+	return &apiKeyService{
+		users:    users,
+		keys:     keys,
+		lastUsed: newThrottle(lastUsedInterval),
+	}
+}
+
+// APIKeyTokenID is the token ID of connections authenticated with the key,
+// used to close them when the key is revoked.
+func APIKeyTokenID(keyID int64) string {
+	return "apikey:" + strconv.FormatInt(keyID, 10)
+}
+
+func (s *apiKeyService) CreateServiceAccount(ctx context.Context, ownerID int, name string) (*models.User, error) {
+	name = strings.TrimSpace(name)
+	if !usernamePattern.MatchString(name) {
+		return nil, ErrInvalidAccountName
+	}
+
+	owner, err := s.users.GetByID(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if owner.IsServiceAccount {
+		return nil, ErrNestedServiceAccount
+	}
+
+	account := &models.User{
+		Username: name,
+		// Service accounts have no password and cannot log in.
+		PasswordHash:     "!",
+		CreatedAt:        time.Now(),
+		IsServiceAccount: true,
+		OwnerID:          ownerID,
+		Role:             string(policy.RoleUser),
+	}
+	if err := s.users.Create(ctx, account); err != nil {
+		if errors.Is(err, repository.ErrUserExists) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *apiKeyService) ListServiceAccounts(ctx context.Context, ownerID int) ([]*models.User, error) {
+	accounts, err := s.users.GetServiceAccounts(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if accounts == nil {
+		accounts = []*models.User{}
+	}
+	return accounts, nil
+}
+
+func (s *apiKeyService) CreateKey(ctx context.Context, ownerID, accountID int, name string, scopes []string, expiresIn time.Duration) (*models.NewAPIKey, error) {
+	if err := s.checkOwner(ctx, ownerID, accountID); err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return nil, ErrInvalidKeyName
+	}
+	if expiresIn < 0 {
+		return nil, ErrInvalidKeyExpiry
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	var expiresAt *time.Time
+	if expiresIn > 0 {
+		at := time.Now().Add(expiresIn)
+		expiresAt = &at
+	}
+	return s.issueKey(ctx, accountID, name, scopes, expiresAt)
+}
+
+func (s *apiKeyService) ListKeys(ctx context.Context, ownerID, accountID int) ([]*models.APIKey, error) {
+	if err := s.checkOwner(ctx, ownerID, accountID); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.keys.GetByUser(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = []*models.APIKey{}
+	}
+	return keys, nil
+}
+
+func (s *apiKeyService) RotateKey(ctx context.Context, ownerID, accountID int, keyID int64) (*models.NewAPIKey, error) {
+	old, err := s.ownedKey(ctx, ownerID, accountID, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if old.RevokedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if old.ExpiresAt != nil {
+		at := now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+		expiresAt = &at
+	}
+
+	key, err := s.issueKey(ctx, accountID, old.Name, old.Scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.keys.Revoke(ctx, old.ID, now); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *apiKeyService) RevokeKey(ctx context.Context, ownerID, accountID int, keyID int64) error {
+	key, err := s.ownedKey(ctx, ownerID, accountID, keyID)
+	if err != nil {
+		return err
+	}
+	return s.keys.Revoke(ctx, key.ID, time.Now())
+}
+
+func (s *apiKeyService) Authenticate(ctx context.Context, key string) (*TokenInfo, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	stored, err := s.keys.GetByHash(ctx, hashToken(key))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil || (stored.ExpiresAt != nil && !now.Before(*stored.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if s.lastUsed.allow(APIKeyTokenID(stored.ID), now) {
+		if err := s.keys.TouchLastUsed(ctx, stored.ID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &TokenInfo{
+		UserID: stored.UserID,
+		ID:     APIKeyTokenID(stored.ID),
+		Scopes: stored.Scopes,
+		// Service accounts cannot be given elevated roles.
+		Role: policy.RoleUser,
+	}
+	if stored.ExpiresAt != nil {
+		info.ExpiresAt = *stored.ExpiresAt
+	}
+	return info, nil
+}
+
+func (s *apiKeyService) issueKey(ctx context.Context, accountID int, name string, scopes []string, expiresAt *time.Time) (*models.NewAPIKey, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	key := APIKeyPrefix + secret
+
+	stored := &models.APIKey{
+		UserID:    accountID,
+		Name:      name,
+		Prefix:    key[:apiKeyDisplayLength],
+		KeyHash:   hashToken(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.keys.Create(ctx, stored); err != nil {
+		return nil, err
+	}
+	return &models.NewAPIKey{APIKey: stored, Key: key}, nil
+}
+
+// checkOwner makes sure the account is a service account owned by ownerID.
+func (s *apiKeyService) checkOwner(ctx context.Context, ownerID, accountID int) error {
+	account, err := s.users.GetByID(ctx, accountID)
+	if err == sql.ErrNoRows {
+		return ErrServiceAccountNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !account.IsServiceAccount || account.OwnerID != ownerID {
+		return ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+func (s *apiKeyService) ownedKey(ctx context.Context, ownerID, accountID int, keyID int64) (*models.APIKey, error) {
+	if err := s.checkOwner(ctx, ownerID, accountID); err != nil {
+		return nil, err
+	}
+
+	key, err := s.keys.GetByID(ctx, keyID)
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.UserID != accountID {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+// normalizeScopes rejects unknown scopes and drops duplicates.
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrMissingScope
+	}
+
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !models.ValidScopes[scope] {
+			return nil, ErrInvalidScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
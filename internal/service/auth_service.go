@@ -1,37 +1,677 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/repository"
 	"github.com/chatapp/pkg/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrAccountLocked      = errors.New("account temporarily locked")
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrInvalidRefresh     = errors.New("invalid refresh token")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+	ErrInvalidTicket      = errors.New("invalid or expired ticket")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrUnknownUser        = errors.New("user does not exist")
+)
+
+const (
+	minPasswordLength = 8
+	// maxPasswordLength is bcrypt's input limit.
+	maxPasswordLength = 72
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+// dummyPasswordHash is compared against when the username does not exist, so
+// unknown and known usernames take the same time to reject.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
 type AuthService interface {
-	ValidateToken(tokenString string) (int, error)
+	Register(ctx context.Context, username, password string) (*models.User, error)
+	Login(ctx context.Context, username, password string, device *models.DeviceInfo) (*models.AuthTokens, error)
+	VerifyMFA(ctx context.Context, mfaToken, code string, device *models.DeviceInfo) (*models.AuthTokens, error)
+	IssueTokens(ctx context.Context, user *models.User, device *models.DeviceInfo) (*models.AuthTokens, error)
+	Refresh(ctx context.Context, refreshToken string, device *models.DeviceInfo) (*models.AuthTokens, error)
+	Logout(ctx context.Context, accessToken, refreshToken string) error
+	ValidateToken(ctx context.Context, tokenString string) (*TokenInfo, error)
+	IssueWSTicket(ctx context.Context, token *TokenInfo) (string, time.Time, error)
+	RedeemWSTicket(ctx context.Context, ticket string) (*TokenInfo, error)
+	PurgeExpiredTokens(ctx context.Context) (int64, error)
+	// ListSessions returns the devices the user is logged in on, marking
+	// currentID as the current one.
+	ListSessions(ctx context.Context, userID int, currentID string) ([]*models.AuthSession, error)
+	// RevokeSession logs the device out: its refresh tokens stop working
+	// and its access tokens are rejected until they would have expired.
+	RevokeSession(ctx context.Context, userID int, sessionID string) error
+}
+
+// TokenInfo describes a validated access token.
+type TokenInfo struct {
+	UserID    int
+	ID        string
+	ExpiresAt time.Time
+	// SessionID is the login session the token belongs to, if any.
+	SessionID string
+	// Role is the user's current role, loaded when the token is validated
+	// so role changes apply at once.
+	Role policy.Role
+	// Scopes limits what an API key may do. User tokens have none and are
+	// not limited.
+	Scopes []string
+}
+
+// HasScope reports whether the token may act within scope.
+func (t *TokenInfo) HasScope(scope string) bool {
+	if t.Scopes == nil {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAPIKey reports whether the token is a service account's API key.
+func (t *TokenInfo) IsAPIKey() bool {
+	return t.Scopes != nil
+}
+
+// AuthConfig controls login lockout and refresh token lifetime.
+type AuthConfig struct {
+	// MaxLoginAttempts consecutive failures lock the account for LoginLockout.
+	MaxLoginAttempts int
+	LoginLockout     time.Duration
+	RefreshTokenTTL  time.Duration
+	WSTicketTTL      time.Duration
+	// MFAChallengeTTL is how long a user has to enter their second factor
+	// after the password step.
+	MFAChallengeTTL time.Duration
 }
 
 type authService struct {
 	jwtService jwt.Service
+	users      repository.UserRepository
+	tokens     repository.TokenRepository
+	sessions   repository.AuthSessionRepository
+	identities repository.IdentityRepository
+	twoFactor  TwoFactorService
+	apiKeys    APIKeyService
+	cfg        AuthConfig
+	lastActive *throttle
+}
+
+// NewAuthService builds the auth service. identities maps the subjects of
+// tokens from external issuers to internal users.
+func NewAuthService(jwtService jwt.Service, users repository.UserRepository, tokens repository.TokenRepository, sessions repository.AuthSessionRepository, identities repository.IdentityRepository, twoFactor TwoFactorService, apiKeys APIKeyService, cfg AuthConfig) AuthService {
+	return &authService{
+		jwtService: jwtService,
+		users:      users,
+		tokens:     tokens,
+		sessions:   sessions,
+		identities: identities,
+		twoFactor:  twoFactor,
+		apiKeys:    apiKeys,
+		cfg:        cfg,
+		lastActive: newThrottle(lastUsedInterval),
+	}
 }
 
-func NewAuthService(jwtService jwt.Service) AuthService {
-	return &authService{jwtService: jwtService}
+func (s *authService) Register(ctx context.Context, username, password string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, errors.New("username must be 3-32 letters, digits, '.', '_' or '-'")
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return nil, errors.New("password must be between 8 and 72 bytes")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Username:     username,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+		Role:         string(policy.RoleUser),
+	}
+	if err := s.users.Create(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrUserExists) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *authService) Login(ctx context.Context, username, password string, device *models.DeviceInfo) (*models.AuthTokens, error) {
+	user, err := s.users.GetByUsername(ctx, strings.TrimSpace(username))
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return nil, ErrAccountLocked
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		if err := s.users.RecordFailedLogin(ctx, user.ID, s.cfg.MaxLoginAttempts, now.Add(s.cfg.LoginLockout)); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := s.users.ResetFailedLogins(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.TOTPEnabled {
+		return s.challengeMFA(ctx, user)
+	}
+	return s.IssueTokens(ctx, user, device)
+}
+
+// challengeMFA ends the password step of a 2FA account with a token that
+// VerifyMFA accepts together with the second factor.
+func (s *authService) challengeMFA(ctx context.Context, user *models.User) (*models.AuthTokens, error) {
+	challenge, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tokens.CreateMFAChallenge(ctx, hashToken(challenge), user.ID, time.Now().Add(s.cfg.MFAChallengeTTL)); err != nil {
+		return nil, err
+	}
+	return &models.AuthTokens{MFARequired: true, MFAToken: challenge}, nil
+}
+
+// VerifyMFA completes a 2FA login. Wrong codes count towards the account
+// lockout like wrong passwords do.
+func (s *authService) VerifyMFA(ctx context.Context, mfaToken, code string, device *models.DeviceInfo) (*models.AuthTokens, error) {
+	if mfaToken == "" {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	challengeHash := hashToken(mfaToken)
+	now := time.Now()
+	userID, err := s.tokens.GetMFAChallenge(ctx, challengeHash, now)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return nil, ErrAccountLocked
+	}
+
+	if err := s.twoFactor.Verify(ctx, user, code); err != nil {
+		if errors.Is(err, ErrInvalidCode) {
+			if err := s.users.RecordFailedLogin(ctx, user.ID, s.cfg.MaxLoginAttempts, now.Add(s.cfg.LoginLockout)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	deleted, err := s.tokens.DeleteMFAChallenge(ctx, challengeHash)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if user.FailedLogins > 0 {
+		if err := s.users.ResetFailedLogins(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.IssueTokens(ctx, user, device)
+}
+
+// IssueTokens starts a new session for a user authenticated by other means,
+// such as an external identity provider.
+func (s *authService) IssueTokens(ctx context.Context, user *models.User, device *models.DeviceInfo) (*models.AuthTokens, error) {
+	familyID, err := newFamilyID()
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		device = &models.DeviceInfo{}
+	}
+
+	// The session shares its ID with the refresh token family, so revoking
+	// one revokes the other.
+	now := time.Now()
+	err = s.sessions.Create(ctx, &models.AuthSession{
+		ID:           familyID,
+		UserID:       user.ID,
+		DeviceName:   truncate(device.Name, 128),
+		IP:           truncate(device.IP, 45),
+		UserAgent:    truncate(device.UserAgent, 255),
+		CreatedAt:    now,
+		LastActiveAt: now,
+		ExpiresAt:    now.Add(s.cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.issueTokens(ctx, user.ID, familyID)
+	if err != nil {
+		return nil, err
+	}
+	tokens.User = user
+	return tokens, nil
+}
+
+// Refresh exchanges a refresh token for a new pair. Each refresh token works
+// once; presenting one again means it leaked, so its whole family is revoked.
+func (s *authService) Refresh(ctx context.Context, refreshToken string, device *models.DeviceInfo) (*models.AuthTokens, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefresh
+	}
+
+	tokenHash := hashToken(refreshToken)
+	stored, err := s.tokens.GetRefreshToken(ctx, tokenHash)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefresh
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil || now.After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefresh
+	}
+
+	used, err := s.tokens.UseRefreshToken(ctx, tokenHash, now)
+	if err != nil {
+		return nil, err
+	}
+	if stored.UsedAt != nil || !used {
+		if err := s.endSession(ctx, stored.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefresh
+	}
+
+	ip := ""
+	if device != nil {
+		ip = truncate(device.IP, 45)
+	}
+	if err := s.sessions.Touch(ctx, stored.FamilyID, ip, now, now.Add(s.cfg.RefreshTokenTTL)); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, stored.UserID, stored.FamilyID)
+}
+
+// Logout ends the session of either token and denylists the access token
+// until it expires. Either token may be empty.
+func (s *authService) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	if accessToken == "" && refreshToken == "" {
+		return errors.New("no token to revoke")
+	}
+
+	now := time.Now()
+	if refreshToken != "" {
+		stored, err := s.tokens.GetRefreshToken(ctx, hashToken(refreshToken))
+		if err == sql.ErrNoRows {
+			return ErrInvalidRefresh
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.endSession(ctx, stored.FamilyID, now); err != nil {
+			return err
+		}
+	}
+
+	if accessToken != "" {
+		claims, err := s.jwtService.ValidateToken(accessToken)
+		if err != nil {
+			// An invalid or expired access token cannot be used anyway.
+			return nil
+		}
+		if sid := sessionIDFromClaims(claims); sid != "" {
+			if err := s.endSession(ctx, sid, now); err != nil {
+				return err
+			}
+		}
+		jti, _ := claims["jti"].(string)
+		exp, _ := claims["exp"].(float64)
+		if jti == "" {
+			return nil
+		}
+		if err := s.tokens.RevokeJTI(ctx, jti, time.Unix(int64(exp), 0)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *authService) ValidateToken(tokenString string) (int, error) {
+func (s *authService) ValidateToken(ctx context.Context, tokenString string) (*TokenInfo, error) {
 	if tokenString == "" {
-		return 0, errors.New("empty token")
+		return nil, errors.New("empty token")
+	}
+	if strings.HasPrefix(tokenString, APIKeyPrefix) {
+		return s.apiKeys.Authenticate(ctx, tokenString)
 	}
 
 	claims, err := s.jwtService.ValidateToken(tokenString)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	userID, err := s.userIDFromClaims(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &TokenInfo{UserID: userID}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		info.ExpiresAt = exp.Time
+	}
+
+	info.ID, _ = claims["jti"].(string)
+	info.SessionID = sessionIDFromClaims(claims)
+	if err := s.checkRevoked(ctx, info); err != nil {
+		return nil, err
+	}
+	if err := s.loadRole(ctx, info); err != nil {
+		return nil, err
+	}
+
+	if info.SessionID != "" && s.lastActive.allow(info.SessionID, time.Now()) {
+		if err := s.sessions.Touch(ctx, info.SessionID, "", time.Now(), time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// IssueWSTicket returns a short-lived, single-use ticket that opens a
+// WebSocket as the token's user, for clients that cannot send headers. The
+// ticket does not outlive the token.
+func (s *authService) IssueWSTicket(ctx context.Context, token *TokenInfo) (string, time.Time, error) {
+	now := time.Now()
+	if tokenExpired(token.ExpiresAt, now) {
+		return "", time.Time{}, ErrInvalidTicket
+	}
+	ticket, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := now.Add(s.cfg.WSTicketTTL)
+	if !token.ExpiresAt.IsZero() && token.ExpiresAt.Before(expiresAt) {
+		expiresAt = token.ExpiresAt
+	}
+	err = s.tokens.CreateTicket(ctx, &models.WSTicket{
+		TicketHash:     hashToken(ticket),
+		UserID:         token.UserID,
+		TokenID:        token.ID,
+		SessionID:      token.SessionID,
+		TokenExpiresAt: token.ExpiresAt,
+		ExpiresAt:      expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return ticket, expiresAt, nil
+}
+
+// RedeemWSTicket consumes the ticket and returns the token it stands for. The
+// token is checked against its expiry and the denylist again, since it may
+// have expired or been revoked after the ticket was issued.
+func (s *authService) RedeemWSTicket(ctx context.Context, ticket string) (*TokenInfo, error) {
+	if ticket == "" {
+		return nil, ErrInvalidTicket
 	}
 
-	userID, ok := claims["id"].(float64)
-	if !ok {
+	now := time.Now()
+	stored, err := s.tokens.ConsumeTicket(ctx, hashToken(ticket), now)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidTicket
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tokenExpired(stored.TokenExpiresAt, now) {
+		return nil, ErrInvalidTicket
+	}
+
+	info := &TokenInfo{
+		UserID:    stored.UserID,
+		ID:        stored.TokenID,
+		SessionID: stored.SessionID,
+		ExpiresAt: stored.TokenExpiresAt,
+	}
+	if err := s.checkRevoked(ctx, info); err != nil {
+		return nil, err
+	}
+	if err := s.loadRole(ctx, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// tokenExpired reports whether a token expiring at expiresAt is no longer
+// valid. A zero time means the token does not expire.
+func tokenExpired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// loadRole sets the token's role from the user's record, rejecting tokens of
+// users that no longer exist.
+func (s *authService) loadRole(ctx context.Context, token *TokenInfo) error {
+	user, err := s.users.GetByID(ctx, token.UserID)
+	if err == sql.ErrNoRows {
+		return ErrUnknownUser
+	}
+	if err != nil {
+		return err
+	}
+	token.Role = policy.Role(user.Role)
+	return nil
+}
+
+// checkRevoked rejects tokens that were revoked themselves or whose session
+// was ended.
+func (s *authService) checkRevoked(ctx context.Context, token *TokenInfo) error {
+	var ids []string
+	if token.ID != "" {
+		ids = append(ids, token.ID)
+	}
+	if token.SessionID != "" {
+		ids = append(ids, token.SessionID)
+	}
+
+	revoked, err := s.tokens.IsRevoked(ctx, ids...)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// userIDFromClaims reads our own "id" claim. Tokens from external issuers,
+// which carry an iss, are mapped through the identity linked to their issuer
+// and subject; their other claims are not trusted to name a local user.
+func (s *authService) userIDFromClaims(ctx context.Context, claims gojwt.MapClaims) (int, error) {
+	issuer, _ := claims.GetIssuer()
+	if issuer == "" {
+		if userID, ok := claims["id"].(float64); ok && userID > 0 {
+			return int(userID), nil
+		}
 		return 0, errors.New("invalid user ID in token")
 	}
 
-	return int(userID), nil
+	subject, _ := claims.GetSubject()
+	if subject == "" || s.identities == nil {
+		return 0, ErrUnknownUser
+	}
+	userID, err := s.identities.GetUserID(ctx, issuer, subject)
+	if err == sql.ErrNoRows {
+		return 0, ErrUnknownUser
+	}
+	return userID, err
+}
+
+// sessionIDFromClaims returns the login session of one of our own tokens.
+// External tokens do not belong to a session here.
+func sessionIDFromClaims(claims gojwt.MapClaims) string {
+	if issuer, _ := claims.GetIssuer(); issuer != "" {
+		return ""
+	}
+	sid, _ := claims["sid"].(string)
+	return sid
+}
+
+func (s *authService) PurgeExpiredTokens(ctx context.Context) (int64, error) {
+	now := time.Now()
+	purged, err := s.tokens.DeleteExpired(ctx, now)
+	if err != nil {
+		return purged, err
+	}
+	sessions, err := s.sessions.DeleteExpired(ctx, now)
+	return purged + sessions, err
+}
+
+func (s *authService) ListSessions(ctx context.Context, userID int, currentID string) ([]*models.AuthSession, error) {
+	sessions, err := s.sessions.GetActive(ctx, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []*models.AuthSession{}
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == currentID
+	}
+	return sessions, nil
+}
+
+func (s *authService) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	session, err := s.sessions.Get(ctx, sessionID)
+	if err == sql.ErrNoRows {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID || session.RevokedAt != nil {
+		return ErrSessionNotFound
+	}
+	return s.endSession(ctx, sessionID, time.Now())
+}
+
+// endSession revokes the session's refresh tokens and denylists its ID for as
+// long as an access token issued now would live, so every access token of
+// the session is rejected.
+func (s *authService) endSession(ctx context.Context, sessionID string, now time.Time) error {
+	if err := s.tokens.RevokeFamily(ctx, sessionID, now); err != nil {
+		return err
+	}
+	if _, err := s.sessions.Revoke(ctx, sessionID, now); err != nil {
+		return err
+	}
+	return s.tokens.RevokeJTI(ctx, sessionID, now.Add(s.jwtService.TTL()))
+}
+
+func (s *authService) issueTokens(ctx context.Context, userID int, familyID string) (*models.AuthTokens, error) {
+	accessToken, err := s.jwtService.GenerateToken(userID, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.tokens.CreateRefreshToken(ctx, &models.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: now.Add(s.cfg.RefreshTokenTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthTokens{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.jwtService.TTL().Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// randomToken returns n random bytes encoded as URL-safe base64.
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func newFamilyID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// truncate cuts s to at most n bytes so it fits its column.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
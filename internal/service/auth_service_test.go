@@ -0,0 +1,467 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/pkg/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+type testAuth struct {
+	AuthService
+	jwt        jwt.Service
+	users      *fakeUserRepo
+	tokens     *fakeTokenRepo
+	sessions   *fakeAuthSessions
+	identities *fakeIdentities
+}
+
+func newTestAuth(t *testing.T, cfg AuthConfig) *testAuth {
+	t.Helper()
+	return newTestAuthWithJWT(t, cfg, jwt.Config{})
+}
+
+// newTestAuthWithJWT also trusts the external sources in jwtCfg.
+func newTestAuthWithJWT(t *testing.T, cfg AuthConfig, jwtCfg jwt.Config) *testAuth {
+	t.Helper()
+	jwtCfg.Secret = "test-secret"
+	jwtCfg.TTL = 15 * time.Minute
+	jwtService, err := jwt.NewJWTService(jwtCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxLoginAttempts == 0 {
+		cfg.MaxLoginAttempts = 3
+	}
+	if cfg.LoginLockout == 0 {
+		cfg.LoginLockout = time.Minute
+	}
+	if cfg.RefreshTokenTTL == 0 {
+		cfg.RefreshTokenTTL = time.Hour
+	}
+	if cfg.WSTicketTTL == 0 {
+		cfg.WSTicketTTL = 30 * time.Second
+	}
+	if cfg.MFAChallengeTTL == 0 {
+		cfg.MFAChallengeTTL = 5 * time.Minute
+	}
+
+	a := &testAuth{
+		jwt:        jwtService,
+		users:      newFakeUserRepo(),
+		tokens:     newFakeTokenRepo(),
+		sessions:   newFakeAuthSessions(),
+		identities: newFakeIdentities(),
+	}
+	a.AuthService = NewAuthService(jwtService, a.users, a.tokens, a.sessions, a.identities, NewTwoFactorService(a.users, "ChatApp"), nil, cfg)
+	return a
+}
+
+func (a *testAuth) register(t *testing.T, username, password string) *models.User {
+	t.Helper()
+	user, err := a.Register(context.Background(), username, password)
+	if err != nil {
+		t.Fatalf("Register(%q): %v", username, err)
+	}
+	return user
+}
+
+func (a *testAuth) login(t *testing.T, username, password string) *models.AuthTokens {
+	t.Helper()
+	tokens, err := a.Login(context.Background(), username, password, &models.DeviceInfo{Name: "test"})
+	if err != nil {
+		t.Fatalf("Login(%q): %v", username, err)
+	}
+	return tokens
+}
+
+func TestRegisterValidatesInput(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{})
+	ctx := context.Background()
+
+	cases := map[string][2]string{
+		"short username":   {"ab", "long-enough"},
+		"invalid username": {"ana maria", "long-enough"},
+		"short password":   {"ana", "short"},
+		"long password":    {"ana", string(make([]byte, 73))},
+	}
+	for name, c := range cases {
+		if _, err := auth.Register(ctx, c[0], c[1]); err == nil {
+			t.Errorf("%s: registered", name)
+		}
+	}
+
+	user := auth.register(t, " ana ", "correct-horse")
+	if user.ID == 0 || user.Username != "ana" || user.Role != "user" {
+		t.Fatalf("registered %+v", user)
+	}
+	if user.PasswordHash == "" || user.PasswordHash == "correct-horse" {
+		t.Fatal("password was not hashed")
+	}
+	if _, err := auth.Register(ctx, "ANA", "another-pass"); !errors.Is(err, ErrUsernameTaken) {
+		t.Fatalf("duplicate username: %v, want ErrUsernameTaken", err)
+	}
+}
+
+func TestLoginIssuesValidTokens(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{})
+	ctx := context.Background()
+	user := auth.register(t, "ana", "correct-horse")
+
+	tokens := auth.login(t, "ana", "correct-horse")
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.User.ID != user.ID {
+		t.Fatalf("tokens = %+v", tokens)
+	}
+	info, err := auth.ValidateToken(ctx, tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.UserID != user.ID || info.SessionID == "" || info.Role != "user" {
+		t.Fatalf("token info = %+v", info)
+	}
+
+	for _, c := range [][2]string{{"ana", "wrong-horse"}, {"nobody", "correct-horse"}} {
+		if _, err := auth.Login(ctx, c[0], c[1], nil); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Login(%q, %q) = %v, want ErrInvalidCredentials", c[0], c[1], err)
+		}
+	}
+}
+
+func TestLoginLocksAccountAfterRepeatedFailures(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{MaxLoginAttempts: 3, LoginLockout: time.Hour})
+	ctx := context.Background()
+	auth.register(t, "ana", "correct-horse")
+
+	for i := 0; i < 3; i++ {
+		if _, err := auth.Login(ctx, "ana", "wrong-horse", nil); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	if _, err := auth.Login(ctx, "ana", "correct-horse", nil); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("login while locked = %v, want ErrAccountLocked", err)
+	}
+
+	// Let the lock lapse without waiting for it.
+	stored, _ := auth.users.GetByUsername(ctx, "ana")
+	auth.users.mu.Lock()
+	lapsed := time.Now().Add(-time.Second)
+	auth.users.users[stored.ID].LockedUntil = &lapsed
+	auth.users.mu.Unlock()
+
+	auth.login(t, "ana", "correct-horse")
+	stored, _ = auth.users.GetByUsername(ctx, "ana")
+	if stored.FailedLogins != 0 || stored.LockedUntil != nil {
+		t.Fatalf("failures not reset after login: %d, %v", stored.FailedLogins, stored.LockedUntil)
+	}
+}
+
+func TestSuccessfulLoginResetsFailureCount(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{MaxLoginAttempts: 3})
+	ctx := context.Background()
+	auth.register(t, "ana", "correct-horse")
+
+	// Two failures, a success, then two more failures stay under the limit.
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 2; i++ {
+			auth.Login(ctx, "ana", "wrong-horse", nil)
+		}
+		auth.login(t, "ana", "correct-horse")
+	}
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{})
+	ctx := context.Background()
+	user := auth.register(t, "ana", "correct-horse")
+	first := auth.login(t, "ana", "correct-horse")
+
+	second, err := auth.Refresh(ctx, first.RefreshToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("refresh did not rotate the tokens")
+	}
+	info, err := auth.ValidateToken(ctx, second.AccessToken)
+	if err != nil || info.UserID != user.ID {
+		t.Fatalf("refreshed access token: %+v, %v", info, err)
+	}
+	if _, err := auth.Refresh(ctx, second.RefreshToken, nil); err != nil {
+		t.Fatalf("refreshing the rotated token: %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{})
+	ctx := context.Background()
+	auth.register(t, "ana", "correct-horse")
+	first := auth.login(t, "ana", "correct-horse")
+
+	second, err := auth.Refresh(ctx, first.RefreshToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first token was stolen and replayed.
+	if _, err := auth.Refresh(ctx, first.RefreshToken, nil); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("reused refresh token = %v, want ErrInvalidRefresh", err)
+	}
+	if _, err := auth.Refresh(ctx, second.RefreshToken, nil); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("token of the revoked family = %v, want ErrInvalidRefresh", err)
+	}
+	if _, err := auth.ValidateToken(ctx, second.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("access token of the revoked family = %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestRefreshRejectsExpiredTokens(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{RefreshTokenTTL: 50 * time.Millisecond})
+	ctx := context.Background()
+	auth.register(t, "ana", "correct-horse")
+	tokens := auth.login(t, "ana", "correct-horse")
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := auth.Refresh(ctx, tokens.RefreshToken, nil); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("expired refresh token = %v, want ErrInvalidRefresh", err)
+	}
+	if _, err := auth.Refresh(ctx, "not-a-token", nil); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("unknown refresh token = %v, want ErrInvalidRefresh", err)
+	}
+}
+
+func TestLogoutRevokesAccessAndRefreshTokens(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{})
+	ctx := context.Background()
+	auth.register(t, "ana", "correct-horse")
+	phone := auth.login(t, "ana", "correct-horse")
+	laptop := auth.login(t, "ana", "correct-horse")
+
+	if err := auth.Logout(ctx, phone.AccessToken, phone.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ValidateToken(ctx, phone.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("access token after logout = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := auth.Refresh(ctx, phone.RefreshToken, nil); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("refresh token after logout = %v, want ErrInvalidRefresh", err)
+	}
+
+	// Other devices stay logged in.
+	if _, err := auth.ValidateToken(ctx, laptop.AccessToken); err != nil {
+		t.Fatalf("other session's access token: %v", err)
+	}
+	if _, err := auth.Refresh(ctx, laptop.RefreshToken, nil); err != nil {
+		t.Fatalf("other session's refresh token: %v", err)
+	}
+}
+
+func TestWSTicketRedeemsOnce(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{})
+	ctx := context.Background()
+	user := auth.register(t, "ana", "correct-horse")
+	info, err := auth.ValidateToken(ctx, auth.login(t, "ana", "correct-horse").AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket, _, err := auth.IssueWSTicket(ctx, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redeemed, err := auth.RedeemWSTicket(ctx, ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redeemed.UserID != user.ID || redeemed.ID != info.ID || redeemed.SessionID != info.SessionID {
+		t.Fatalf("redeemed token = %+v, want %+v", redeemed, info)
+	}
+	if _, err := auth.RedeemWSTicket(ctx, ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("second redeem = %v, want ErrInvalidTicket", err)
+	}
+}
+
+func TestWSTicketDoesNotOutliveToken(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{WSTicketTTL: time.Minute})
+	ctx := context.Background()
+	user := auth.register(t, "ana", "correct-horse")
+	token := &TokenInfo{UserID: user.ID, ID: "jti", ExpiresAt: time.Now().Add(10 * time.Second)}
+
+	_, expiresAt, err := auth.IssueWSTicket(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresAt.After(token.ExpiresAt) {
+		t.Fatalf("ticket expires at %v, after the token at %v", expiresAt, token.ExpiresAt)
+	}
+
+	expired := &TokenInfo{UserID: user.ID, ID: "old", ExpiresAt: time.Now().Add(-time.Second)}
+	if _, _, err := auth.IssueWSTicket(ctx, expired); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("ticket for an expired token = %v, want ErrInvalidTicket", err)
+	}
+}
+
+func TestWSTicketRejectsExpiredToken(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{})
+	ctx := context.Background()
+	user := auth.register(t, "ana", "correct-horse")
+	ticket, _, err := auth.IssueWSTicket(ctx, &TokenInfo{UserID: user.ID, ID: "jti", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A ticket stored before tickets were capped can outlive its token.
+	for _, stored := range auth.tokens.tickets {
+		stored.TokenExpiresAt = time.Now().Add(-time.Second)
+	}
+	if _, err := auth.RedeemWSTicket(ctx, ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("redeem after the token expired = %v, want ErrInvalidTicket", err)
+	}
+}
+
+// newExternalIssuer returns a key of an identity provider and a JWKS source
+// that trusts it for tokens addressed to chatapp.
+func newExternalIssuer(t *testing.T, issuer string) (*jwt.Key, *jwt.JWKSSource) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwt.NewKey(issuer+"-key", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := key.ToJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(jwt.JWKSet{Keys: []jwt.JWK{jwk}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := jwt.NewJWKSSource(path, issuer, "chatapp", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, source
+}
+
+func signExternal(t *testing.T, key *jwt.Key, claims gojwt.MapClaims) string {
+	t.Helper()
+	token := gojwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestExternalTokensMapThroughLinkedIdentity(t *testing.T) {
+	key, source := newExternalIssuer(t, "https://idp.example")
+	auth := newTestAuthWithJWT(t, AuthConfig{}, jwt.Config{External: []*jwt.JWKSSource{source}})
+	ctx := context.Background()
+	ana := auth.register(t, "ana", "correct-horse")
+	bob := auth.register(t, "bob", "correct-horse")
+	if err := auth.identities.Link(ctx, "https://idp.example", "ext-ana", ana.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The id, a numeric sub and sid mean nothing coming from another issuer.
+	token := signExternal(t, key, gojwt.MapClaims{
+		"iss": "https://idp.example",
+		"aud": "chatapp",
+		"sub": "ext-ana",
+		"id":  bob.ID,
+		"sid": "someone-elses-session",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	info, err := auth.ValidateToken(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.UserID != ana.ID || info.SessionID != "" {
+		t.Fatalf("token info = %+v, want user %d without a session", info, ana.ID)
+	}
+
+	for name, sub := range map[string]string{
+		"unlinked subject": "ext-bob",
+		"numeric subject":  "2",
+	} {
+		token := signExternal(t, key, gojwt.MapClaims{
+			"iss": "https://idp.example",
+			"aud": "chatapp",
+			"sub": sub,
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})
+		if _, err := auth.ValidateToken(ctx, token); !errors.Is(err, ErrUnknownUser) {
+			t.Errorf("%s: ValidateToken = %v, want ErrUnknownUser", name, err)
+		}
+	}
+}
+
+func TestExternalTokensAreBoundToTheirIssuer(t *testing.T) {
+	key, source := newExternalIssuer(t, "https://idp.example")
+	auth := newTestAuthWithJWT(t, AuthConfig{}, jwt.Config{External: []*jwt.JWKSSource{source}})
+	ctx := context.Background()
+	ana := auth.register(t, "ana", "correct-horse")
+	if err := auth.identities.Link(ctx, "https://other.example", "ext-ana", ana.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key is trusted for idp.example only, whatever the token claims.
+	token := signExternal(t, key, gojwt.MapClaims{
+		"iss": "https://other.example",
+		"aud": "chatapp",
+		"sub": "ext-ana",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	if _, err := auth.ValidateToken(ctx, token); err == nil {
+		t.Fatal("token from another issuer was accepted")
+	}
+}
+
+func TestLoginWithTwoFactorRequiresCode(t *testing.T) {
+	auth := newTestAuth(t, AuthConfig{MaxLoginAttempts: 2})
+	ctx := context.Background()
+	user := auth.register(t, "ana", "correct-horse")
+	secret, _ := enrollTOTP(t, NewTwoFactorService(auth.users, "ChatApp"), user.ID)
+
+	challenge := auth.login(t, "ana", "correct-horse")
+	if !challenge.MFARequired || challenge.MFAToken == "" || challenge.AccessToken != "" {
+		t.Fatalf("login with 2FA = %+v, want only an MFA challenge", challenge)
+	}
+
+	// A wrong code counts towards the lockout.
+	if _, err := auth.VerifyMFA(ctx, challenge.MFAToken, "000000", nil); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("wrong code = %v, want ErrInvalidCode", err)
+	}
+	if stored, _ := auth.users.GetByID(ctx, user.ID); stored.FailedLogins != 1 {
+		t.Fatalf("failed logins = %d, want 1", stored.FailedLogins)
+	}
+
+	tokens, err := auth.VerifyMFA(ctx, challenge.MFAToken, currentCode(t, secret, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken == "" || tokens.User.ID != user.ID {
+		t.Fatalf("tokens = %+v", tokens)
+	}
+	if stored, _ := auth.users.GetByID(ctx, user.ID); stored.FailedLogins != 0 {
+		t.Fatalf("failed logins after success = %d, want 0", stored.FailedLogins)
+	}
+	if _, err := auth.VerifyMFA(ctx, challenge.MFAToken, currentCode(t, secret, 0), nil); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("reused challenge = %v, want ErrInvalidMFAChallenge", err)
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/repository"
+)
+
+var (
+	ErrSelfBlock  = errors.New("cannot block yourself")
+	ErrNotBlocked = errors.New("user is not blocked")
+)
+
+// BlockChecker reports blocks between users. A block hides presence in both
+// directions, whoever created it.
+type BlockChecker interface {
+	BlockedAmong(ctx context.Context, userID int, candidates []int) (map[int]bool, error)
+	// BlocksBetween looks up the blocks between each pair of users in both
+	// directions with one query. The result holds blocker → blocked pairs.
+	BlocksBetween(ctx context.Context, pairs [][2]int) (map[[2]int]bool, error)
+}
+
+// BlockService manages the users a user has blocked. Blocked users are not
+// told: their messages are acknowledged but never delivered.
+type BlockService interface {
+	BlockChecker
+
+	Block(ctx context.Context, userID, blockedID int) error
+	Unblock(ctx context.Context, userID, blockedID int) error
+	ListBlocked(ctx context.Context, userID int) ([]*models.BlockedUser, error)
+}
+
+type blockService struct {
+	repo  repository.BlockRepository
+	users repository.UserRepository
+}
+
+func NewBlockService(repo repository.BlockRepository, users repository.UserRepository) BlockService {
+	return &blockService{repo: repo, users: users}
+}
+
+// Block also ends any contact or pending contact request between the users.
+func (s *blockService) Block(ctx context.Context, userID, blockedID int) error {
+	if userID <= 0 || blockedID <= 0 {
+		return errors.New("invalid user ID")
+	}
+	if userID == blockedID {
+		return ErrSelfBlock
+	}
+
+	if _, err := s.users.GetByID(ctx, blockedID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUnknownUser
+		}
+		return err
+	}
+
+	return s.repo.Block(ctx, userID, blockedID, time.Now())
+}
+
+func (s *blockService) Unblock(ctx context.Context, userID, blockedID int) error {
+	removed, err := s.repo.Unblock(ctx, userID, blockedID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrNotBlocked
+	}
+	return nil
+}
+
+func (s *blockService) ListBlocked(ctx context.Context, userID int) ([]*models.BlockedUser, error) {
+	blocked, err := s.repo.GetBlocked(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked == nil {
+		blocked = []*models.BlockedUser{}
+	}
+	return blocked, nil
+}
+
+func (s *blockService) BlockedAmong(ctx context.Context, userID int, candidates []int) (map[int]bool, error) {
+	ids, err := s.repo.FilterBlocked(ctx, userID, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		blocked[id] = true
+	}
+	return blocked, nil
+}
+
+func (s *blockService) BlocksBetween(ctx context.Context, pairs [][2]int) (map[[2]int]bool, error) {
+	both := make([][2]int, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		both = append(both, pair, [2]int{pair[1], pair[0]})
+	}
+
+	found, err := s.repo.FilterBlockPairs(ctx, both)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make(map[[2]int]bool, len(found))
+	for _, pair := range found {
+		blocks[pair] = true
+	}
+	return blocks, nil
+}
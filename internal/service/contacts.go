@@ -0,0 +1,350 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/repository"
+)
+
+var (
+	ErrContactRequestNotFound = errors.New("contact request not found")
+	ErrContactRequestPending  = errors.New("a contact request between these users is already pending")
+	ErrAlreadyContacts        = errors.New("users are already contacts")
+	ErrNotContact             = errors.New("user is not a contact")
+	ErrSelfContact            = errors.New("cannot add yourself as a contact")
+	ErrContactNotAllowed      = errors.New("cannot send a contact request to this user")
+)
+
+// ContactChecker decides which users count as a user's contacts for privacy
+// purposes.
+type ContactChecker interface {
+	ContactsAmong(ctx context.Context, userID int, candidates []int) (map[int]bool, error)
+}
+
+// ContactService manages friend requests and the contact lists they build.
+// Contacts are mutual: accepting a request adds each user to the other's
+// list.
+type ContactService interface {
+	ContactChecker
+	MessageFilter
+
+	SendRequest(ctx context.Context, senderID, receiverID int) (*models.ContactRequest, error)
+	// AcceptRequest and DeclineRequest are for the receiver, CancelRequest
+	// for the sender.
+	AcceptRequest(ctx context.Context, userID int, requestID int64) (*models.ContactRequest, error)
+	DeclineRequest(ctx context.Context, userID int, requestID int64) (*models.ContactRequest, error)
+	CancelRequest(ctx context.Context, userID int, requestID int64) (*models.ContactRequest, error)
+	ListRequests(ctx context.Context, userID int) (*models.ContactRequests, error)
+	ListContacts(ctx context.Context, userID int) ([]*models.Contact, error)
+	RemoveContact(ctx context.Context, userID, contactID int) error
+	GetSettings(ctx context.Context, userID int) (*models.ContactSettings, error)
+	UpdateSettings(ctx context.Context, userID int, settings *models.ContactSettings) error
+}
+
+type contactService struct {
+	repo   repository.ContactRepository
+	blocks BlockChecker
+	users  repository.UserRepository
+}
+
+func NewContactService(repo repository.ContactRepository, blocks BlockChecker, users repository.UserRepository) ContactService {
+	return &contactService{repo: repo, blocks: blocks, users: users}
+}
+
+func (s *contactService) SendRequest(ctx context.Context, senderID, receiverID int) (*models.ContactRequest, error) {
+	if senderID <= 0 || receiverID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+	if senderID == receiverID {
+		return nil, ErrSelfContact
+	}
+
+	if _, err := s.users.GetByID(ctx, receiverID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownUser
+		}
+		return nil, err
+	}
+
+	blocked, err := s.blocks.BlockedAmong(ctx, senderID, []int{receiverID})
+	if err != nil {
+		return nil, err
+	}
+	if blocked[receiverID] {
+		return nil, ErrContactNotAllowed
+	}
+
+	contacts, err := s.repo.FilterContacts(ctx, senderID, []int{receiverID})
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) > 0 {
+		return nil, ErrAlreadyContacts
+	}
+
+	_, err = s.repo.GetPendingBetween(ctx, senderID, receiverID)
+	if err == nil {
+		return nil, ErrContactRequestPending
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	req := &models.ContactRequest{
+		SenderID:   senderID,
+		ReceiverID: receiverID,
+		Status:     models.ContactRequestPending,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.repo.CreateRequest(ctx, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (s *contactService) AcceptRequest(ctx context.Context, userID int, requestID int64) (*models.ContactRequest, error) {
+	req, err := s.pendingRequest(ctx, requestID, func(req *models.ContactRequest) bool {
+		return req.ReceiverID == userID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	accepted, err := s.repo.AcceptRequest(ctx, req, now)
+	if err != nil {
+		return nil, err
+	}
+	if !accepted {
+		return nil, ErrContactRequestNotFound
+	}
+
+	req.Status = models.ContactRequestAccepted
+	req.RespondedAt = &now
+	return req, nil
+}
+
+func (s *contactService) DeclineRequest(ctx context.Context, userID int, requestID int64) (*models.ContactRequest, error) {
+	return s.closeRequest(ctx, requestID, models.ContactRequestDeclined, func(req *models.ContactRequest) bool {
+		return req.ReceiverID == userID
+	})
+}
+
+func (s *contactService) CancelRequest(ctx context.Context, userID int, requestID int64) (*models.ContactRequest, error) {
+	return s.closeRequest(ctx, requestID, models.ContactRequestCancelled, func(req *models.ContactRequest) bool {
+		return req.SenderID == userID
+	})
+}
+
+func (s *contactService) closeRequest(ctx context.Context, requestID int64, status string, allowed func(*models.ContactRequest) bool) (*models.ContactRequest, error) {
+	req, err := s.pendingRequest(ctx, requestID, allowed)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	closed, err := s.repo.CloseRequest(ctx, requestID, status, now)
+	if err != nil {
+		return nil, err
+	}
+	if !closed {
+		return nil, ErrContactRequestNotFound
+	}
+
+	req.Status = status
+	req.RespondedAt = &now
+	return req, nil
+}
+
+// pendingRequest loads a pending request the user may act on. Requests of
+// other users are reported as not found.
+func (s *contactService) pendingRequest(ctx context.Context, requestID int64, allowed func(*models.ContactRequest) bool) (*models.ContactRequest, error) {
+	req, err := s.repo.GetRequest(ctx, requestID)
+	if err == sql.ErrNoRows {
+		return nil, ErrContactRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.Status != models.ContactRequestPending || !allowed(req) {
+		return nil, ErrContactRequestNotFound
+	}
+	return req, nil
+}
+
+func (s *contactService) ListRequests(ctx context.Context, userID int) (*models.ContactRequests, error) {
+	requests, err := s.repo.GetPendingRequests(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.ContactRequests{
+		Incoming: []*models.ContactRequest{},
+		Outgoing: []*models.ContactRequest{},
+	}
+	for _, req := range requests {
+		if req.ReceiverID == userID {
+			result.Incoming = append(result.Incoming, req)
+		} else {
+			result.Outgoing = append(result.Outgoing, req)
+		}
+	}
+	return result, nil
+}
+
+func (s *contactService) ListContacts(ctx context.Context, userID int) ([]*models.Contact, error) {
+	contacts, err := s.repo.GetContacts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if contacts == nil {
+		contacts = []*models.Contact{}
+	}
+	return contacts, nil
+}
+
+func (s *contactService) RemoveContact(ctx context.Context, userID, contactID int) error {
+	removed, err := s.repo.RemoveContact(ctx, userID, contactID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrNotContact
+	}
+	return nil
+}
+
+func (s *contactService) GetSettings(ctx context.Context, userID int) (*models.ContactSettings, error) {
+	messagesFrom, err := s.repo.GetMessagesFrom(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ContactSettings{MessagesFrom: messagesFrom}, nil
+}
+
+func (s *contactService) UpdateSettings(ctx context.Context, userID int, settings *models.ContactSettings) error {
+	switch settings.MessagesFrom {
+	case models.VisibilityEveryone, models.VisibilityContacts:
+	default:
+		return errors.New("messages_from must be everyone or contacts")
+	}
+	return s.repo.SetMessagesFrom(ctx, userID, settings.MessagesFrom)
+}
+
+func (s *contactService) ContactsAmong(ctx context.Context, userID int, candidates []int) (map[int]bool, error) {
+	ids, err := s.repo.FilterContacts(ctx, userID, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		contacts[id] = true
+	}
+	return contacts, nil
+}
+
+// AllowMessages enforces the receivers' messages_from setting for a whole
+// batch: one query finds the receivers who only accept contacts, and one more
+// checks the senders writing to them.
+func (s *contactService) AllowMessages(ctx context.Context, pairs []MessagePair) (map[MessagePair]bool, error) {
+	allowed := make(map[MessagePair]bool, len(pairs))
+	receivers := make([]int, 0, len(pairs))
+	seen := make(map[int]bool, len(pairs))
+	for _, pair := range pairs {
+		allowed[pair] = true
+		if !seen[pair.ReceiverID] {
+			seen[pair.ReceiverID] = true
+			receivers = append(receivers, pair.ReceiverID)
+		}
+	}
+
+	restricted, err := s.repo.FilterContactsOnly(ctx, receivers)
+	if err != nil {
+		return nil, err
+	}
+	if len(restricted) == 0 {
+		return allowed, nil
+	}
+
+	contactsOnly := make(map[int]bool, len(restricted))
+	for _, id := range restricted {
+		contactsOnly[id] = true
+	}
+	var candidates [][2]int
+	for _, pair := range pairs {
+		if contactsOnly[pair.ReceiverID] {
+			allowed[pair] = false
+			candidates = append(candidates, [2]int{pair.ReceiverID, pair.SenderID})
+		}
+	}
+
+	contacts, err := s.repo.FilterContactPairs(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	for _, contact := range contacts {
+		allowed[MessagePair{SenderID: contact[1], ReceiverID: contact[0]}] = true
+	}
+	return allowed, nil
+}
+
+// NewConversationContacts treats everyone the user has exchanged messages
+// with as a contact.
+func NewConversationContacts(repo repository.MessageRepository) ContactChecker {
+	return &conversationContacts{repo: repo}
+}
+
+type conversationContacts struct {
+	repo repository.MessageRepository
+}
+
+func (c *conversationContacts) ContactsAmong(ctx context.Context, userID int, candidates []int) (map[int]bool, error) {
+	partners, err := c.repo.FilterConversationPartners(ctx, userID, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := make(map[int]bool, len(partners))
+	for _, id := range partners {
+		contacts[id] = true
+	}
+	return contacts, nil
+}
+
+// NewAnyContacts counts a user as a contact if any of the checkers does.
+func NewAnyContacts(checkers ...ContactChecker) ContactChecker {
+	return anyContacts(checkers)
+}
+
+type anyContacts []ContactChecker
+
+func (a anyContacts) ContactsAmong(ctx context.Context, userID int, candidates []int) (map[int]bool, error) {
+	contacts := make(map[int]bool)
+	for _, checker := range a {
+		remaining := make([]int, 0, len(candidates))
+		for _, id := range candidates {
+			if !contacts[id] {
+				remaining = append(remaining, id)
+			}
+		}
+		if len(remaining) == 0 {
+			break
+		}
+
+		found, err := checker.ContactsAmong(ctx, userID, remaining)
+		if err != nil {
+			return nil, err
+		}
+		for id, ok := range found {
+			if ok {
+				contacts[id] = true
+			}
+		}
+	}
+	return contacts, nil
+}
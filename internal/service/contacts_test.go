@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chatapp/internal/models"
+)
+
+func TestAllowMessagesResolvesBatchInTwoQueries(t *testing.T) {
+	repo := newFakeContactRepo([2]int{2, 1})
+	repo.messagesFrom[2] = models.VisibilityContacts
+	repo.messagesFrom[3] = models.VisibilityContacts
+	contacts := NewContactService(repo, newFakeBlocks(), newFakeUserRepo())
+
+	pairs := []MessagePair{
+		{SenderID: 1, ReceiverID: 2},
+		{SenderID: 3, ReceiverID: 2},
+		{SenderID: 1, ReceiverID: 3},
+		{SenderID: 2, ReceiverID: 4},
+	}
+	allowed, err := contacts.AllowMessages(context.Background(), pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[MessagePair]bool{
+		{SenderID: 1, ReceiverID: 2}: true,
+		{SenderID: 3, ReceiverID: 2}: false,
+		{SenderID: 1, ReceiverID: 3}: false,
+		{SenderID: 2, ReceiverID: 4}: true,
+	}
+	for pair, ok := range want {
+		if allowed[pair] != ok {
+			t.Errorf("allowed[%+v] = %v, want %v", pair, allowed[pair], ok)
+		}
+	}
+	if repo.queries != 2 {
+		t.Fatalf("made %d queries, want 2 for the whole batch", repo.queries)
+	}
+}
+
+func TestAllowMessagesSkipsContactLookupWhenNoneRestricted(t *testing.T) {
+	repo := newFakeContactRepo()
+	contacts := NewContactService(repo, newFakeBlocks(), newFakeUserRepo())
+
+	allowed, err := contacts.AllowMessages(context.Background(), []MessagePair{{SenderID: 1, ReceiverID: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed[MessagePair{SenderID: 1, ReceiverID: 2}] {
+		t.Fatal("message to a receiver accepting everyone was not allowed")
+	}
+	if repo.queries != 1 {
+		t.Fatalf("made %d queries, want 1", repo.queries)
+	}
+}
+
+func TestAnyContactsKeepsConversationPartners(t *testing.T) {
+	accepted := NewContactService(newFakeContactRepo([2]int{1, 2}), newFakeBlocks(), newFakeUserRepo())
+	partners := NewConversationContacts(&fakePartners{partners: map[[2]int]bool{{3, 1}: true}})
+	checker := NewAnyContacts(accepted, partners)
+
+	contacts, err := checker.ContactsAmong(context.Background(), 1, []int{2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contacts[2] || !contacts[3] || contacts[4] {
+		t.Fatalf("contacts = %v, want 2 (accepted) and 3 (conversation partner)", contacts)
+	}
+}
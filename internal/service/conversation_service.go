@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/repository"
+)
+
+const maxNicknameLength = 64
+
+// AlertPolicy applies the receivers' conversation settings to messages about
+// to be pushed to them. The hub and any other notification path must call it
+// before alerting a user.
+type AlertPolicy interface {
+	// ApplyAlerts sets Silent on the messages that should not alert their
+	// receiver.
+	ApplyAlerts(ctx context.Context, msgs []*models.Message) error
+}
+
+// ConversationService manages each user's per-conversation settings: mute,
+// notification level and a nickname for the other user.
+type ConversationService interface {
+	AlertPolicy
+
+	ListConversations(ctx context.Context, userID, limit int) ([]*models.Conversation, error)
+	GetSettings(ctx context.Context, userID, partnerID int) (*models.ConversationSettings, error)
+	UpdateSettings(ctx context.Context, userID, partnerID int, settings *models.ConversationSettings) error
+}
+
+type conversationService struct {
+	repo repository.ConversationRepository
+}
+
+func NewConversationService(repo repository.ConversationRepository) ConversationService {
+	return &conversationService{repo: repo}
+}
+
+func (s *conversationService) ListConversations(ctx context.Context, userID, limit int) ([]*models.Conversation, error) {
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+
+	conversations, err := s.repo.GetConversations(ctx, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if conversations == nil {
+		conversations = []*models.Conversation{}
+	}
+	return conversations, nil
+}
+
+func (s *conversationService) GetSettings(ctx context.Context, userID, partnerID int) (*models.ConversationSettings, error) {
+	if userID <= 0 || partnerID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+	return s.repo.GetSettings(ctx, userID, partnerID)
+}
+
+// UpdateSettings replaces the conversation's settings. An empty level means
+// NotifyAll, and a mute that already ended is cleared.
+func (s *conversationService) UpdateSettings(ctx context.Context, userID, partnerID int, settings *models.ConversationSettings) error {
+	if userID <= 0 || partnerID <= 0 || userID == partnerID {
+		return errors.New("invalid user ID")
+	}
+
+	switch settings.NotificationLevel {
+	case "":
+		settings.NotificationLevel = models.NotifyAll
+	case models.NotifyAll, models.NotifyMentions, models.NotifyNone:
+	default:
+		return errors.New("notification_level must be all, mentions or none")
+	}
+
+	settings.Nickname = strings.TrimSpace(settings.Nickname)
+	if utf8.RuneCountInString(settings.Nickname) > maxNicknameLength {
+		return errors.New("nickname is too long")
+	}
+	if settings.MutedUntil != nil && !settings.Muted(time.Now()) {
+		settings.MutedUntil = nil
+	}
+
+	return s.repo.SaveSettings(ctx, userID, partnerID, settings)
+}
+
+func (s *conversationService) ApplyAlerts(ctx context.Context, msgs []*models.Message) error {
+	senders := make(map[int][]int)
+	for _, msg := range msgs {
+		senders[msg.ReceiverID] = append(senders[msg.ReceiverID], msg.SenderID)
+	}
+
+	settings := make(map[int]map[int]*models.ConversationSettings, len(senders))
+	for receiverID, senderIDs := range senders {
+		byPartner, err := s.repo.GetSettingsFor(ctx, receiverID, senderIDs)
+		if err != nil {
+			return err
+		}
+		settings[receiverID] = byPartner
+	}
+
+	now := time.Now()
+	for _, msg := range msgs {
+		conversation, ok := settings[msg.ReceiverID][msg.SenderID]
+		msg.Silent = ok && !conversation.ShouldAlert(now, msg.Mentions(msg.ReceiverID))
+	}
+	return nil
+}
@@ -0,0 +1,809 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/repository"
+)
+
+// The fakes embed the repository interfaces they stand in for, so a test
+// that reaches a method the fake does not implement panics instead of
+// silently passing.
+
+type fakeMessageRepo struct {
+	repository.MessageRepository
+
+	mu       sync.Mutex
+	nextID   int64
+	messages []*models.Message
+	mentions map[int64][]int
+	failNext error
+}
+
+func newFakeMessageRepo() *fakeMessageRepo {
+	return &fakeMessageRepo{mentions: make(map[int64][]int)}
+}
+
+func (r *fakeMessageRepo) Create(ctx context.Context, message *models.Message) (int64, error) {
+	if err := r.CreateBatch(ctx, []*models.Message{message}); err != nil {
+		return 0, err
+	}
+	return message.ID, nil
+}
+
+func (r *fakeMessageRepo) CreateBatch(ctx context.Context, messages []*models.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.failNext; err != nil {
+		r.failNext = nil
+		return err
+	}
+	for _, message := range messages {
+		r.nextID++
+		message.ID = r.nextID
+		stored := *message
+		stored.Entities = append([]models.MessageEntity(nil), message.Entities...)
+		r.messages = append(r.messages, &stored)
+		if message.Dropped {
+			continue
+		}
+		for _, entity := range message.Entities {
+			if entity.Type == models.EntityMention {
+				r.mentions[message.ID] = append(r.mentions[message.ID], entity.UserID)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *fakeMessageRepo) GetMentions(ctx context.Context, userID int, before int64, limit int) ([]*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var found []*models.Message
+	for i := len(r.messages) - 1; i >= 0 && len(found) < limit; i-- {
+		msg := r.messages[i]
+		if before > 0 && msg.ID >= before {
+			continue
+		}
+		for _, mentioned := range r.mentions[msg.ID] {
+			if mentioned == userID {
+				found = append(found, msg)
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeMessageRepo) stored() []*models.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*models.Message(nil), r.messages...)
+}
+
+type fakeUserRepo struct {
+	repository.UserRepository
+
+	mu     sync.Mutex
+	users  map[int]*models.User
+	nextID int
+	// recovery holds each user's unused recovery code hashes.
+	recovery map[int]map[string]bool
+}
+
+func newFakeUserRepo(users ...*models.User) *fakeUserRepo {
+	r := &fakeUserRepo{users: make(map[int]*models.User), recovery: make(map[int]map[string]bool)}
+	for _, user := range users {
+		r.users[user.ID] = user
+		if user.ID > r.nextID {
+			r.nextID = user.ID
+		}
+	}
+	return r
+}
+
+func (r *fakeUserRepo) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if strings.EqualFold(existing.Username, user.Username) {
+			return repository.ErrUserExists
+		}
+	}
+	r.nextID++
+	user.ID = r.nextID
+	copied := *user
+	r.users[user.ID] = &copied
+	return nil
+}
+
+func (r *fakeUserRepo) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if strings.EqualFold(user.Username, username) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *fakeUserRepo) RecordFailedLogin(ctx context.Context, userID, maxAttempts int, lockedUntil time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user := r.users[userID]
+	user.FailedLogins++
+	if user.FailedLogins >= maxAttempts {
+		user.FailedLogins = 0
+		user.LockedUntil = &lockedUntil
+	}
+	return nil
+}
+
+func (r *fakeUserRepo) ResetFailedLogins(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[userID].FailedLogins = 0
+	r.users[userID].LockedUntil = nil
+	return nil
+}
+
+func (r *fakeUserRepo) SetRole(ctx context.Context, userID int, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	user.Role = role
+	return nil
+}
+
+func (r *fakeUserRepo) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[userID].TOTPSecret = secret
+	return nil
+}
+
+func (r *fakeUserRepo) EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[userID].TOTPEnabled = true
+	r.recovery[userID] = make(map[string]bool)
+	for _, hash := range recoveryCodeHashes {
+		r.recovery[userID][hash] = true
+	}
+	return nil
+}
+
+func (r *fakeUserRepo) DisableTOTP(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.users[userID]
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	delete(r.recovery, userID)
+	return nil
+}
+
+func (r *fakeUserRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := r.users[userID]
+	if step <= user.TOTPLastStep {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	return true, nil
+}
+
+func (r *fakeUserRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.recovery[userID][codeHash] {
+		return false, nil
+	}
+	delete(r.recovery[userID], codeHash)
+	return true, nil
+}
+
+func (r *fakeUserRepo) GetByID(ctx context.Context, userID int) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (r *fakeUserRepo) GetUsernames(ctx context.Context, ids []int) (map[int]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make(map[int]string)
+	for _, id := range ids {
+		if user, ok := r.users[id]; ok {
+			names[id] = user.Username
+		}
+	}
+	return names, nil
+}
+
+// fakeBlocks records blocks as blocker → blocked pairs.
+type fakeBlocks struct {
+	mu      sync.Mutex
+	blocked map[[2]int]bool
+	calls   int
+}
+
+func newFakeBlocks(pairs ...[2]int) *fakeBlocks {
+	b := &fakeBlocks{blocked: make(map[[2]int]bool)}
+	for _, pair := range pairs {
+		b.blocked[pair] = true
+	}
+	return b
+}
+
+func (b *fakeBlocks) BlockedAmong(ctx context.Context, userID int, candidates []int) (map[int]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.calls++
+	found := make(map[int]bool)
+	for _, candidate := range candidates {
+		if b.blocked[[2]int{userID, candidate}] || b.blocked[[2]int{candidate, userID}] {
+			found[candidate] = true
+		}
+	}
+	return found, nil
+}
+
+func (b *fakeBlocks) BlocksBetween(ctx context.Context, pairs [][2]int) (map[[2]int]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.calls++
+	found := make(map[[2]int]bool)
+	for _, pair := range pairs {
+		for _, directed := range [][2]int{pair, {pair[1], pair[0]}} {
+			if b.blocked[directed] {
+				found[directed] = true
+			}
+		}
+	}
+	return found, nil
+}
+
+// allowFilter accepts every message except those to receivers in deny.
+type allowFilter struct {
+	deny  map[int]bool
+	calls int
+}
+
+func (f *allowFilter) AllowMessages(ctx context.Context, pairs []MessagePair) (map[MessagePair]bool, error) {
+	f.calls++
+	allowed := make(map[MessagePair]bool, len(pairs))
+	for _, pair := range pairs {
+		allowed[pair] = !f.deny[pair.ReceiverID]
+	}
+	return allowed, nil
+}
+
+// fakeStatusRepo keeps statuses in memory. Users without a row get the same
+// defaults as the MySQL repository.
+type fakeStatusRepo struct {
+	repository.StatusRepository
+
+	mu       sync.Mutex
+	statuses map[int]*models.UserStatus
+}
+
+func newFakeStatusRepo() *fakeStatusRepo {
+	return &fakeStatusRepo{statuses: make(map[int]*models.UserStatus)}
+}
+
+func (r *fakeStatusRepo) GetByUserID(ctx context.Context, userID int) (*models.UserStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if status, ok := r.statuses[userID]; ok {
+		copied := *status
+		privacy := *status.Privacy
+		copied.Privacy = &privacy
+		return &copied, nil
+	}
+	return &models.UserStatus{
+		UserID:   userID,
+		Status:   models.StatusOffline,
+		LastSeen: time.Now(),
+		Privacy:  &models.PrivacySettings{OnlineStatus: models.VisibilityEveryone, LastSeen: models.VisibilityEveryone},
+	}, nil
+}
+
+func (r *fakeStatusRepo) GetByUserIDs(ctx context.Context, userIDs []int) ([]*models.UserStatus, error) {
+	statuses := make([]*models.UserStatus, 0, len(userIDs))
+	for _, userID := range userIDs {
+		status, _ := r.GetByUserID(ctx, userID)
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (r *fakeStatusRepo) Update(ctx context.Context, status *models.UserStatus) error {
+	current, _ := r.GetByUserID(ctx, status.UserID)
+	current.Status = status.Status
+	current.LastSeen = status.LastSeen
+	r.put(current)
+	return nil
+}
+
+func (r *fakeStatusRepo) UpdateCustom(ctx context.Context, status *models.UserStatus) error {
+	current, _ := r.GetByUserID(ctx, status.UserID)
+	current.ManualStatus = status.ManualStatus
+	current.StatusText = status.StatusText
+	current.StatusEmoji = status.StatusEmoji
+	current.StatusExpiresAt = status.StatusExpiresAt
+	r.put(current)
+	return nil
+}
+
+func (r *fakeStatusRepo) UpdatePrivacy(ctx context.Context, userID int, settings *models.PrivacySettings) error {
+	current, _ := r.GetByUserID(ctx, userID)
+	privacy := *settings
+	current.Privacy = &privacy
+	r.put(current)
+	return nil
+}
+
+func (r *fakeStatusRepo) put(status *models.UserStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses[status.UserID] = status
+}
+
+// fakeContacts holds mutual contact pairs.
+type fakeContacts map[[2]int]bool
+
+func (c fakeContacts) ContactsAmong(ctx context.Context, userID int, candidates []int) (map[int]bool, error) {
+	contacts := make(map[int]bool)
+	for _, candidate := range candidates {
+		if c[[2]int{userID, candidate}] || c[[2]int{candidate, userID}] {
+			contacts[candidate] = true
+		}
+	}
+	return contacts, nil
+}
+
+// fakeContactRepo holds mutual contact pairs and each user's messages_from
+// setting, counting the queries made.
+type fakeContactRepo struct {
+	repository.ContactRepository
+
+	contacts     map[[2]int]bool
+	messagesFrom map[int]string
+	queries      int
+}
+
+func newFakeContactRepo(pairs ...[2]int) *fakeContactRepo {
+	r := &fakeContactRepo{contacts: make(map[[2]int]bool), messagesFrom: make(map[int]string)}
+	for _, pair := range pairs {
+		r.contacts[pair] = true
+		r.contacts[[2]int{pair[1], pair[0]}] = true
+	}
+	return r
+}
+
+func (r *fakeContactRepo) FilterContacts(ctx context.Context, userID int, candidates []int) ([]int, error) {
+	r.queries++
+	var found []int
+	for _, candidate := range candidates {
+		if r.contacts[[2]int{userID, candidate}] {
+			found = append(found, candidate)
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeContactRepo) FilterContactPairs(ctx context.Context, pairs [][2]int) ([][2]int, error) {
+	r.queries++
+	var found [][2]int
+	for _, pair := range pairs {
+		if r.contacts[pair] {
+			found = append(found, pair)
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeContactRepo) FilterContactsOnly(ctx context.Context, userIDs []int) ([]int, error) {
+	r.queries++
+	var found []int
+	for _, id := range userIDs {
+		if r.messagesFrom[id] == models.VisibilityContacts {
+			found = append(found, id)
+		}
+	}
+	return found, nil
+}
+
+// fakePartners counts pairs of users who exchanged messages as contacts.
+type fakePartners struct {
+	repository.MessageRepository
+
+	partners map[[2]int]bool
+}
+
+func (r *fakePartners) FilterConversationPartners(ctx context.Context, userID int, candidates []int) ([]int, error) {
+	var found []int
+	for _, candidate := range candidates {
+		if r.partners[[2]int{userID, candidate}] || r.partners[[2]int{candidate, userID}] {
+			found = append(found, candidate)
+		}
+	}
+	return found, nil
+}
+
+// fakeProfiles holds profiles by user ID.
+type fakeProfiles struct {
+	repository.ProfileRepository
+
+	profiles map[int]*models.Profile
+}
+
+func newFakeProfiles(profiles ...*models.Profile) *fakeProfiles {
+	r := &fakeProfiles{profiles: make(map[int]*models.Profile)}
+	for _, profile := range profiles {
+		r.profiles[profile.UserID] = profile
+	}
+	return r
+}
+
+func (r *fakeProfiles) Get(ctx context.Context, userID int) (*models.Profile, error) {
+	profile, ok := r.profiles[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *profile
+	return &copied, nil
+}
+
+func (r *fakeProfiles) Search(ctx context.Context, query string, limit int) ([]*models.Profile, error) {
+	var found []*models.Profile
+	for _, profile := range r.profiles {
+		if strings.HasPrefix(strings.ToLower(profile.Username), strings.ToLower(query)) && len(found) < limit {
+			copied := *profile
+			found = append(found, &copied)
+		}
+	}
+	return found, nil
+}
+
+// fakeStatusEvents records presence transitions.
+type fakeStatusEvents struct {
+	repository.StatusEventRepository
+
+	mu     sync.Mutex
+	events []*models.StatusEvent
+}
+
+func (r *fakeStatusEvents) Create(ctx context.Context, event *models.StatusEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *fakeStatusEvents) GetRange(ctx context.Context, userID int, from, to time.Time) ([]*models.StatusEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []*models.StatusEvent
+	for _, event := range r.events {
+		if event.UserID == userID && !event.OccurredAt.Before(from) && event.OccurredAt.Before(to) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (r *fakeStatusEvents) GetLastBefore(ctx context.Context, userID int, before time.Time, statuses []string) (*models.StatusEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var last *models.StatusEvent
+	for _, event := range r.events {
+		if event.UserID != userID || !event.OccurredAt.Before(before) {
+			continue
+		}
+		for _, status := range statuses {
+			if event.Status == status {
+				last = event
+			}
+		}
+	}
+	return last, nil
+}
+
+// statuses returns the recorded statuses of the user, oldest first.
+func (r *fakeStatusEvents) statuses(userID int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var statuses []string
+	for _, event := range r.events {
+		if event.UserID == userID {
+			statuses = append(statuses, event.Status)
+		}
+	}
+	return statuses
+}
+
+// fakeTokenRepo keeps refresh tokens, the denylist, WebSocket tickets and
+// MFA challenges in memory.
+type fakeTokenRepo struct {
+	repository.TokenRepository
+
+	mu         sync.Mutex
+	refresh    map[string]*models.RefreshToken
+	revoked    map[string]time.Time
+	tickets    map[string]*models.WSTicket
+	challenges map[string]fakeChallenge
+}
+
+type fakeChallenge struct {
+	userID    int
+	expiresAt time.Time
+}
+
+func newFakeTokenRepo() *fakeTokenRepo {
+	return &fakeTokenRepo{
+		refresh:    make(map[string]*models.RefreshToken),
+		revoked:    make(map[string]time.Time),
+		tickets:    make(map[string]*models.WSTicket),
+		challenges: make(map[string]fakeChallenge),
+	}
+}
+
+func (r *fakeTokenRepo) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *token
+	r.refresh[token.TokenHash] = &copied
+	return nil
+}
+
+func (r *fakeTokenRepo) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.refresh[tokenHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (r *fakeTokenRepo) UseRefreshToken(ctx context.Context, tokenHash string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.refresh[tokenHash]
+	if !ok || token.UsedAt != nil || token.RevokedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &at
+	return true, nil
+}
+
+func (r *fakeTokenRepo) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.refresh {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &at
+		}
+	}
+	return nil
+}
+
+func (r *fakeTokenRepo) RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.revoked[jti]; !ok {
+		r.revoked[jti] = expiresAt
+	}
+	return nil
+}
+
+func (r *fakeTokenRepo) IsRevoked(ctx context.Context, ids ...string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := r.revoked[id]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeTokenRepo) CreateTicket(ctx context.Context, ticket *models.WSTicket) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *ticket
+	r.tickets[ticket.TicketHash] = &copied
+	return nil
+}
+
+func (r *fakeTokenRepo) ConsumeTicket(ctx context.Context, ticketHash string, now time.Time) (*models.WSTicket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ticket, ok := r.tickets[ticketHash]
+	if !ok || !ticket.ExpiresAt.After(now) {
+		return nil, sql.ErrNoRows
+	}
+	delete(r.tickets, ticketHash)
+	return ticket, nil
+}
+
+func (r *fakeTokenRepo) CreateMFAChallenge(ctx context.Context, challengeHash string, userID int, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.challenges[challengeHash] = fakeChallenge{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (r *fakeTokenRepo) GetMFAChallenge(ctx context.Context, challengeHash string, now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	challenge, ok := r.challenges[challengeHash]
+	if !ok || !challenge.expiresAt.After(now) {
+		return 0, sql.ErrNoRows
+	}
+	return challenge.userID, nil
+}
+
+func (r *fakeTokenRepo) DeleteMFAChallenge(ctx context.Context, challengeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.challenges[challengeHash]
+	delete(r.challenges, challengeHash)
+	return ok, nil
+}
+
+// fakeAuthSessions keeps login sessions in memory.
+type fakeAuthSessions struct {
+	repository.AuthSessionRepository
+
+	mu       sync.Mutex
+	sessions map[string]*models.AuthSession
+}
+
+func newFakeAuthSessions() *fakeAuthSessions {
+	return &fakeAuthSessions{sessions: make(map[string]*models.AuthSession)}
+}
+
+func (r *fakeAuthSessions) Create(ctx context.Context, session *models.AuthSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *session
+	r.sessions[session.ID] = &copied
+	return nil
+}
+
+func (r *fakeAuthSessions) Get(ctx context.Context, sessionID string) (*models.AuthSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[sessionID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (r *fakeAuthSessions) GetActive(ctx context.Context, userID int, now time.Time) ([]*models.AuthSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sessions []*models.AuthSession
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.ExpiresAt.After(now) {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *fakeAuthSessions) Touch(ctx context.Context, sessionID, ip string, at, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[sessionID]
+	if !ok || session.RevokedAt != nil {
+		return nil
+	}
+	session.LastActiveAt = at
+	session.IP = ip
+	if expiresAt.After(session.ExpiresAt) {
+		session.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (r *fakeAuthSessions) Revoke(ctx context.Context, sessionID string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[sessionID]
+	if !ok || session.RevokedAt != nil {
+		return false, nil
+	}
+	session.RevokedAt = &at
+	return true, nil
+}
+
+type fakeIdentities struct {
+	repository.IdentityRepository
+
+	mu     sync.Mutex
+	states map[string]*models.OIDCState
+	links  map[[2]string]int
+}
+
+func newFakeIdentities() *fakeIdentities {
+	return &fakeIdentities{
+		states: make(map[string]*models.OIDCState),
+		links:  make(map[[2]string]int),
+	}
+}
+
+func (r *fakeIdentities) CreateState(ctx context.Context, state *models.OIDCState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *state
+	r.states[state.StateHash] = &copied
+	return nil
+}
+
+func (r *fakeIdentities) ConsumeState(ctx context.Context, stateHash string, now time.Time) (*models.OIDCState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[stateHash]
+	if !ok || !state.ExpiresAt.After(now) {
+		return nil, sql.ErrNoRows
+	}
+	delete(r.states, stateHash)
+	return state, nil
+}
+
+func (r *fakeIdentities) GetUserID(ctx context.Context, issuer, subject string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	userID, ok := r.links[[2]string{issuer, subject}]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return userID, nil
+}
+
+func (r *fakeIdentities) Link(ctx context.Context, issuer, subject string, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := [2]string{issuer, subject}
+	if _, ok := r.links[key]; ok {
+		return repository.ErrUserExists
+	}
+	r.links[key] = userID
+	return nil
+}
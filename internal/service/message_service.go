@@ -3,36 +3,103 @@ package service
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/chatapp/internal/models"
 	"github.com/chatapp/internal/repository"
+	"github.com/chatapp/internal/richtext"
 )
 
 type MessageService interface {
+	// PrepareMessage sanitizes the content a client sent and turns its
+	// Markdown or entities into validated entities. Messages passed to
+	// SendMessages must have been prepared.
+	PrepareMessage(msg *models.Message) error
 	SendMessage(ctx context.Context, msg *models.Message) (*models.Message, error)
+	SendMessages(ctx context.Context, msgs []*models.Message) error
 	GetConversation(ctx context.Context, user1ID, user2ID, limit int) ([]*models.Message, error)
 	GetUserMessages(ctx context.Context, userID, limit int) ([]*models.Message, error)
 	GetUndeliveredMessages(ctx context.Context, userID int) ([]*models.Message, error)
+	GetMentions(ctx context.Context, userID int, before int64, limit int) ([]*models.Message, error)
+	GetConversationPartners(ctx context.Context, userID, limit int) ([]int, error)
 	MarkMessagesAsDelivered(ctx context.Context, receiverID int) error
+	MarkMessagesAsDeliveredByIDs(ctx context.Context, ids []int64) error
 	MarkMessagesAsRead(ctx context.Context, senderID, receiverID int) error
 }
 
+// ErrMessageNotAllowed is returned when the receiver does not accept messages
+// from the sender.
+var ErrMessageNotAllowed = errors.New("recipient does not accept messages from you")
+
+// MessagePair is the sender and receiver of a message.
+type MessagePair struct {
+	SenderID   int
+	ReceiverID int
+}
+
+// MessageFilter decides whether the senders may message the receivers. The
+// pairs of a whole batch are resolved in one call.
+type MessageFilter interface {
+	AllowMessages(ctx context.Context, pairs []MessagePair) (map[MessagePair]bool, error)
+}
+
+// messageVerdict is what happens to a message the sender submitted.
+type messageVerdict int
+
+const (
+	verdictAccept messageVerdict = iota
+	verdictReject
+	verdictDrop
+)
+
 type messageService struct {
-	repo repository.MessageRepository
+	repo   repository.MessageRepository
+	users  repository.UserRepository
+	filter MessageFilter
+	blocks BlockChecker
+}
+
+func NewMessageService(
+	repo repository.MessageRepository,
+	users repository.UserRepository,
+	filter MessageFilter,
+	blocks BlockChecker,
+) MessageService {
+	return &messageService{repo: repo, users: users, filter: filter, blocks: blocks}
 }
 
-func NewMessageService(repo repository.MessageRepository) MessageService {
-	return &messageService{repo: repo}
+func (s *messageService) PrepareMessage(msg *models.Message) error {
+	content, entities, err := richtext.Prepare(msg.Content, msg.Format, msg.Entities)
+	if err != nil {
+		return err
+	}
+	msg.Content, msg.Entities, msg.Format = content, entities, ""
+	return nil
 }
 
 func (s *messageService) SendMessage(ctx context.Context, msg *models.Message) (*models.Message, error) {
 	if msg.SenderID <= 0 || msg.ReceiverID <= 0 {
 		return nil, errors.New("invalid user ID")
 	}
+	if err := s.PrepareMessage(msg); err != nil {
+		return nil, err
+	}
+	verdicts, err := s.check(ctx, []*models.Message{msg})
+	if err != nil {
+		return nil, err
+	}
+	verdict := verdicts[pairOf(msg)]
+	if verdict == verdictReject {
+		return nil, ErrMessageNotAllowed
+	}
 
 	msg.Timestamp = time.Now()
 	msg.Status = "sent"
+	msg.Dropped = verdict == verdictDrop
+	if err := s.addMentions(ctx, []*models.Message{msg}); err != nil {
+		return nil, err
+	}
 
 	id, err := s.repo.Create(ctx, msg)
 	if err != nil {
@@ -43,6 +110,157 @@ func (s *messageService) SendMessage(ctx context.Context, msg *models.Message) (
 	return msg, nil
 }
 
+// SendMessages stores the messages the receivers accept. Rejected messages
+// are marked MessageRejected and not stored. Messages to receivers who
+// blocked the sender are marked Dropped and stored hidden from the receiver,
+// so the sender gets an ID like for any other message. Blocks and messaging
+// settings are resolved once for the whole batch. A batch that failed may be
+// passed again as is.
+func (s *messageService) SendMessages(ctx context.Context, msgs []*models.Message) error {
+	for _, msg := range msgs {
+		if msg.SenderID <= 0 || msg.ReceiverID <= 0 {
+			return errors.New("invalid user ID")
+		}
+	}
+	verdicts, err := s.check(ctx, msgs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	accepted := make([]*models.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		msg.Dropped = false
+		verdict := verdicts[pairOf(msg)]
+		if verdict == verdictReject {
+			msg.Status = models.MessageRejected
+			continue
+		}
+
+		if msg.Timestamp.IsZero() {
+			msg.Timestamp = now
+		}
+		msg.Status = "sent"
+		msg.Dropped = verdict == verdictDrop
+		accepted = append(accepted, msg)
+	}
+
+	if len(accepted) == 0 {
+		return nil
+	}
+	if err := s.addMentions(ctx, accepted); err != nil {
+		return err
+	}
+	return s.repo.CreateBatch(ctx, accepted)
+}
+
+// addMentions adds the mentions found in the messages' content to their
+// entities. In a one-to-one conversation the receiver is the only member
+// other than the sender, so mentions of anyone else, the sender included, are
+// ignored, as are mentions inside code or across other formatting.
+func (s *messageService) addMentions(ctx context.Context, msgs []*models.Message) error {
+	mentions := make([][]richtext.Mention, len(msgs))
+	var ids []int
+	for i, msg := range msgs {
+		msg.Entities = withoutMentions(msg.Entities)
+		mentions[i] = richtext.ParseMentions(msg.Content)
+		if len(mentions[i]) > 0 {
+			ids = append(ids, msg.ReceiverID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	usernames, err := s.users.GetUsernames(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i, msg := range msgs {
+		for _, mention := range mentions[i] {
+			if !strings.EqualFold(mention.Username, usernames[msg.ReceiverID]) {
+				continue
+			}
+			entity := models.MessageEntity{
+				Type:   models.EntityMention,
+				Offset: mention.Offset,
+				Length: mention.Length,
+				UserID: msg.ReceiverID,
+			}
+			if richtext.Fits(msg.Entities, entity) {
+				msg.Entities = append(msg.Entities, entity)
+			}
+		}
+		richtext.SortEntities(msg.Entities)
+	}
+	return nil
+}
+
+// withoutMentions drops the mentions added by an earlier attempt to store the
+// message.
+func withoutMentions(entities []models.MessageEntity) []models.MessageEntity {
+	kept := entities[:0]
+	for _, entity := range entities {
+		if entity.Type != models.EntityMention {
+			kept = append(kept, entity)
+		}
+	}
+	return kept
+}
+
+// check applies blocks and the receivers' messaging settings to each
+// distinct sender and receiver pair of the messages, with one lookup of each
+// for the whole batch. A message to someone who blocked the sender looks
+// sent, so the block stays hidden; a sender who blocked the receiver is
+// simply rejected.
+func (s *messageService) check(ctx context.Context, msgs []*models.Message) (map[MessagePair]messageVerdict, error) {
+	verdicts := make(map[MessagePair]messageVerdict, len(msgs))
+	pairs := make([][2]int, 0, len(msgs))
+	for _, msg := range msgs {
+		pair := pairOf(msg)
+		if _, ok := verdicts[pair]; !ok {
+			verdicts[pair] = verdictAccept
+			pairs = append(pairs, [2]int{pair.SenderID, pair.ReceiverID})
+		}
+	}
+
+	blocks, err := s.blocks.BlocksBetween(ctx, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []MessagePair
+	for pair := range verdicts {
+		switch {
+		case blocks[[2]int{pair.SenderID, pair.ReceiverID}]:
+			verdicts[pair] = verdictReject
+		case blocks[[2]int{pair.ReceiverID, pair.SenderID}]:
+			verdicts[pair] = verdictDrop
+		default:
+			filtered = append(filtered, pair)
+		}
+	}
+	if len(filtered) == 0 {
+		return verdicts, nil
+	}
+
+	allowed, err := s.filter.AllowMessages(ctx, filtered)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range filtered {
+		if !allowed[pair] {
+			verdicts[pair] = verdictReject
+		}
+	}
+	return verdicts, nil
+}
+
+func pairOf(msg *models.Message) MessagePair {
+	return MessagePair{SenderID: msg.SenderID, ReceiverID: msg.ReceiverID}
+}
+
 func (s *messageService) GetConversation(ctx context.Context, user1ID, user2ID, limit int) ([]*models.Message, error) {
 	if user1ID <= 0 || user2ID <= 0 {
 		return nil, errors.New("invalid user ID")
@@ -67,6 +285,29 @@ func (s *messageService) GetUndeliveredMessages(ctx context.Context, userID int)
 	return s.repo.GetUndeliveredMessages(ctx, userID)
 }
 
+func (s *messageService) GetMentions(ctx context.Context, userID int, before int64, limit int) ([]*models.Message, error) {
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+
+	messages, err := s.repo.GetMentions(ctx, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []*models.Message{}
+	}
+	return messages, nil
+}
+
+func (s *messageService) GetConversationPartners(ctx context.Context, userID, limit int) ([]int, error) {
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+
+	return s.repo.GetConversationPartners(ctx, userID, limit)
+}
+
 func (s *messageService) MarkMessagesAsDelivered(ctx context.Context, receiverID int) error {
 	if receiverID <= 0 {
 		return errors.New("invalid user ID")
@@ -75,6 +316,10 @@ func (s *messageService) MarkMessagesAsDelivered(ctx context.Context, receiverID
 	return s.repo.MarkAsDelivered(ctx, receiverID)
 }
 
+func (s *messageService) MarkMessagesAsDeliveredByIDs(ctx context.Context, ids []int64) error {
+	return s.repo.MarkAsDeliveredByIDs(ctx, ids)
+}
+
 func (s *messageService) MarkMessagesAsRead(ctx context.Context, senderID, receiverID int) error {
 	if senderID <= 0 || receiverID <= 0 {
 		return errors.New("invalid user ID")
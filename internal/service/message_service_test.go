@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/chatapp/internal/models"
+)
+
+func newTestMessageService(repo *fakeMessageRepo, blocks *fakeBlocks, filter *allowFilter) MessageService {
+	users := newFakeUserRepo(
+		&models.User{ID: 1, Username: "ana"},
+		&models.User{ID: 2, Username: "bruno"},
+		&models.User{ID: 3, Username: "carla"},
+	)
+	return NewMessageService(repo, users, filter, blocks)
+}
+
+func TestSendMessagesCanRetryFailedBatch(t *testing.T) {
+	repo := newFakeMessageRepo()
+	service := newTestMessageService(repo, newFakeBlocks(), &allowFilter{})
+	msgs := []*models.Message{
+		{SenderID: 1, ReceiverID: 2, Content: "oi @bruno"},
+		{SenderID: 2, ReceiverID: 1, Content: "oi"},
+	}
+
+	repo.failNext = errors.New("deadlock")
+	if err := service.SendMessages(context.Background(), msgs); err == nil {
+		t.Fatal("SendMessages succeeded, want the repository error")
+	}
+	if err := service.SendMessages(context.Background(), msgs); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+
+	stored := repo.stored()
+	if len(stored) != 2 {
+		t.Fatalf("stored %d messages, want 2", len(stored))
+	}
+	if got := len(stored[0].Entities); got != 1 {
+		t.Fatalf("retried message has %d entities, want the mention once", got)
+	}
+}
+
+func TestSendMessagesFiltersBatchOnce(t *testing.T) {
+	repo := newFakeMessageRepo()
+	filter := &allowFilter{deny: map[int]bool{3: true}}
+	service := newTestMessageService(repo, newFakeBlocks(), filter)
+	msgs := []*models.Message{
+		{SenderID: 1, ReceiverID: 2, Content: "oi"},
+		{SenderID: 1, ReceiverID: 3, Content: "oi"},
+		{SenderID: 2, ReceiverID: 1, Content: "oi"},
+		{SenderID: 1, ReceiverID: 2, Content: "tudo bem?"},
+	}
+
+	if err := service.SendMessages(context.Background(), msgs); err != nil {
+		t.Fatal(err)
+	}
+	if filter.calls != 1 {
+		t.Fatalf("filter called %d times, want once per batch", filter.calls)
+	}
+	if msgs[1].Status != models.MessageRejected {
+		t.Fatalf("message to a receiver that does not accept it has status %q, want rejected", msgs[1].Status)
+	}
+	if got := len(repo.stored()); got != 3 {
+		t.Fatalf("stored %d messages, want 3", got)
+	}
+}
+
+func TestSendMessagesStoresDroppedMessagesHidden(t *testing.T) {
+	repo := newFakeMessageRepo()
+	// bruno (2) blocked ana (1).
+	blocks := newFakeBlocks([2]int{2, 1})
+	service := newTestMessageService(repo, blocks, &allowFilter{})
+	msgs := []*models.Message{
+		{SenderID: 1, ReceiverID: 2, Content: "oi @bruno"},
+		{SenderID: 1, ReceiverID: 3, Content: "oi"},
+		{SenderID: 2, ReceiverID: 1, Content: "oi"},
+		{SenderID: 1, ReceiverID: 2, Content: "tudo bem?"},
+	}
+
+	if err := service.SendMessages(context.Background(), msgs); err != nil {
+		t.Fatal(err)
+	}
+	if blocks.calls != 1 {
+		t.Fatalf("blocks looked up %d times, want once per batch", blocks.calls)
+	}
+
+	for _, i := range []int{0, 3} {
+		if !msgs[i].Dropped || msgs[i].ID == 0 || msgs[i].Status != "sent" {
+			t.Fatalf("message to the blocker = %+v, want it dropped with an ID like any sent message", msgs[i])
+		}
+	}
+	if msgs[1].Dropped || msgs[1].ID == 0 {
+		t.Fatalf("message to another user = %+v", msgs[1])
+	}
+	if msgs[2].Status != models.MessageRejected || msgs[2].ID != 0 {
+		t.Fatalf("message from the blocker = %+v, want it rejected", msgs[2])
+	}
+
+	if got := len(repo.stored()); got != 3 {
+		t.Fatalf("stored %d messages, want 3", got)
+	}
+	if mentions, _ := repo.GetMentions(context.Background(), 2, 0, 10); len(mentions) != 0 {
+		t.Fatalf("blocker sees %d mentions from the hidden messages", len(mentions))
+	}
+}
+
+func TestSendMessagesIndexesOnlyReceiverMentions(t *testing.T) {
+	repo := newFakeMessageRepo()
+	service := newTestMessageService(repo, newFakeBlocks(), &allowFilter{})
+	msgs := []*models.Message{
+		{SenderID: 1, ReceiverID: 2, Content: "@ana avisa @bruno e @carla"},
+	}
+
+	if err := service.SendMessages(context.Background(), msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []models.MessageEntity{{Type: models.EntityMention, Offset: 11, Length: 6, UserID: 2}}
+	if got := msgs[0].Entities; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("entities = %+v, want only the receiver's mention %+v", got, want)
+	}
+	for _, userID := range []int{1, 3} {
+		if mentions, _ := service.GetMentions(context.Background(), userID, 0, 10); len(mentions) != 0 {
+			t.Fatalf("user %d has %d mentions, want none", userID, len(mentions))
+		}
+	}
+}
+
+func TestGetMentionsPagesNewestFirst(t *testing.T) {
+	repo := newFakeMessageRepo()
+	service := newTestMessageService(repo, newFakeBlocks(), &allowFilter{})
+	ctx := context.Background()
+	msgs := []*models.Message{
+		{SenderID: 1, ReceiverID: 2, Content: "@bruno um"},
+		{SenderID: 1, ReceiverID: 2, Content: "sem menção"},
+		{SenderID: 3, ReceiverID: 2, Content: "@bruno dois"},
+		{SenderID: 1, ReceiverID: 2, Content: "@bruno três"},
+	}
+	if err := service.SendMessages(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := service.GetMentions(ctx, 2, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].ID != msgs[3].ID || page[1].ID != msgs[2].ID {
+		t.Fatalf("first page = %+v, want the two newest mentions", page)
+	}
+	page, err = service.GetMentions(ctx, 2, page[1].ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].ID != msgs[0].ID {
+		t.Fatalf("second page = %+v, want the oldest mention", page)
+	}
+
+	empty, err := service.GetMentions(ctx, 1, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty == nil || len(empty) != 0 {
+		t.Fatalf("mentions of an unmentioned user = %#v, want an empty list", empty)
+	}
+	if _, err := service.GetMentions(ctx, 0, 0, 10); err == nil {
+		t.Fatal("GetMentions accepted an invalid user ID")
+	}
+}
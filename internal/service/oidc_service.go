@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/repository"
+	"github.com/chatapp/pkg/oidc"
+)
+
+var ErrInvalidOIDCState = errors.New("invalid or expired login state")
+
+// OIDCStateTTL bounds how long the user may spend at the provider.
+const OIDCStateTTL = 10 * time.Minute
+
+// oidcPasswordHash is stored for users provisioned from an identity provider.
+// It is not a valid bcrypt hash, so they cannot log in with a password.
+const oidcPasswordHash = "!"
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+type OIDCService interface {
+	// BeginLogin returns the provider URL to redirect the user agent to and
+	// a binding value the user agent must keep, in a cookie, until the
+	// callback.
+	BeginLogin(ctx context.Context) (redirectURL, binding string, err error)
+	// CompleteLogin handles the provider's callback and issues our tokens.
+	// The binding must be the one BeginLogin returned for the state, so a
+	// callback started in another browser is rejected (login CSRF).
+	CompleteLogin(ctx context.Context, state, binding, code string, device *models.DeviceInfo) (*models.AuthTokens, error)
+	PurgeExpiredStates(ctx context.Context) (int64, error)
+}
+
+type oidcService struct {
+	provider   *oidc.Provider
+	identities repository.IdentityRepository
+	users      repository.UserRepository
+	auth       AuthService
+}
+
+func NewOIDCService(provider *oidc.Provider, identities repository.IdentityRepository, users repository.UserRepository, auth AuthService) OIDCService {
+	return &oidcService{
+		provider:   provider,
+		identities: identities,
+		users:      users,
+		auth:       auth,
+	}
+}
+
+func (s *oidcService) BeginLogin(ctx context.Context) (string, string, error) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	binding, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.identities.CreateState(ctx, &models.OIDCState{
+		StateHash:    hashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		BindingHash:  hashToken(binding),
+		ExpiresAt:    time.Now().Add(OIDCStateTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return s.provider.AuthCodeURL(state, nonce, verifier), binding, nil
+}
+
+func (s *oidcService) CompleteLogin(ctx context.Context, state, binding, code string, device *models.DeviceInfo) (*models.AuthTokens, error) {
+	if state == "" || binding == "" || code == "" {
+		return nil, ErrInvalidOIDCState
+	}
+
+	// The state is consumed even on a mismatch, so it cannot be retried.
+	pending, err := s.identities.ConsumeState(ctx, hashToken(state), time.Now())
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidOIDCState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(binding)), []byte(pending.BindingHash)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+
+	claims, err := s.provider.Exchange(ctx, code, pending.CodeVerifier, pending.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userFor(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	return s.auth.IssueTokens(ctx, user, device)
+}
+
+// userFor returns the user linked to the external identity, provisioning one
+// on first login.
+func (s *oidcService) userFor(ctx context.Context, claims *oidc.Claims) (*models.User, error) {
+	userID, err := s.identities.GetUserID(ctx, claims.Issuer, claims.Subject)
+	if err == nil {
+		return s.users.GetByID(ctx, userID)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	user, err := s.provision(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.identities.Link(ctx, claims.Issuer, claims.Subject, user.ID)
+	if errors.Is(err, repository.ErrUserExists) {
+		// A concurrent first login linked the identity first.
+		userID, err := s.identities.GetUserID(ctx, claims.Issuer, claims.Subject)
+		if err != nil {
+			return nil, err
+		}
+		return s.users.GetByID(ctx, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// provision creates a user named after the identity's preferred username or
+// email, adding a numeric suffix if the name is taken.
+func (s *oidcService) provision(ctx context.Context, claims *oidc.Claims) (*models.User, error) {
+	base := usernameFromClaims(claims)
+	for attempt := 0; attempt < 10; attempt++ {
+		username := base
+		if attempt > 0 {
+			username = base + "-" + strconv.Itoa(attempt+1)
+		}
+
+		user := &models.User{
+			Username:     username,
+			PasswordHash: oidcPasswordHash,
+			CreatedAt:    time.Now(),
+			Role:         string(policy.RoleUser),
+		}
+		err := s.users.Create(ctx, user)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, repository.ErrUserExists) {
+			return nil, err
+		}
+	}
+	return nil, ErrUsernameTaken
+}
+
+func usernameFromClaims(claims *oidc.Claims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	candidate = usernameInvalidChars.ReplaceAllString(candidate, "")
+	if len(candidate) > 28 {
+		candidate = candidate[:28]
+	}
+	if len(candidate) < 3 {
+		candidate = "user"
+	}
+	return candidate
+}
+
+func (s *oidcService) PurgeExpiredStates(ctx context.Context) (int64, error) {
+	return s.identities.DeleteExpiredStates(ctx, time.Now())
+}
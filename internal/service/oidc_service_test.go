@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chatapp/pkg/jwt"
+	"github.com/chatapp/pkg/oidc"
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a minimal OpenID provider: it publishes its discovery
+// document and keys, and redeems the codes handed out by authorize.
+type mockProvider struct {
+	server *httptest.Server
+	key    *jwt.Key
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	subject   string
+	nonce     string
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwt.NewKey("idp-key", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, codes: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, _ := p.key.ToJWK()
+		json.NewEncoder(w).Encode(jwt.JWKSet{Keys: []jwt.JWK{jwk}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize plays the user logging in at the provider as subject and
+// returns the state and code the provider sends back to the callback.
+func (p *mockProvider) authorize(t *testing.T, authURL, subject string) (state, code string) {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	code, err = oidc.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = mockGrant{subject: subject, nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+	return query.Get("state"), code
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	grant, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	token := gojwt.NewWithClaims(p.key.Method, gojwt.MapClaims{
+		"iss":                p.server.URL,
+		"aud":                "chatapp",
+		"sub":                grant.subject,
+		"nonce":              grant.nonce,
+		"preferred_username": grant.subject,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = p.key.ID
+	idToken, _ := token.SignedString(p.key.Private)
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+}
+
+func newTestOIDC(t *testing.T) (OIDCService, *mockProvider, *testAuth) {
+	t.Helper()
+	mock := newMockProvider(t)
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:      mock.server.URL,
+		ClientID:    "chatapp",
+		RedirectURL: "https://chat.example/api/auth/oidc/callback",
+		Scopes:      []string{"openid"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := newTestAuth(t, AuthConfig{})
+	return NewOIDCService(provider, auth.identities, auth.users, auth), mock, auth
+}
+
+func TestOIDCLoginProvisionsAndLinksUser(t *testing.T) {
+	oidcService, mock, auth := newTestOIDC(t)
+	ctx := context.Background()
+
+	authURL, binding, err := oidcService.BeginLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := mock.authorize(t, authURL, "alice")
+	tokens, err := oidcService.CompleteLogin(ctx, state, binding, code, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.User.Username != "alice" {
+		t.Fatalf("provisioned user = %+v", tokens.User)
+	}
+	if userID, err := auth.identities.GetUserID(ctx, mock.server.URL, "alice"); err != nil || userID != tokens.User.ID {
+		t.Fatalf("linked user = %d, %v; want %d", userID, err, tokens.User.ID)
+	}
+
+	// The state is single use.
+	if _, err := oidcService.CompleteLogin(ctx, state, binding, code, nil); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("replayed callback = %v, want ErrInvalidOIDCState", err)
+	}
+
+	// The next login finds the linked user.
+	authURL, binding, err = oidcService.BeginLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code = mock.authorize(t, authURL, "alice")
+	again, err := oidcService.CompleteLogin(ctx, state, binding, code, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.User.ID != tokens.User.ID {
+		t.Fatalf("second login as user %d, want %d", again.User.ID, tokens.User.ID)
+	}
+}
+
+func TestOIDCCallbackRequiresTheStartingBrowser(t *testing.T) {
+	oidcService, mock, _ := newTestOIDC(t)
+	ctx := context.Background()
+
+	// The attacker starts a login and has the victim's browser complete it.
+	authURL, attackerBinding, err := oidcService.BeginLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, victimBinding, err := oidcService.BeginLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := mock.authorize(t, authURL, "mallory")
+
+	for name, binding := range map[string]string{"no cookie": "", "another login's cookie": victimBinding} {
+		if _, err := oidcService.CompleteLogin(ctx, state, binding, code, nil); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("%s: CompleteLogin = %v, want ErrInvalidOIDCState", name, err)
+		}
+	}
+
+	// A rejected callback burns the state, even for the right browser.
+	if _, err := oidcService.CompleteLogin(ctx, state, attackerBinding, code, nil); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("CompleteLogin after a mismatch = %v, want ErrInvalidOIDCState", err)
+	}
+}
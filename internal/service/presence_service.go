@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/repository"
+)
+
+// PresenceService tracks which users are online across every backend node.
+// A user is online while at least one of their sessions keeps heartbeating
+// within the TTL; sessions belong to the node that accepted the connection.
+type PresenceService interface {
+	Connect(ctx context.Context, userID int) (string, error)
+	Disconnect(ctx context.Context, sessionID string, userID int) (bool, error)
+	// Heartbeat keeps the sessions alive and records that the inputIDs,
+	// a subset of them, received input from the user.
+	Heartbeat(ctx context.Context, sessionIDs, inputIDs []string) error
+	// LastInput returns the latest input on any of the user's live sessions,
+	// on every node.
+	LastInput(ctx context.Context, userID int) (time.Time, error)
+	ReapExpired(ctx context.Context) ([]int, error)
+	ReleaseNode(ctx context.Context) ([]int, error)
+}
+
+type presenceService struct {
+	sessions repository.SessionRepository
+	statuses StatusService
+	nodeID   string
+	ttl      time.Duration
+}
+
+func NewPresenceService(
+	sessions repository.SessionRepository,
+	statuses StatusService,
+	nodeID string,
+	ttl time.Duration,
+) PresenceService {
+	return &presenceService{sessions: sessions, statuses: statuses, nodeID: nodeID, ttl: ttl}
+}
+
+// Connect opens a session for the user on this node and marks them online.
+func (s *presenceService) Connect(ctx context.Context, userID int) (string, error) {
+	if userID <= 0 {
+		return "", errors.New("invalid user ID")
+	}
+
+	sessionID, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:            sessionID,
+		UserID:        userID,
+		NodeID:        s.nodeID,
+		ConnectedAt:   now,
+		LastHeartbeat: now,
+		LastInput:     now,
+	}
+	if err := s.sessions.Create(ctx, session); err != nil {
+		return "", err
+	}
+
+	if err := s.statuses.UpdateUserStatus(ctx, userID, models.StatusOnline); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// Disconnect closes the session and reports whether it was the user's last
+// live session, in which case the user is now offline.
+func (s *presenceService) Disconnect(ctx context.Context, sessionID string, userID int) (bool, error) {
+	if err := s.sessions.Delete(ctx, sessionID); err != nil {
+		return false, err
+	}
+	return s.markOfflineIfIdle(ctx, userID)
+}
+
+// Heartbeat refreshes the given sessions and this node's own liveness.
+func (s *presenceService) Heartbeat(ctx context.Context, sessionIDs, inputIDs []string) error {
+	now := time.Now()
+	if err := s.sessions.HeartbeatNode(ctx, s.nodeID, now); err != nil {
+		return err
+	}
+	if err := s.sessions.Touch(ctx, sessionIDs, now); err != nil {
+		return err
+	}
+	return s.sessions.TouchInput(ctx, inputIDs, now)
+}
+
+func (s *presenceService) LastInput(ctx context.Context, userID int) (time.Time, error) {
+	return s.sessions.LastInputByUser(ctx, userID, time.Now().Add(-s.ttl))
+}
+
+// ReapExpired removes sessions whose heartbeat lapsed, including those of
+// nodes that stopped heartbeating, and returns the users that went offline.
+func (s *presenceService) ReapExpired(ctx context.Context) ([]int, error) {
+	userIDs, err := s.sessions.DeleteExpired(ctx, time.Now().Add(-s.ttl))
+	if err != nil {
+		return nil, err
+	}
+	return s.markOffline(ctx, userIDs)
+}
+
+// ReleaseNode drops every session owned by this node, for a clean shutdown,
+// and returns the users that went offline.
+func (s *presenceService) ReleaseNode(ctx context.Context) ([]int, error) {
+	userIDs, err := s.sessions.DeleteByNode(ctx, s.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.markOffline(ctx, userIDs)
+}
+
+func (s *presenceService) markOffline(ctx context.Context, userIDs []int) ([]int, error) {
+	var offline []int
+	for _, userID := range userIDs {
+		wentOffline, err := s.markOfflineIfIdle(ctx, userID)
+		if err != nil {
+			return offline, err
+		}
+		if wentOffline {
+			offline = append(offline, userID)
+		}
+	}
+	return offline, nil
+}
+
+func (s *presenceService) markOfflineIfIdle(ctx context.Context, userID int) (bool, error) {
+	active, err := s.sessions.CountActiveByUser(ctx, userID, time.Now().Add(-s.ttl))
+	if err != nil {
+		return false, err
+	}
+	if active > 0 {
+		return false, nil
+	}
+
+	if err := s.statuses.UpdateUserStatus(ctx, userID, models.StatusOffline); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
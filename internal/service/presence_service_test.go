@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/repository"
+)
+
+type fakeSessionRepo struct {
+	repository.SessionRepository
+
+	mu       sync.Mutex
+	sessions map[string]*models.Session
+	nodes    map[string]time.Time
+}
+
+func newFakeSessionRepo() *fakeSessionRepo {
+	return &fakeSessionRepo{sessions: make(map[string]*models.Session), nodes: make(map[string]time.Time)}
+}
+
+func (r *fakeSessionRepo) Create(ctx context.Context, session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *session
+	r.sessions[session.ID] = &copied
+	return nil
+}
+
+func (r *fakeSessionRepo) Delete(ctx context.Context, sessionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, sessionID)
+	return nil
+}
+
+func (r *fakeSessionRepo) Touch(ctx context.Context, sessionIDs []string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range sessionIDs {
+		if session, ok := r.sessions[id]; ok {
+			session.LastHeartbeat = at
+		}
+	}
+	return nil
+}
+
+func (r *fakeSessionRepo) TouchInput(ctx context.Context, sessionIDs []string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range sessionIDs {
+		if session, ok := r.sessions[id]; ok {
+			session.LastInput = at
+		}
+	}
+	return nil
+}
+
+func (r *fakeSessionRepo) LastInputByUser(ctx context.Context, userID int, since time.Time) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var last time.Time
+	for _, session := range r.sessions {
+		if session.UserID == userID && !session.LastHeartbeat.Before(since) && session.LastInput.After(last) {
+			last = session.LastInput
+		}
+	}
+	return last, nil
+}
+
+func (r *fakeSessionRepo) CountActiveByUser(ctx context.Context, userID int, since time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, session := range r.sessions {
+		if session.UserID == userID && !session.LastHeartbeat.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeSessionRepo) HeartbeatNode(ctx context.Context, nodeID string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[nodeID] = at
+	return nil
+}
+
+func (r *fakeSessionRepo) DeleteByNode(ctx context.Context, nodeID string) ([]int, error) {
+	return r.deleteWhere(func(session *models.Session) bool { return session.NodeID == nodeID }), nil
+}
+
+func (r *fakeSessionRepo) DeleteExpired(ctx context.Context, before time.Time) ([]int, error) {
+	return r.deleteWhere(func(session *models.Session) bool {
+		node, ok := r.nodes[session.NodeID]
+		return session.LastHeartbeat.Before(before) || (ok && node.Before(before))
+	}), nil
+}
+
+func (r *fakeSessionRepo) deleteWhere(match func(*models.Session) bool) []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[int]bool)
+	var userIDs []int
+	for id, session := range r.sessions {
+		if !match(session) {
+			continue
+		}
+		delete(r.sessions, id)
+		if !seen[session.UserID] {
+			seen[session.UserID] = true
+			userIDs = append(userIDs, session.UserID)
+		}
+	}
+	return userIDs
+}
+
+// age moves the session's heartbeat back by d.
+func (r *fakeSessionRepo) age(sessionID string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[sessionID].LastHeartbeat = r.sessions[sessionID].LastHeartbeat.Add(-d)
+}
+
+// idle moves the session's last input back by d.
+func (r *fakeSessionRepo) idle(sessionID string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[sessionID].LastInput = r.sessions[sessionID].LastInput.Add(-d)
+}
+
+// fakeStatusUpdates records the connection-driven status of each user.
+type fakeStatusUpdates struct {
+	StatusService
+
+	mu       sync.Mutex
+	statuses map[int]string
+}
+
+func newFakeStatusUpdates() *fakeStatusUpdates {
+	return &fakeStatusUpdates{statuses: make(map[int]string)}
+}
+
+func (s *fakeStatusUpdates) UpdateUserStatus(ctx context.Context, userID int, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[userID] = status
+	return nil
+}
+
+func (s *fakeStatusUpdates) status(userID int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statuses[userID]
+}
+
+func TestPresenceOfflineOnlyAfterLastSession(t *testing.T) {
+	ctx := context.Background()
+	statuses := newFakeStatusUpdates()
+	presence := NewPresenceService(newFakeSessionRepo(), statuses, "node-a", time.Minute)
+
+	phone, err := presence.Connect(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := presence.Connect(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if phone == laptop {
+		t.Fatal("sessions share an ID")
+	}
+	if got := statuses.status(1); got != models.StatusOnline {
+		t.Fatalf("status after connect = %q, want online", got)
+	}
+
+	offline, err := presence.Disconnect(ctx, phone, 1)
+	if err != nil || offline {
+		t.Fatalf("Disconnect(phone) = %v, %v; want still online", offline, err)
+	}
+	offline, err = presence.Disconnect(ctx, laptop, 1)
+	if err != nil || !offline {
+		t.Fatalf("Disconnect(laptop) = %v, %v; want offline", offline, err)
+	}
+	if got := statuses.status(1); got != models.StatusOffline {
+		t.Fatalf("status after last disconnect = %q, want offline", got)
+	}
+}
+
+func TestPresenceReapsSessionsWithoutHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	sessions := newFakeSessionRepo()
+	statuses := newFakeStatusUpdates()
+	presence := NewPresenceService(sessions, statuses, "node-a", time.Minute)
+
+	stale, _ := presence.Connect(ctx, 1)
+	live, _ := presence.Connect(ctx, 2)
+	otherStale, _ := presence.Connect(ctx, 3)
+	presence.Connect(ctx, 3)
+	sessions.age(stale, 2*time.Minute)
+	sessions.age(otherStale, 2*time.Minute)
+	if err := presence.Heartbeat(ctx, []string{live}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	offline, err := presence.ReapExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offline) != 1 || offline[0] != 1 {
+		t.Fatalf("ReapExpired = %v, want only user 1 offline", offline)
+	}
+	if statuses.status(3) != models.StatusOnline {
+		t.Fatal("user with a live session was marked offline")
+	}
+}
+
+func TestPresenceReleaseNodeKeepsOtherNodes(t *testing.T) {
+	ctx := context.Background()
+	sessions := newFakeSessionRepo()
+	statuses := newFakeStatusUpdates()
+	nodeA := NewPresenceService(sessions, statuses, "node-a", time.Minute)
+	nodeB := NewPresenceService(sessions, statuses, "node-b", time.Minute)
+
+	nodeA.Connect(ctx, 1)
+	nodeA.Connect(ctx, 2)
+	nodeB.Connect(ctx, 2)
+
+	offline, err := nodeA.ReleaseNode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offline) != 1 || offline[0] != 1 {
+		t.Fatalf("ReleaseNode = %v, want only user 1 offline", offline)
+	}
+	if statuses.status(2) != models.StatusOnline {
+		t.Fatal("user connected to another node was marked offline")
+	}
+}
+
+func TestPresenceReapsSessionsOfDeadNodes(t *testing.T) {
+	ctx := context.Background()
+	sessions := newFakeSessionRepo()
+	statuses := newFakeStatusUpdates()
+	dead := NewPresenceService(sessions, statuses, "node-dead", time.Minute)
+	alive := NewPresenceService(sessions, statuses, "node-alive", time.Minute)
+
+	dead.Connect(ctx, 1)
+	dead.Heartbeat(ctx, nil, nil)
+	sessions.mu.Lock()
+	sessions.nodes["node-dead"] = time.Now().Add(-2 * time.Minute)
+	sessions.mu.Unlock()
+
+	offline, err := alive.ReapExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offline) != 1 || offline[0] != 1 {
+		t.Fatalf("ReapExpired = %v, want the dead node's user offline", offline)
+	}
+}
+
+func TestPresenceLastInputUsesMostRecentSession(t *testing.T) {
+	ctx := context.Background()
+	sessions := newFakeSessionRepo()
+	presence := NewPresenceService(sessions, newFakeStatusUpdates(), "node-a", time.Minute)
+	other := NewPresenceService(sessions, newFakeStatusUpdates(), "node-b", time.Minute)
+
+	phone, _ := presence.Connect(ctx, 1)
+	laptop, _ := other.Connect(ctx, 1)
+	sessions.idle(phone, time.Hour)
+	sessions.idle(laptop, time.Hour)
+
+	last, err := presence.LastInput(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(last) < 59*time.Minute {
+		t.Fatalf("LastInput = %v ago, want about an hour", time.Since(last))
+	}
+
+	// Input on the other node's session counts for the whole user.
+	if err := other.Heartbeat(ctx, []string{laptop}, []string{laptop}); err != nil {
+		t.Fatal(err)
+	}
+	last, err = presence.LastInput(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(last) > time.Minute {
+		t.Fatalf("LastInput = %v ago after input on another session", time.Since(last))
+	}
+
+	// A session whose heartbeat lapsed no longer counts.
+	sessions.age(laptop, 2*time.Minute)
+	last, _ = presence.LastInput(ctx, 1)
+	if time.Since(last) < 59*time.Minute {
+		t.Fatal("LastInput used a session without heartbeat")
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/repository"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 280
+	maxAvatarURLLength   = 512
+	minSearchLength      = 2
+	maxSearchResults     = 50
+)
+
+// ErrSearchQueryTooShort is returned for directory searches shorter than
+// minSearchLength characters.
+var ErrSearchQueryTooShort = errors.New("query must have at least 2 characters")
+
+type ProfileService interface {
+	// GetProfile returns the user's profile as the viewer may see it. A user
+	// with a block between them and the viewer is reported as unknown, as
+	// Search leaves them out.
+	GetProfile(ctx context.Context, viewerID, userID int) (*models.Profile, error)
+	// UpdateProfile replaces the editable fields of the user's profile and
+	// returns the stored profile.
+	UpdateProfile(ctx context.Context, userID int, update *models.Profile) (*models.Profile, error)
+	// Search looks users up by username or display name, leaving out users
+	// blocked by or blocking the viewer.
+	Search(ctx context.Context, viewerID int, query string, limit int) ([]*models.Profile, error)
+}
+
+type profileService struct {
+	repo   repository.ProfileRepository
+	blocks BlockChecker
+}
+
+func NewProfileService(repo repository.ProfileRepository, blocks BlockChecker) ProfileService {
+	return &profileService{repo: repo, blocks: blocks}
+}
+
+func (s *profileService) GetProfile(ctx context.Context, viewerID, userID int) (*models.Profile, error) {
+	if viewerID != userID {
+		blocked, err := s.blocks.BlockedAmong(ctx, viewerID, []int{userID})
+		if err != nil {
+			return nil, err
+		}
+		if blocked[userID] {
+			return nil, ErrUnknownUser
+		}
+	}
+	return s.load(ctx, userID)
+}
+
+func (s *profileService) load(ctx context.Context, userID int) (*models.Profile, error) {
+	profile, err := s.repo.Get(ctx, userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownUser
+	}
+	return profile, err
+}
+
+func (s *profileService) UpdateProfile(ctx context.Context, userID int, update *models.Profile) (*models.Profile, error) {
+	profile, err := s.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.DisplayName = strings.TrimSpace(update.DisplayName)
+	profile.Bio = strings.TrimSpace(update.Bio)
+	profile.AvatarURL = strings.TrimSpace(update.AvatarURL)
+	profile.Timezone = strings.TrimSpace(update.Timezone)
+	if err := validateProfile(profile); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	profile.UpdatedAt = &now
+	if err := s.repo.Save(ctx, profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func validateProfile(profile *models.Profile) error {
+	if utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameLength {
+		return errors.New("display_name is too long")
+	}
+	if utf8.RuneCountInString(profile.Bio) > maxBioLength {
+		return errors.New("bio is too long")
+	}
+	if profile.AvatarURL != "" {
+		u, err := url.Parse(profile.AvatarURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || len(profile.AvatarURL) > maxAvatarURLLength {
+			return errors.New("avatar_url must be an https URL")
+		}
+	}
+	if profile.Timezone != "" {
+		if _, err := time.LoadLocation(profile.Timezone); err != nil || profile.Timezone == "Local" {
+			return errors.New("timezone must be an IANA time zone")
+		}
+	}
+	return nil
+}
+
+func (s *profileService) Search(ctx context.Context, viewerID int, query string, limit int) ([]*models.Profile, error) {
+	query = strings.TrimSpace(query)
+	if utf8.RuneCountInString(query) < minSearchLength {
+		return nil, ErrSearchQueryTooShort
+	}
+	if limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+
+	profiles, err := s.repo.Search(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(profiles))
+	for _, profile := range profiles {
+		ids = append(ids, profile.UserID)
+	}
+	blocked, err := s.blocks.BlockedAmong(ctx, viewerID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*models.Profile, 0, len(profiles))
+	for _, profile := range profiles {
+		if !blocked[profile.UserID] {
+			results = append(results, profile)
+		}
+	}
+	return results, nil
+}
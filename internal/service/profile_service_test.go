@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/chatapp/internal/models"
+)
+
+func newTestProfileService(blocks *fakeBlocks) ProfileService {
+	return NewProfileService(newFakeProfiles(
+		&models.Profile{UserID: 1, Username: "ana"},
+		&models.Profile{UserID: 2, Username: "anabela"},
+		&models.Profile{UserID: 3, Username: "andre"},
+	), blocks)
+}
+
+func TestGetProfileHidesUsersBehindABlock(t *testing.T) {
+	ctx := context.Background()
+	// ana (1) blocked anabela (2).
+	profiles := newTestProfileService(newFakeBlocks([2]int{1, 2}))
+
+	for _, c := range [][2]int{{1, 2}, {2, 1}} {
+		if _, err := profiles.GetProfile(ctx, c[0], c[1]); !errors.Is(err, ErrUnknownUser) {
+			t.Errorf("GetProfile(viewer %d, user %d) = %v, want ErrUnknownUser", c[0], c[1], err)
+		}
+	}
+
+	profile, err := profiles.GetProfile(ctx, 1, 3)
+	if err != nil || profile.Username != "andre" {
+		t.Fatalf("GetProfile(viewer 1, user 3) = %+v, %v", profile, err)
+	}
+	if _, err := profiles.GetProfile(ctx, 1, 1); err != nil {
+		t.Fatalf("own profile: %v", err)
+	}
+	if _, err := profiles.GetProfile(ctx, 1, 9); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("missing profile: %v, want ErrUnknownUser", err)
+	}
+}
+
+func TestSearchLeavesOutUsersBehindABlock(t *testing.T) {
+	profiles := newTestProfileService(newFakeBlocks([2]int{2, 1}))
+
+	results, err := profiles.Search(context.Background(), 1, "an", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, profile := range results {
+		if profile.UserID == 2 {
+			t.Fatal("search by ana found anabela, who blocked ana")
+		}
+	}
+	if len(results) != 2 {
+		t.Fatalf("found %d profiles, want ana and andre", len(results))
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/repository"
+)
+
+var (
+	ErrInvalidRole  = errors.New("unknown role")
+	ErrOwnRole      = errors.New("cannot change your own role")
+	ErrServiceRoled = errors.New("service accounts can only have the user role")
+)
+
+type RoleService interface {
+	// SetRole changes another user's role. The caller in ctx must be
+	// allowed to manage roles.
+	SetRole(ctx context.Context, userID int, role policy.Role) (*models.User, error)
+	// BootstrapAdmins makes the users admins, so a fresh deployment has
+	// someone who can assign roles.
+	BootstrapAdmins(ctx context.Context, userIDs []int) error
+}
+
+type roleService struct {
+	users repository.UserRepository
+}
+
+func NewRoleService(users repository.UserRepository) RoleService {
+	return &roleService{users: users}
+}
+
+func (s *roleService) SetRole(ctx context.Context, userID int, role policy.Role) (*models.User, error) {
+	if err := policy.Authorize(ctx, policy.PermRolesManage); err != nil {
+		return nil, err
+	}
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+	// Admins cannot demote themselves, so there is always one left.
+	if subject, _ := policy.FromContext(ctx); subject.UserID == userID {
+		return nil, ErrOwnRole
+	}
+
+	user, err := s.users.GetByID(ctx, userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.IsServiceAccount && role != policy.RoleUser {
+		return nil, ErrServiceRoled
+	}
+
+	if err := s.users.SetRole(ctx, userID, string(role)); err != nil {
+		return nil, err
+	}
+	user.Role = string(role)
+	return user, nil
+}
+
+func (s *roleService) BootstrapAdmins(ctx context.Context, userIDs []int) error {
+	for _, userID := range userIDs {
+		user, err := s.users.GetByID(ctx, userID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if user.IsServiceAccount || user.Role == string(policy.RoleAdmin) {
+			continue
+		}
+		if err := s.users.SetRole(ctx, userID, string(policy.RoleAdmin)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,51 +4,310 @@ import (
 	"context"
 	"errors"
 	"time"
+	"unicode/utf8"
 
 	"github.com/chatapp/internal/models"
 	"github.com/chatapp/internal/repository"
 )
 
+const (
+	maxStatusTextLength  = 140
+	maxStatusEmojiLength = 16
+)
+
 type StatusService interface {
 	UpdateUserStatus(ctx context.Context, userID int, status string) error
+	SetCustomStatus(ctx context.Context, userID int, update *models.StatusUpdate) (*models.UserStatus, error)
 	GetUserStatus(ctx context.Context, userID int) (*models.UserStatus, error)
-	GetAllStatuses(ctx context.Context) ([]*models.UserStatus, error)
-	UpdateAllUsersOffline(ctx context.Context) error
+	GetStatuses(ctx context.Context, userIDs []int) ([]*models.UserStatus, error)
+	GetStatusesFor(ctx context.Context, viewerID int, userIDs []int) ([]*models.UserStatus, error)
+	ContactsAmong(ctx context.Context, viewerID int, userIDs []int) (map[int]bool, error)
+	// BlockedAmong returns the users with a block between them and the
+	// viewer, in either direction. Their presence is hidden from the viewer.
+	BlockedAmong(ctx context.Context, viewerID int, userIDs []int) (map[int]bool, error)
+	UpdatePrivacySettings(ctx context.Context, userID int, update *models.PrivacyUpdate) (*models.PrivacySettings, error)
+	ExpireCustomStatuses(ctx context.Context) ([]*models.UserStatus, error)
+	InvalidateStatus(userID int)
 }
 
 type statusService struct {
-	repo repository.StatusRepository
+	repo     repository.StatusRepository
+	events   repository.StatusEventRepository
+	contacts ContactChecker
+	blocks   BlockChecker
 }
 
-func NewStatusService(repo repository.StatusRepository) StatusService {
-	return &statusService{repo: repo}
+func NewStatusService(
+	repo repository.StatusRepository,
+	events repository.StatusEventRepository,
+	contacts ContactChecker,
+	blocks BlockChecker,
+) StatusService {
+	return &statusService{repo: repo, events: events, contacts: contacts, blocks: blocks}
 }
 
+// UpdateUserStatus records the connection-driven status: online, away or
+// offline. Transitions are also appended to the presence history.
 func (s *statusService) UpdateUserStatus(ctx context.Context, userID int, status string) error {
 	if userID <= 0 {
 		return errors.New("invalid user ID")
 	}
+	switch status {
+	case models.StatusOnline, models.StatusAway, models.StatusOffline:
+	default:
+		return errors.New("invalid status")
+	}
+
+	previous, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
 
+	// An invisible user appears offline, so their last seen time stays where
+	// it was when they went invisible; a fresh one on every connect would
+	// give them away.
+	now := time.Now()
+	lastSeen := now
+	if invisibleAt(previous, now) {
+		lastSeen = previous.LastSeen
+	}
 	userStatus := &models.UserStatus{
 		UserID:   userID,
 		Status:   status,
-		LastSeen: time.Now(),
+		LastSeen: lastSeen,
+	}
+	if err := s.repo.Update(ctx, userStatus); err != nil {
+		return err
+	}
+
+	if previous.Status == status {
+		return nil
+	}
+	return s.events.Create(ctx, &models.StatusEvent{UserID: userID, Status: status, OccurredAt: now})
+}
+
+// SetCustomStatus stores the state the user chose for themselves. An empty or
+// "online" status clears the manual override.
+func (s *statusService) SetCustomStatus(ctx context.Context, userID int, update *models.StatusUpdate) (*models.UserStatus, error) {
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+
+	manual := update.Status
+	switch manual {
+	case "", models.StatusOnline:
+		manual = ""
+	case models.StatusAway, models.StatusDND, models.StatusInvisible:
+	default:
+		return nil, errors.New("invalid status")
+	}
+	if utf8.RuneCountInString(update.StatusText) > maxStatusTextLength {
+		return nil, errors.New("status text too long")
+	}
+	if utf8.RuneCountInString(update.StatusEmoji) > maxStatusEmojiLength {
+		return nil, errors.New("status emoji too long")
+	}
+	if update.ExpiresAt != nil && !update.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
 	}
-	return s.repo.Update(ctx, userStatus)
+
+	previous, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	wasInvisible := resolveStatus(previous).ManualStatus == models.StatusInvisible
+
+	custom := &models.UserStatus{
+		UserID:          userID,
+		ManualStatus:    manual,
+		StatusText:      update.StatusText,
+		StatusEmoji:     update.StatusEmoji,
+		StatusExpiresAt: update.ExpiresAt,
+	}
+	if err := s.repo.UpdateCustom(ctx, custom); err != nil {
+		return nil, err
+	}
+
+	switch invisible := manual == models.StatusInvisible; {
+	case invisible && !wasInvisible:
+		err = s.recordVisibility(ctx, userID, models.StatusInvisible)
+	case !invisible && wasInvisible:
+		err = s.recordVisibility(ctx, userID, models.StatusEventVisible)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUserStatus(ctx, userID)
 }
 
+// GetUserStatus returns the effective status: a manual state overrides the
+// connection-driven one while the user is connected, until it expires.
 func (s *statusService) GetUserStatus(ctx context.Context, userID int) (*models.UserStatus, error) {
 	if userID <= 0 {
 		return nil, errors.New("invalid user ID")
 	}
 
-	return s.repo.GetByUserID(ctx, userID)
+	status, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return resolveStatus(status), nil
+}
+
+func (s *statusService) GetStatuses(ctx context.Context, userIDs []int) ([]*models.UserStatus, error) {
+	statuses, err := s.repo.GetByUserIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i, status := range statuses {
+		statuses[i] = resolveStatus(status)
+	}
+	return statuses, nil
+}
+
+// GetStatusesFor returns the statuses as the viewer is allowed to see them.
+// The viewer's own status is returned in full, and users blocked either way
+// are hidden.
+func (s *statusService) GetStatusesFor(ctx context.Context, viewerID int, userIDs []int) ([]*models.UserStatus, error) {
+	statuses, err := s.GetStatuses(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts, err := s.ContactsAmong(ctx, viewerID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := s.BlockedAmong(ctx, viewerID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, status := range statuses {
+		switch {
+		case status.UserID == viewerID:
+		case blocked[status.UserID]:
+			statuses[i] = status.Hidden()
+		default:
+			statuses[i] = status.ViewFor(contacts[status.UserID])
+		}
+	}
+	return statuses, nil
+}
+
+func (s *statusService) ContactsAmong(ctx context.Context, viewerID int, userIDs []int) (map[int]bool, error) {
+	return s.contacts.ContactsAmong(ctx, viewerID, userIDs)
+}
+
+func (s *statusService) BlockedAmong(ctx context.Context, viewerID int, userIDs []int) (map[int]bool, error) {
+	return s.blocks.BlockedAmong(ctx, viewerID, userIDs)
+}
+
+// UpdatePrivacySettings merges the update into the stored settings and
+// returns the result.
+func (s *statusService) UpdatePrivacySettings(ctx context.Context, userID int, update *models.PrivacyUpdate) (*models.PrivacySettings, error) {
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+	if update.OnlineStatus == nil && update.LastSeen == nil {
+		return nil, errors.New("no privacy settings to update")
+	}
+
+	status, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	settings := models.PrivacySettings{
+		OnlineStatus: models.VisibilityEveryone,
+		LastSeen:     models.VisibilityEveryone,
+	}
+	if status.Privacy != nil {
+		settings = *status.Privacy
+	}
+	if update.OnlineStatus != nil {
+		settings.OnlineStatus = *update.OnlineStatus
+	}
+	if update.LastSeen != nil {
+		settings.LastSeen = *update.LastSeen
+	}
+
+	for _, visibility := range []string{settings.OnlineStatus, settings.LastSeen} {
+		switch visibility {
+		case models.VisibilityEveryone, models.VisibilityContacts, models.VisibilityNobody:
+		default:
+			return nil, errors.New("invalid visibility")
+		}
+	}
+
+	if err := s.repo.UpdatePrivacy(ctx, userID, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// ExpireCustomStatuses clears custom statuses past their expiry and returns
+// the resulting statuses so they can be broadcast.
+func (s *statusService) ExpireCustomStatuses(ctx context.Context) ([]*models.UserStatus, error) {
+	userIDs, err := s.repo.ClearExpiredCustom(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*models.UserStatus, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if err := s.endInvisibility(ctx, userID); err != nil {
+			return statuses, err
+		}
+		status, err := s.GetUserStatus(ctx, userID)
+		if err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
 }
 
-func (s *statusService) GetAllStatuses(ctx context.Context) ([]*models.UserStatus, error) {
-	return s.repo.GetAll(ctx)
+// recordVisibility appends the start or end of an invisible period to the
+// presence history, so activity reports can leave it out.
+func (s *statusService) recordVisibility(ctx context.Context, userID int, status string) error {
+	return s.events.Create(ctx, &models.StatusEvent{UserID: userID, Status: status, OccurredAt: time.Now()})
 }
 
-func (s *statusService) UpdateAllUsersOffline(ctx context.Context) error {
-	return s.repo.UpdateAllOffline(ctx)
+// endInvisibility closes the invisible period of a user whose custom status
+// expired, if they were invisible.
+func (s *statusService) endInvisibility(ctx context.Context, userID int) error {
+	last, err := s.events.GetLastBefore(ctx, userID, time.Now(), []string{models.StatusInvisible, models.StatusEventVisible})
+	if err != nil || last == nil || last.Status != models.StatusInvisible {
+		return err
+	}
+	return s.recordVisibility(ctx, userID, models.StatusEventVisible)
+}
+
+// InvalidateStatus drops any locally cached copy of the user's status, for
+// changes made by another node.
+func (s *statusService) InvalidateStatus(userID int) {
+	if cache, ok := s.repo.(repository.StatusInvalidator); ok {
+		cache.Invalidate(userID)
+	}
+}
+
+// invisibleAt reports whether the user chose to appear offline and the
+// choice has not expired by now.
+func invisibleAt(status *models.UserStatus, now time.Time) bool {
+	return status.ManualStatus == models.StatusInvisible &&
+		(status.StatusExpiresAt == nil || status.StatusExpiresAt.After(now))
+}
+
+func resolveStatus(status *models.UserStatus) *models.UserStatus {
+	if status.StatusExpiresAt != nil && !status.StatusExpiresAt.After(time.Now()) {
+		status.ManualStatus = ""
+		status.StatusText = ""
+		status.StatusEmoji = ""
+		status.StatusExpiresAt = nil
+	}
+	if status.Status != models.StatusOffline && status.ManualStatus != "" {
+		status.Status = status.ManualStatus
+	}
+	return status
 }
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+)
+
+func visibility(v string) *string { return &v }
+
+func TestUpdatePrivacySettingsMergesPartialUpdates(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeStatusRepo()
+	statuses := NewStatusService(repo, &fakeStatusEvents{}, fakeContacts{}, newFakeBlocks())
+
+	settings, err := statuses.UpdatePrivacySettings(ctx, 1, &models.PrivacyUpdate{LastSeen: visibility(models.VisibilityNobody)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.PrivacySettings{OnlineStatus: models.VisibilityEveryone, LastSeen: models.VisibilityNobody}
+	if *settings != want {
+		t.Fatalf("settings = %+v, want %+v", *settings, want)
+	}
+
+	settings, err = statuses.UpdatePrivacySettings(ctx, 1, &models.PrivacyUpdate{OnlineStatus: visibility(models.VisibilityContacts)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.OnlineStatus = models.VisibilityContacts
+	if *settings != want {
+		t.Fatalf("settings = %+v, want %+v", *settings, want)
+	}
+	stored, _ := repo.GetByUserID(ctx, 1)
+	if *stored.Privacy != want {
+		t.Fatalf("stored = %+v, want %+v", *stored.Privacy, want)
+	}
+}
+
+func TestUpdatePrivacySettingsRejectsInvalidUpdates(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeStatusRepo()
+	statuses := NewStatusService(repo, &fakeStatusEvents{}, fakeContacts{}, newFakeBlocks())
+
+	for name, update := range map[string]*models.PrivacyUpdate{
+		"empty":   {},
+		"unknown": {OnlineStatus: visibility("friends")},
+		"blank":   {LastSeen: visibility("")},
+	} {
+		if _, err := statuses.UpdatePrivacySettings(ctx, 1, update); err == nil {
+			t.Errorf("%s update was accepted", name)
+		}
+	}
+	if _, ok := repo.statuses[1]; ok {
+		t.Fatal("a rejected update was stored")
+	}
+}
+
+func TestGetStatusesForAppliesPrivacy(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeStatusRepo()
+	// 1 is a contact of 2 but not of 3, and 4 blocked 1.
+	statuses := NewStatusService(repo, &fakeStatusEvents{}, fakeContacts{{1, 2}: true}, newFakeBlocks([2]int{4, 1}))
+
+	statuses.UpdateUserStatus(ctx, 1, models.StatusOnline)
+	statuses.UpdatePrivacySettings(ctx, 1, &models.PrivacyUpdate{
+		OnlineStatus: visibility(models.VisibilityContacts),
+		LastSeen:     visibility(models.VisibilityNobody),
+	})
+
+	cases := []struct {
+		viewerID     int
+		wantStatus   string
+		wantLastSeen bool
+	}{
+		{viewerID: 1, wantStatus: models.StatusOnline, wantLastSeen: true},
+		{viewerID: 2, wantStatus: models.StatusOnline},
+		{viewerID: 3, wantStatus: models.StatusOffline},
+		{viewerID: 4, wantStatus: models.StatusOffline},
+	}
+	for _, tc := range cases {
+		got, err := statuses.GetStatusesFor(ctx, tc.viewerID, []int{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0].Status != tc.wantStatus || got[0].LastSeen.IsZero() == tc.wantLastSeen {
+			t.Errorf("viewer %d sees %s, last seen %v", tc.viewerID, got[0].Status, got[0].LastSeen)
+		}
+		if tc.viewerID != 1 && got[0].Privacy != nil {
+			t.Errorf("viewer %d sees the privacy settings", tc.viewerID)
+		}
+	}
+}
+
+func TestLastSeenFrozenWhileInvisible(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeStatusRepo()
+	statuses := NewStatusService(repo, &fakeStatusEvents{}, fakeContacts{}, newFakeBlocks())
+
+	statuses.UpdateUserStatus(ctx, 1, models.StatusOnline)
+	wentInvisible := time.Now().Add(-time.Hour).Truncate(time.Second)
+	current, _ := repo.GetByUserID(ctx, 1)
+	current.LastSeen = wentInvisible
+	repo.put(current)
+	if _, err := statuses.SetCustomStatus(ctx, 1, &models.StatusUpdate{Status: models.StatusInvisible}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reconnecting while invisible must not move last seen, or watchers
+	// could tell the "offline" user just came online.
+	for _, status := range []string{models.StatusOffline, models.StatusOnline} {
+		if err := statuses.UpdateUserStatus(ctx, 1, status); err != nil {
+			t.Fatal(err)
+		}
+		got, err := statuses.GetStatusesFor(ctx, 2, []int{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0].Status != models.StatusOffline || !got[0].LastSeen.Equal(wentInvisible) {
+			t.Fatalf("after going %s, viewer sees %s, last seen %v; want offline, %v", status, got[0].Status, got[0].LastSeen, wentInvisible)
+		}
+	}
+
+	if _, err := statuses.SetCustomStatus(ctx, 1, &models.StatusUpdate{}); err != nil {
+		t.Fatal(err)
+	}
+	statuses.UpdateUserStatus(ctx, 1, models.StatusOnline)
+	if got, _ := statuses.GetUserStatus(ctx, 1); !got.LastSeen.After(wentInvisible) {
+		t.Fatalf("last seen = %v after becoming visible again", got.LastSeen)
+	}
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// throttle limits how often a busy key, such as an API key or a session,
+// writes its last use to the database.
+type throttle struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newThrottle(interval time.Duration) *throttle {
+	return &throttle{interval: interval, last: make(map[string]time.Time)}
+}
+
+// allow reports whether key is due to be written at now, and if so records it.
+func (t *throttle) allow(key string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+	// Forget stale entries now and then so the map does not grow forever.
+	if len(t.last) >= 10000 {
+		for k, last := range t.last {
+			if now.Sub(last) >= t.interval {
+				delete(t.last, k)
+			}
+		}
+	}
+	t.last[key] = now
+	return true
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/repository"
+	"github.com/chatapp/pkg/totp"
+)
+
+var (
+	ErrInvalidCode         = errors.New("invalid verification code")
+	ErrTOTPNotEnrolled     = errors.New("two-factor authentication is not being enrolled")
+	ErrTOTPAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA token")
+)
+
+const (
+	recoveryCodeCount = 10
+	// totpSkew accepts codes from one step before or after the current one.
+	totpSkew = 1
+)
+
+type TwoFactorService interface {
+	// Enroll generates a secret that takes effect once Confirm succeeds.
+	Enroll(ctx context.Context, userID int) (*models.TOTPEnrollment, error)
+	// Confirm enables 2FA and returns the recovery codes, which are only
+	// shown this once.
+	Confirm(ctx context.Context, userID int, code string) ([]string, error)
+	Disable(ctx context.Context, userID int, code string) error
+	// Reset disables 2FA without a code, for administrators helping a user
+	// who lost their authenticator and recovery codes. The caller in ctx
+	// must be allowed to reset 2FA.
+	Reset(ctx context.Context, userID int) error
+	// Verify accepts a current TOTP code or an unused recovery code.
+	Verify(ctx context.Context, user *models.User, code string) error
+}
+
+type twoFactorService struct {
+	users  repository.UserRepository
+	issuer string
+}
+
+// NewTwoFactorService labels enrollments with issuer in authenticator apps.
+func NewTwoFactorService(users repository.UserRepository, issuer string) TwoFactorService {
+	return &twoFactorService{users: users, issuer: issuer}
+}
+
+func (s *twoFactorService) Enroll(ctx context.Context, userID int) (*models.TOTPEnrollment, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.SetTOTPSecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+
+	return &models.TOTPEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(s.issuer, user.Username, secret),
+	}, nil
+}
+
+func (s *twoFactorService) Confirm(ctx context.Context, userID int, code string) ([]string, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if err := s.verifyTOTP(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	if err := s.users.EnableTOTP(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *twoFactorService) Disable(ctx context.Context, userID int, code string) error {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	if err := s.Verify(ctx, user, code); err != nil {
+		return err
+	}
+	return s.users.DisableTOTP(ctx, userID)
+}
+
+func (s *twoFactorService) Reset(ctx context.Context, userID int) error {
+	if err := policy.Authorize(ctx, policy.PermTwoFactorReset); err != nil {
+		return err
+	}
+	if _, err := s.users.GetByID(ctx, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("user not found")
+		}
+		return err
+	}
+	return s.users.DisableTOTP(ctx, userID)
+}
+
+func (s *twoFactorService) Verify(ctx context.Context, user *models.User, code string) error {
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == 6 {
+		return s.verifyTOTP(ctx, user, code)
+	}
+
+	used, err := s.users.UseRecoveryCode(ctx, user.ID, hashToken(normalizeRecoveryCode(code)), time.Now())
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+func (s *twoFactorService) verifyTOTP(ctx context.Context, user *models.User, code string) error {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew)
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	fresh, err := s.users.UseTOTPStep(ctx, user.ID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// newRecoveryCode returns ten base32 characters grouped as XXXXX-XXXXX.
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 7)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.EncodeToString(buf)[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
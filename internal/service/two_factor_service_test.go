@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/pkg/totp"
+)
+
+// currentCode returns the authenticator's code offset steps from now.
+func currentCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enrollTOTP enables 2FA for the user and returns the secret and recovery
+// codes.
+func enrollTOTP(t *testing.T, twoFactor TwoFactorService, userID int) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	enrollment, err := twoFactor.Enroll(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := twoFactor.Confirm(ctx, userID, currentCode(t, enrollment.Secret, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enrollment.Secret, codes
+}
+
+func TestEnrollmentTakesEffectOnConfirm(t *testing.T) {
+	users := newFakeUserRepo(&models.User{ID: 1, Username: "ana"})
+	twoFactor := NewTwoFactorService(users, "ChatApp")
+	ctx := context.Background()
+
+	enrollment, err := twoFactor.Enroll(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(enrollment.OTPAuthURI, "ChatApp:ana") {
+		t.Fatalf("URI = %s", enrollment.OTPAuthURI)
+	}
+	if user, _ := users.GetByID(ctx, 1); user.TOTPEnabled {
+		t.Fatal("2FA enabled before the code was confirmed")
+	}
+
+	if _, err := twoFactor.Confirm(ctx, 1, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("Confirm with a wrong code = %v, want ErrInvalidCode", err)
+	}
+	codes, err := twoFactor.Confirm(ctx, 1, currentCode(t, enrollment.Secret, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	if user, _ := users.GetByID(ctx, 1); !user.TOTPEnabled {
+		t.Fatal("2FA not enabled after confirming")
+	}
+	if _, err := twoFactor.Enroll(ctx, 1); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Fatalf("Enroll while enabled = %v, want ErrTOTPAlreadyEnabled", err)
+	}
+}
+
+func TestConfirmRequiresEnrollment(t *testing.T) {
+	twoFactor := NewTwoFactorService(newFakeUserRepo(&models.User{ID: 1, Username: "ana"}), "ChatApp")
+	if _, err := twoFactor.Confirm(context.Background(), 1, "123456"); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Fatalf("Confirm without Enroll = %v, want ErrTOTPNotEnrolled", err)
+	}
+}
+
+func TestVerifyRejectsReusedCode(t *testing.T) {
+	users := newFakeUserRepo(&models.User{ID: 1, Username: "ana"})
+	twoFactor := NewTwoFactorService(users, "ChatApp")
+	ctx := context.Background()
+	secret, _ := enrollTOTP(t, twoFactor, 1)
+	user, _ := users.GetByID(ctx, 1)
+
+	// The code used to confirm cannot log in again.
+	if err := twoFactor.Verify(ctx, user, currentCode(t, secret, 0)); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("reused code = %v, want ErrInvalidCode", err)
+	}
+	next := currentCode(t, secret, 1)
+	if err := twoFactor.Verify(ctx, user, next); err != nil {
+		t.Fatalf("next code: %v", err)
+	}
+	if err := twoFactor.Verify(ctx, user, next); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("next code reused = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestRecoveryCodesWorkOnce(t *testing.T) {
+	users := newFakeUserRepo(&models.User{ID: 1, Username: "ana"})
+	twoFactor := NewTwoFactorService(users, "ChatApp")
+	ctx := context.Background()
+	_, codes := enrollTOTP(t, twoFactor, 1)
+	user, _ := users.GetByID(ctx, 1)
+
+	// Case and the dash do not matter.
+	typed := strings.ToLower(strings.ReplaceAll(codes[0], "-", ""))
+	if err := twoFactor.Verify(ctx, user, typed); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if err := twoFactor.Verify(ctx, user, codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("used recovery code = %v, want ErrInvalidCode", err)
+	}
+	if err := twoFactor.Verify(ctx, user, codes[1]); err != nil {
+		t.Fatalf("other recovery code: %v", err)
+	}
+}
+
+func TestDisableRequiresCodeAndResetRequiresAdmin(t *testing.T) {
+	users := newFakeUserRepo(&models.User{ID: 1, Username: "ana"})
+	twoFactor := NewTwoFactorService(users, "ChatApp")
+	ctx := context.Background()
+	_, codes := enrollTOTP(t, twoFactor, 1)
+
+	if err := twoFactor.Disable(ctx, 1, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("Disable with a wrong code = %v, want ErrInvalidCode", err)
+	}
+	if err := twoFactor.Disable(ctx, 1, codes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := users.GetByID(ctx, 1); user.TOTPEnabled || user.TOTPSecret != "" {
+		t.Fatal("2FA still enabled after Disable")
+	}
+
+	enrollTOTP(t, twoFactor, 1)
+	moderator := policy.NewContext(ctx, policy.Subject{UserID: 2, Role: policy.RoleModerator})
+	if err := twoFactor.Reset(moderator, 1); err == nil {
+		t.Fatal("moderator reset 2FA")
+	}
+	admin := policy.NewContext(ctx, policy.Subject{UserID: 3, Role: policy.RoleAdmin})
+	if err := twoFactor.Reset(admin, 1); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := users.GetByID(ctx, 1); user.TOTPEnabled {
+		t.Fatal("2FA still enabled after Reset")
+	}
+}
@@ -1,37 +1,233 @@
 package websocket
 
 import (
+	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/service"
 	"github.com/gorilla/websocket"
 )
 
+// Close codes sent when the connection's credentials stop being valid.
+const (
+	CloseTokenExpired = 4001
+	CloseTokenRevoked = 4002
+)
+
+var closeReasons = map[int]string{
+	CloseTokenExpired: "token expired",
+	CloseTokenRevoked: "token revoked",
+}
+
 type Client struct {
-	Hub    *Hub
-	Conn   *websocket.Conn
-	UserID int
-	Send   chan *models.Message
+	Hub       *Hub
+	Conn      *websocket.Conn
+	UserID    int
+	SessionID string
+	Send      chan *models.Message
+
+	lastActive atomic.Int64
+	lastInput  atomic.Int64
+	away       atomic.Bool
+
+	// token is the access token the connection was authenticated with; a
+	// reauth frame replaces it. reauthRequested is set once the client has
+	// been asked for a fresh token.
+	token           atomic.Pointer[service.TokenInfo]
+	reauthRequested atomic.Bool
+	closeCode       atomic.Int32
 }
 
 func NewClient(hub *Hub, conn *websocket.Conn, userID int) *Client {
-	return &Client{
+	c := &Client{
 		Hub:    hub,
 		Conn:   conn,
 		UserID: userID,
 		Send:   make(chan *models.Message, 256),
 	}
+	c.touch()
+	c.lastInput.Store(time.Now().UnixNano())
+	return c
+}
+
+// touch records that the peer is still alive. Only connections touched within
+// the presence TTL keep their session heartbeating.
+func (c *Client) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+func (c *Client) activeSince(t time.Time) bool {
+	return c.lastActive.Load() >= t.UnixNano()
+}
+
+// markInput records a frame from the user, as opposed to a pong, and brings
+// an away connection back online.
+func (c *Client) markInput() {
+	c.lastInput.Store(time.Now().UnixNano())
+	if c.away.CompareAndSwap(true, false) {
+		c.Hub.setAutoStatus(c.UserID, models.StatusOnline)
+	}
+}
+
+func (c *Client) inputSince(t time.Time) bool {
+	return c.lastInput.Load() >= t.UnixNano()
+}
+
+// SetToken records the access token that authenticated the connection, so
+// the hub can close it when the token expires or is revoked.
+func (c *Client) SetToken(token *service.TokenInfo) {
+	c.token.Store(token)
+	c.reauthRequested.Store(false)
+}
+
+// setRole applies a role change to the connection's token, so frames are
+// authorized against the new role without waiting for a reauth.
+func (c *Client) setRole(role policy.Role) {
+	for {
+		token := c.token.Load()
+		if token == nil {
+			return
+		}
+		updated := *token
+		updated.Role = role
+		if c.token.CompareAndSwap(token, &updated) {
+			return
+		}
+	}
+}
+
+// tokenExpired reports whether the connection's token lapsed by now.
+func (c *Client) tokenExpired(now time.Time) bool {
+	token := c.token.Load()
+	return token != nil && !token.ExpiresAt.IsZero() && !now.Before(token.ExpiresAt)
+}
+
+// tokenExpiresWithin reports whether the token lapses before now+window.
+func (c *Client) tokenExpiresWithin(now time.Time, window time.Duration) bool {
+	token := c.token.Load()
+	return token != nil && !token.ExpiresAt.IsZero() && token.ExpiresAt.Sub(now) <= window
+}
+
+// authorizeFrame applies the same role permissions and API key scopes as the
+// equivalent REST routes, answering with an error frame when the client may
+// not send the frame. A token that expired since the last sweep closes the
+// connection right away.
+func (c *Client) authorizeFrame(msgType string) bool {
+	if c.tokenExpired(time.Now()) {
+		c.Hub.closeClient(c, CloseTokenExpired)
+		return false
+	}
+
+	perm, scope := frameRequirements(msgType)
+	token := c.token.Load()
+	if perm == "" || token == nil {
+		return true
+	}
+
+	switch {
+	case !policy.Can(token.Role, perm):
+		c.sendError("permission denied")
+		return false
+	case !token.HasScope(scope):
+		c.sendError("missing scope " + scope)
+		return false
+	}
+	return true
+}
+
+// frameRequirements is the permission and API key scope a client frame
+// requires. Frames that only manage the connection require neither.
+func frameRequirements(msgType string) (policy.Permission, string) {
+	switch msgType {
+	case "set_status":
+		return policy.PermStatusWrite, models.ScopeStatusWrite
+	case "subscribe_presence", "unsubscribe_presence":
+		return policy.PermPresenceRead, models.ScopeStatusRead
+	case "activity", "reauth":
+		return "", ""
+	default:
+		return policy.PermMessagesSend, models.ScopeMessagesSend
+	}
+}
+
+func (c *Client) sendError(content string) {
+	c.Hub.sendToClient(c, &models.Message{
+		Type:      "error",
+		Content:   content,
+		Timestamp: time.Now(),
+	})
+}
+
+func (c *Client) hasTokenID(jti string) bool {
+	token := c.token.Load()
+	return token != nil && token.ID != "" && token.ID == jti
+}
+
+func (c *Client) inSession(sessionID string) bool {
+	token := c.token.Load()
+	return token != nil && token.SessionID != "" && token.SessionID == sessionID
+}
+
+// handleReauth swaps in a fresh token for the same user. A rejected token
+// leaves the current one in place until it expires.
+func (c *Client) handleReauth(tokenString string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := c.Hub.AuthService.ValidateToken(ctx, tokenString)
+	if err != nil || token.UserID != c.UserID {
+		c.Hub.Logger.Warn().Err(err).Int("user_id", c.UserID).Msg("Rejected reauth token")
+		c.sendError("reauth failed")
+		return
+	}
+
+	c.SetToken(token)
+	c.Hub.sendToClient(c, &models.Message{
+		Type:      "reauth_ok",
+		Timestamp: time.Now(),
+	})
+}
+
+func (c *Client) handleSetStatus(update *models.StatusUpdate) {
+	if update == nil {
+		update = &models.StatusUpdate{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	status, err := c.Hub.SetStatus(ctx, c.UserID, update)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+
+	c.Hub.sendToUser(c.UserID, statusMessage(status))
+}
+
+func (c *Client) handleSubscribePresence(userIDs []int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.Hub.SubscribePresence(ctx, c.UserID, userIDs); err != nil {
+		c.Hub.Logger.Error().Err(err).Int("user_id", c.UserID).Msg("Failed to subscribe to presence")
+		c.sendError("failed to subscribe to presence")
+	}
 }
 
 func (c *Client) ReadPump() {
 	defer func() {
-		c.Hub.Unregister <- c
+		c.Hub.Unregister(c)
 		c.Conn.Close()
 	}()
 
 	c.Conn.SetReadLimit(5120)
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
+		c.touch()
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 		return nil
 	})
@@ -46,13 +242,55 @@ func (c *Client) ReadPump() {
 			break
 		}
 
-		msg.SenderID = c.UserID
-		msg.Timestamp = time.Now()
-		msg.Status = "sent"
-		c.Hub.Broadcast <- &msg
+		c.touch()
+		c.markInput()
+
+		if !c.authorizeFrame(msg.Type) {
+			continue
+		}
+
+		switch msg.Type {
+		case "set_status":
+			c.handleSetStatus(msg.Presence)
+			continue
+		case "subscribe_presence":
+			c.handleSubscribePresence(msg.UserIDs)
+			continue
+		case "unsubscribe_presence":
+			c.Hub.UnsubscribePresence(c.UserID, msg.UserIDs)
+			continue
+		case "activity":
+			continue
+		case "reauth":
+			c.handleReauth(msg.Token)
+			continue
+		}
+
+		c.handleChat(&msg)
 	}
 }
 
+// handleChat relays any other frame as a chat message. Only the server sets
+// event types and the fields that go with them, so a client cannot pass a
+// forged event such as mentioned or profile_update on to another user.
+func (c *Client) handleChat(msg *models.Message) {
+	msg.Type = ""
+	msg.SenderID = c.UserID
+	msg.Timestamp = time.Now()
+	msg.Status = "sent"
+	msg.Presence = nil
+	msg.UserIDs = nil
+	msg.Token = ""
+	msg.ContactRequest = nil
+	msg.Profile = nil
+	msg.Silent = false
+	if err := c.Hub.MessageService.PrepareMessage(msg); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	c.Hub.Broadcast(msg)
+}
+
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
@@ -65,7 +303,7 @@ func (c *Client) WritePump() {
 		case message, ok := <-c.Send:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.Conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
 				return
 			}
 
@@ -81,3 +319,13 @@ func (c *Client) WritePump() {
 		}
 	}
 }
+
+// closeMessage is the close frame payload: empty, unless the hub dropped the
+// connection for a reason the client should know about.
+func (c *Client) closeMessage() []byte {
+	code := int(c.closeCode.Load())
+	if code == 0 {
+		return []byte{}
+	}
+	return websocket.FormatCloseMessage(code, closeReasons[code])
+}
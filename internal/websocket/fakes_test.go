@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chatapp/internal/models"
+)
+
+// memoryMessageService assigns IDs in memory. failBatch, when set, decides
+// whether a SendMessages call fails.
+type memoryMessageService struct {
+	nextID    atomic.Int64
+	failBatch func(msgs []*models.Message) error
+
+	mu      sync.Mutex
+	batches [][]*models.Message
+}
+
+func (s *memoryMessageService) PrepareMessage(msg *models.Message) error {
+	return nil
+}
+
+func (s *memoryMessageService) SendMessage(ctx context.Context, msg *models.Message) (*models.Message, error) {
+	msg.ID = s.nextID.Add(1)
+	return msg, nil
+}
+
+func (s *memoryMessageService) SendMessages(ctx context.Context, msgs []*models.Message) error {
+	s.mu.Lock()
+	s.batches = append(s.batches, append([]*models.Message(nil), msgs...))
+	s.mu.Unlock()
+
+	if s.failBatch != nil {
+		if err := s.failBatch(msgs); err != nil {
+			return err
+		}
+	}
+	for _, msg := range msgs {
+		msg.ID = s.nextID.Add(1)
+		msg.Status = "sent"
+	}
+	return nil
+}
+
+func (s *memoryMessageService) batchSizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sizes := make([]int, 0, len(s.batches))
+	for _, batch := range s.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func (s *memoryMessageService) GetConversation(ctx context.Context, user1ID, user2ID, limit int) ([]*models.Message, error) {
+	return nil, nil
+}
+
+func (s *memoryMessageService) GetUserMessages(ctx context.Context, userID, limit int) ([]*models.Message, error) {
+	return nil, nil
+}
+
+func (s *memoryMessageService) GetUndeliveredMessages(ctx context.Context, userID int) ([]*models.Message, error) {
+	return nil, nil
+}
+
+func (s *memoryMessageService) GetMentions(ctx context.Context, userID int, before int64, limit int) ([]*models.Message, error) {
+	return nil, nil
+}
+
+func (s *memoryMessageService) GetConversationPartners(ctx context.Context, userID, limit int) ([]int, error) {
+	return nil, nil
+}
+
+func (s *memoryMessageService) MarkMessagesAsDelivered(ctx context.Context, receiverID int) error {
+	return nil
+}
+
+func (s *memoryMessageService) MarkMessagesAsDeliveredByIDs(ctx context.Context, ids []int64) error {
+	return nil
+}
+
+func (s *memoryMessageService) MarkMessagesAsRead(ctx context.Context, senderID, receiverID int) error {
+	return nil
+}
+
+type memoryStatusService struct{}
+
+func (memoryStatusService) UpdateUserStatus(ctx context.Context, userID int, status string) error {
+	return nil
+}
+
+func (memoryStatusService) GetUserStatus(ctx context.Context, userID int) (*models.UserStatus, error) {
+	return &models.UserStatus{UserID: userID, Status: models.StatusOffline, LastSeen: time.Now()}, nil
+}
+
+func (memoryStatusService) SetCustomStatus(ctx context.Context, userID int, update *models.StatusUpdate) (*models.UserStatus, error) {
+	return &models.UserStatus{UserID: userID, Status: update.Status, LastSeen: time.Now()}, nil
+}
+
+func (memoryStatusService) ExpireCustomStatuses(ctx context.Context) ([]*models.UserStatus, error) {
+	return nil, nil
+}
+
+func (memoryStatusService) GetStatuses(ctx context.Context, userIDs []int) ([]*models.UserStatus, error) {
+	statuses := make([]*models.UserStatus, 0, len(userIDs))
+	for _, userID := range userIDs {
+		statuses = append(statuses, &models.UserStatus{UserID: userID, Status: models.StatusOffline, LastSeen: time.Now()})
+	}
+	return statuses, nil
+}
+
+func (s memoryStatusService) GetStatusesFor(ctx context.Context, viewerID int, userIDs []int) ([]*models.UserStatus, error) {
+	return s.GetStatuses(ctx, userIDs)
+}
+
+func (memoryStatusService) ContactsAmong(ctx context.Context, viewerID int, userIDs []int) (map[int]bool, error) {
+	return nil, nil
+}
+
+func (memoryStatusService) BlockedAmong(ctx context.Context, viewerID int, userIDs []int) (map[int]bool, error) {
+	return nil, nil
+}
+
+func (memoryStatusService) InvalidateStatus(userID int) {}
+
+func (memoryStatusService) UpdatePrivacySettings(ctx context.Context, userID int, update *models.PrivacyUpdate) (*models.PrivacySettings, error) {
+	return &models.PrivacySettings{OnlineStatus: models.VisibilityEveryone, LastSeen: models.VisibilityEveryone}, nil
+}
+
+type memoryAlertPolicy struct{}
+
+func (memoryAlertPolicy) ApplyAlerts(ctx context.Context, msgs []*models.Message) error {
+	return nil
+}
+
+type memoryPresenceService struct{}
+
+var nextSessionID atomic.Int64
+
+func (memoryPresenceService) Connect(ctx context.Context, userID int) (string, error) {
+	return strconv.Itoa(userID) + "-" + strconv.FormatInt(nextSessionID.Add(1), 10), nil
+}
+
+func (memoryPresenceService) Disconnect(ctx context.Context, sessionID string, userID int) (bool, error) {
+	return true, nil
+}
+
+func (memoryPresenceService) Heartbeat(ctx context.Context, sessionIDs, inputIDs []string) error {
+	return nil
+}
+
+func (memoryPresenceService) LastInput(ctx context.Context, userID int) (time.Time, error) {
+	return time.Now(), nil
+}
+
+func (memoryPresenceService) ReapExpired(ctx context.Context) ([]int, error) {
+	return nil, nil
+}
+
+func (memoryPresenceService) ReleaseNode(ctx context.Context) ([]int, error) {
+	return nil, nil
+}
@@ -2,158 +2,709 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
+	"sync"
 	"time"
 
+	"github.com/chatapp/internal/broker"
 	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
 	"github.com/chatapp/internal/service"
 	"github.com/rs/zerolog"
 )
 
+const (
+	topicDeliver  = "deliver"
+	topicPresence = "presence"
+	topicRevoke   = "revoke"
+)
+
+// Hub routes traffic between connected clients. Clients are partitioned into
+// shards by user ID; each shard owns its clients and runs its own loop, and
+// shards talk to each other only through lock-free mailboxes. Deliveries and
+// presence changes go through the broker so that clients connected to other
+// replicas receive them too.
 type Hub struct {
-	Clients      map[int]*Client
-	Register     chan *Client
-	Unregister   chan *Client
-	Broadcast    chan *models.Message
 	ShutdownChan chan struct{}
 
-	MessageService service.MessageService
-	StatusService  service.StatusService
-	Logger         *zerolog.Logger
+	shards    []*shard
+	persister *Persister
+	broker    broker.Broker
+	sessions  sync.Map
+	cfg       HubConfig
+	done      chan struct{}
+
+	// outbox holds encoded events waiting to be published, one queue per
+	// publisher goroutine. outboxMu guards closing it.
+	outbox       []chan outboundEvent
+	outboxMu     sync.RWMutex
+	outboxClosed bool
+	publishers   sync.WaitGroup
+
+	// lastHeartbeat is only used by the presence loop.
+	lastHeartbeat time.Time
+
+	AuthService     service.AuthService
+	MessageService  service.MessageService
+	StatusService   service.StatusService
+	PresenceService service.PresenceService
+	AlertPolicy     service.AlertPolicy
+	Logger          *zerolog.Logger
+}
+
+// hubEvent is the payload exchanged between replicas over the broker.
+type hubEvent struct {
+	UserID        int                `json:"user_id"`
+	Message       *models.Message    `json:"message,omitempty"`
+	Status        *models.UserStatus `json:"status,omitempty"`
+	MarkDelivered bool               `json:"mark_delivered,omitempty"`
+	// PrivacyChanged makes subscribers who just lost access receive the
+	// hidden view once, instead of keeping a stale status.
+	PrivacyChanged bool `json:"privacy_changed,omitempty"`
+	// TokenID is the jti of a revoked access token, and SessionID the ID of
+	// an ended login session.
+	TokenID   string `json:"token_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	// BlockedID is set when UserID blocks that user: each stops seeing the
+	// other's presence.
+	BlockedID int `json:"blocked_id,omitempty"`
+	// Role is set when an administrator changed UserID's role.
+	Role policy.Role `json:"role,omitempty"`
+}
+
+type outboundEvent struct {
+	topic   string
+	userID  int
+	payload []byte
+}
+
+type HubConfig struct {
+	Shards int
+	// Publishers is the number of goroutines publishing hub events to the
+	// broker. Events about the same user always go through the same one.
+	Publishers        int
+	HeartbeatInterval time.Duration
+	PresenceTTL       time.Duration
+	AwayAfter         time.Duration
+	Persister         PersisterConfig
 }
 
 func NewHub(
+	authService service.AuthService,
 	messageService service.MessageService,
 	statusService service.StatusService,
+	presenceService service.PresenceService,
+	alertPolicy service.AlertPolicy,
+	messageBroker broker.Broker,
+	cfg HubConfig,
 	logger *zerolog.Logger,
-) *Hub {
-	return &Hub{
-		Clients:        make(map[int]*Client),
-		Register:       make(chan *Client),
-		Unregister:     make(chan *Client),
-		Broadcast:      make(chan *models.Message),
-		ShutdownChan:   make(chan struct{}),
-		MessageService: messageService,
-		StatusService:  statusService,
-		Logger:         logger,
+) (*Hub, error) {
+	if cfg.Shards < 1 {
+		cfg.Shards = 1
+	}
+	if cfg.Publishers < 1 {
+		cfg.Publishers = 8
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 15 * time.Second
 	}
+	if cfg.PresenceTTL <= cfg.HeartbeatInterval {
+		cfg.PresenceTTL = 4 * cfg.HeartbeatInterval
+	}
+	if cfg.AwayAfter <= 0 {
+		cfg.AwayAfter = 5 * time.Minute
+	}
+
+	h := &Hub{
+		ShutdownChan:    make(chan struct{}),
+		broker:          messageBroker,
+		cfg:             cfg,
+		done:            make(chan struct{}),
+		AuthService:     authService,
+		MessageService:  messageService,
+		StatusService:   statusService,
+		PresenceService: presenceService,
+		AlertPolicy:     alertPolicy,
+		Logger:          logger,
+	}
+	for i := 0; i < cfg.Shards; i++ {
+		h.shards = append(h.shards, newShard(h))
+	}
+	h.persister = NewPersister(messageService, cfg.Persister, h.onPersisted, logger)
+	for i := 0; i < cfg.Publishers; i++ {
+		queue := make(chan outboundEvent, 1024)
+		h.outbox = append(h.outbox, queue)
+		h.publishers.Add(1)
+		go func() {
+			defer h.publishers.Done()
+			h.runPublisher(queue)
+		}()
+	}
+
+	if err := messageBroker.Subscribe(topicDeliver, h.handleDeliverEvent); err != nil {
+		return nil, err
+	}
+	if err := messageBroker.Subscribe(topicPresence, h.handlePresenceEvent); err != nil {
+		return nil, err
+	}
+	if err := messageBroker.Subscribe(topicRevoke, h.handleRevokeEvent); err != nil {
+		return nil, err
+	}
+	return h, nil
 }
 
+// Run starts every shard and blocks until the hub has shut down and all
+// pending messages have been flushed.
 func (h *Hub) Run() {
+	defer close(h.done)
+
+	var wg sync.WaitGroup
+	for _, s := range h.shards {
+		wg.Add(1)
+		go func(s *shard) {
+			defer wg.Done()
+			s.run()
+		}(s)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.runPresence()
+	}()
+	wg.Wait()
+
+	h.persister.Close()
+
+	// Only this node's sessions are released; users connected to other
+	// replicas stay online.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	offline, err := h.PresenceService.ReleaseNode(ctx)
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to release node sessions during shutdown")
+	}
+	for _, userID := range offline {
+		h.broadcastStatus(ctx, userID)
+	}
+	h.closeOutbox()
+}
+
+// runPresence heartbeats the sessions of live connections, marks idle
+// connections away, closes connections whose token expired and reaps
+// sessions and custom statuses that expired anywhere in the cluster,
+// including sessions of dead nodes.
+func (h *Hub) runPresence() {
+	ticker := time.NewTicker(h.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	h.heartbeat()
 	for {
 		select {
-		case client := <-h.Register:
-			h.handleRegister(client)
-		case client := <-h.Unregister:
-			h.handleUnregister(client)
-		case message := <-h.Broadcast:
-			h.handleBroadcast(message)
+		case <-ticker.C:
+			h.heartbeat()
+			h.detectIdle()
+			h.checkTokens()
+			h.reap()
 		case <-h.ShutdownChan:
-			h.handleShutdown()
 			return
 		}
 	}
 }
 
-func (h *Hub) handleRegister(client *Client) {
-	h.Clients[client.UserID] = client
-	h.StatusService.UpdateUserStatus(context.Background(), client.UserID, "online")
-	h.notifyStatusChange(client.UserID, "online")
-	h.sendPendingMessages(client)
-}
+// heartbeat keeps the sessions of live connections alive and records which
+// of them received input since the previous heartbeat. A user who was away
+// and typed on any connection is brought back online, even if another node
+// marked them away.
+func (h *Hub) heartbeat() {
+	now := time.Now()
+	cutoff := now.Add(-h.cfg.PresenceTTL)
+	var sessionIDs, inputIDs []string
+	active := make(map[int]bool)
+	h.sessions.Range(func(key, value interface{}) bool {
+		client := value.(*Client)
+		if client.activeSince(cutoff) {
+			sessionIDs = append(sessionIDs, key.(string))
+		}
+		if client.inputSince(h.lastHeartbeat) {
+			inputIDs = append(inputIDs, key.(string))
+			active[client.UserID] = true
+		}
+		return true
+	})
+	h.lastHeartbeat = now
 
-func (h *Hub) handleUnregister(client *Client) {
-	if _, ok := h.Clients[client.UserID]; ok {
-		delete(h.Clients, client.UserID)
-		close(client.Send)
-		h.StatusService.UpdateUserStatus(context.Background(), client.UserID, "offline")
-		h.notifyStatusChange(client.UserID, "offline")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.PresenceService.Heartbeat(ctx, sessionIDs, inputIDs); err != nil {
+		h.Logger.Error().Err(err).Int("sessions", len(sessionIDs)).Msg("Failed to heartbeat sessions")
+	}
+
+	for userID := range active {
+		if h.autoStatus(ctx, userID) == models.StatusAway {
+			h.setAutoStatus(userID, models.StatusOnline)
+		}
 	}
 }
 
-func (h *Hub) handleBroadcast(message *models.Message) {
+func (h *Hub) reap() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	msg, err := h.MessageService.SendMessage(ctx, message)
+	offline, err := h.PresenceService.ReapExpired(ctx)
 	if err != nil {
-		h.Logger.Error().Err(err).Msg("Failed to save message")
-		return
+		h.Logger.Error().Err(err).Msg("Failed to reap expired sessions")
+	}
+	for _, userID := range offline {
+		h.broadcastStatus(ctx, userID)
 	}
 
-	if client, ok := h.Clients[msg.ReceiverID]; ok {
-		select {
-		case client.Send <- msg:
-			if err := h.MessageService.MarkMessagesAsDelivered(ctx, msg.ReceiverID); err != nil {
-				h.Logger.Error().Err(err).Int("user_id", msg.ReceiverID).Msg("Failed to mark messages as delivered")
-			}
-		default:
-			close(client.Send)
-			delete(h.Clients, client.UserID)
-		}
+	expired, err := h.StatusService.ExpireCustomStatuses(ctx)
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to expire custom statuses")
+	}
+	for _, status := range expired {
+		h.notifyStatusChange(status)
 	}
 }
 
-func (h *Hub) notifyStatusChange(userID int, status string) {
-	for _, client := range h.Clients {
-		if client.UserID != userID {
-			select {
-			case client.Send <- &models.Message{
-				Type:      "status_update",
-				SenderID:  userID,
-				Status:    status,
-				Timestamp: time.Now(),
-			}:
-			default:
-				close(client.Send)
-				delete(h.Clients, client.UserID)
-			}
+// detectIdle marks users away once none of their connections, on this node
+// or any other, has received input for AwayAfter. Away is a per-user state,
+// so an idle second device does not hide activity on the first.
+func (h *Hub) detectIdle() {
+	cutoff := time.Now().Add(-h.cfg.AwayAfter)
+	idle := make(map[int][]*Client)
+	busy := make(map[int]bool)
+	h.sessions.Range(func(_, value interface{}) bool {
+		client := value.(*Client)
+		if client.inputSince(cutoff) {
+			busy[client.UserID] = true
+		} else {
+			idle[client.UserID] = append(idle[client.UserID], client)
 		}
+		return true
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for userID, clients := range idle {
+		if busy[userID] || h.autoStatus(ctx, userID) != models.StatusOnline {
+			continue
+		}
+		lastInput, err := h.PresenceService.LastInput(ctx, userID)
+		if err != nil {
+			h.Logger.Error().Err(err).Int("user_id", userID).Msg("Failed to get last input")
+			continue
+		}
+		if !lastInput.Before(cutoff) {
+			continue
+		}
+
+		for _, client := range clients {
+			client.away.Store(true)
+		}
+		h.setAutoStatus(userID, models.StatusAway)
+	}
+}
+
+// autoStatus returns the user's connection-driven status, or "" when a status
+// they chose themselves overrides it.
+func (h *Hub) autoStatus(ctx context.Context, userID int) string {
+	status, err := h.StatusService.GetUserStatus(ctx, userID)
+	if err != nil {
+		h.Logger.Error().Err(err).Int("user_id", userID).Msg("Failed to load user status")
+		return ""
+	}
+	if status.ManualStatus != "" {
+		return ""
 	}
+	return status.Status
+}
+
+func (h *Hub) checkTokens() {
+	for _, s := range h.shards {
+		s.mailbox.push(shardEvent{kind: eventCheckTokens})
+	}
+}
+
+// RevokeToken closes every connection, on any replica, that authenticated
+// with the access token.
+func (h *Hub) RevokeToken(tokenID string) {
+	if tokenID == "" {
+		return
+	}
+	h.publish(topicRevoke, hubEvent{TokenID: tokenID})
+}
+
+// RevokeSession closes every connection, on any replica, that belongs to the
+// login session.
+func (h *Hub) RevokeSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	h.publish(topicRevoke, hubEvent{SessionID: sessionID})
+}
+
+// UpdateRole applies a role change to the user's connections on every
+// replica. Their tokens were validated with the old role.
+func (h *Hub) UpdateRole(userID int, role policy.Role) {
+	h.publish(topicRevoke, hubEvent{UserID: userID, Role: role})
 }
 
-func (h *Hub) sendPendingMessages(client *Client) {
+// setAutoStatus records an activity-driven transition between online and
+// away and broadcasts the resulting effective status.
+func (h *Hub) setAutoStatus(userID int, status string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	messages, err := h.MessageService.GetUndeliveredMessages(ctx, client.UserID)
+	if err := h.StatusService.UpdateUserStatus(ctx, userID, status); err != nil {
+		h.Logger.Error().Err(err).Int("user_id", userID).Str("status", status).Msg("Failed to update user status")
+		return
+	}
+	h.broadcastStatus(ctx, userID)
+}
+
+// SetStatus applies a status chosen by the user and broadcasts it. It backs
+// both the REST endpoint and the set_status WebSocket frame.
+func (h *Hub) SetStatus(ctx context.Context, userID int, update *models.StatusUpdate) (*models.UserStatus, error) {
+	status, err := h.StatusService.SetCustomStatus(ctx, userID, update)
 	if err != nil {
-		h.Logger.Error().Err(err).Int("user_id", client.UserID).Msg("Failed to fetch pending messages")
+		return nil, err
+	}
+	h.notifyStatusChange(status)
+	return status, nil
+}
+
+// UpdatePrivacy changes the user's privacy settings and re-announces their
+// status so subscribers see it under the new rules.
+func (h *Hub) UpdatePrivacy(ctx context.Context, userID int, update *models.PrivacyUpdate) (*models.PrivacySettings, error) {
+	settings, err := h.StatusService.UpdatePrivacySettings(ctx, userID, update)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := h.StatusService.GetUserStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	h.publish(topicPresence, hubEvent{UserID: userID, Status: status, PrivacyChanged: true})
+	return settings, nil
+}
+
+func (h *Hub) broadcastStatus(ctx context.Context, userID int) {
+	status, err := h.StatusService.GetUserStatus(ctx, userID)
+	if err != nil {
+		h.Logger.Error().Err(err).Int("user_id", userID).Msg("Failed to load user status")
 		return
 	}
+	// An invisible user appears offline whether connected or not; announcing
+	// the change would tell watchers they just came or went.
+	if status.ManualStatus == models.StatusInvisible {
+		return
+	}
+	h.notifyStatusChange(status)
+}
+
+func (h *Hub) Register(client *Client) {
+	select {
+	case h.shardFor(client.UserID).register <- client:
+	case <-h.ShutdownChan:
+	}
+}
 
-	for _, msg := range messages {
-		select {
-		case client.Send <- msg:
-			if err := h.MessageService.MarkMessagesAsDelivered(ctx, client.UserID); err != nil {
-				h.Logger.Error().Err(err).Int("user_id", client.UserID).Msg("Failed to mark messages as delivered")
+func (h *Hub) Unregister(client *Client) {
+	select {
+	case h.shardFor(client.UserID).unregister <- client:
+	case <-h.ShutdownChan:
+	}
+}
+
+func (h *Hub) Broadcast(message *models.Message) {
+	select {
+	case h.shardFor(message.SenderID).broadcast <- message:
+	case <-h.ShutdownChan:
+	}
+}
+
+// Shutdown stops the hub and waits for Run to finish.
+func (h *Hub) Shutdown() {
+	close(h.ShutdownChan)
+	<-h.done
+}
+
+func (h *Hub) shardFor(userID int) *shard {
+	return h.shards[uint(userID)%uint(len(h.shards))]
+}
+
+// onPersisted runs on a persister worker with each flushed batch. The acks
+// go straight to the senders' shards, since the senders are connected to
+// this replica; the messages themselves are queued for publishing so
+// whichever replica holds each receiver delivers them, unless the receiver
+// rejected them. The receivers' conversation settings, looked up once for the
+// batch, decide beforehand whether each message alerts them.
+func (h *Hub) onPersisted(messages []*models.Message) {
+	deliver := make([]*models.Message, 0, len(messages))
+	for _, message := range messages {
+		ack := *message
+		ack.Type = "ack"
+		h.sendToUser(message.SenderID, &ack)
+		if message.Status != models.MessageRejected && !message.Dropped {
+			deliver = append(deliver, message)
+		}
+	}
+	if len(deliver) == 0 {
+		return
+	}
+
+	h.applyAlerts(deliver)
+	for _, message := range deliver {
+		h.publish(topicDeliver, hubEvent{UserID: message.ReceiverID, Message: message, MarkDelivered: true})
+
+		// Mentions alert the receiver even in a muted conversation.
+		if message.Mentions(message.ReceiverID) {
+			mentioned := *message
+			mentioned.Type = "mentioned"
+			mentioned.Silent = false
+			h.Notify(message.ReceiverID, &mentioned)
+		}
+	}
+}
+
+// applyAlerts marks the messages that should not alert their receiver. On
+// failure the messages are delivered with an alert.
+func (h *Hub) applyAlerts(messages []*models.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.AlertPolicy.ApplyAlerts(ctx, messages); err != nil {
+		h.Logger.Error().Err(err).Int("count", len(messages)).Msg("Failed to apply conversation settings")
+	}
+}
+
+// notifyStatusChange sends the status to every connection subscribed to the
+// user. The full status, privacy settings included, travels to the shards,
+// which render it per subscriber with UserStatus.ViewFor. Blocked users are
+// never subscribed, so they do not receive it.
+func (h *Hub) notifyStatusChange(status *models.UserStatus) {
+	h.publish(topicPresence, hubEvent{
+		UserID: status.UserID,
+		Status: status,
+	})
+}
+
+// SubscribePresence starts delivering the users' status changes to the
+// subscriber and sends their current statuses as a snapshot. Whether the
+// subscriber is a contact of each user is resolved once, here, and reused
+// for every later event.
+func (h *Hub) SubscribePresence(ctx context.Context, subscriberID int, userIDs []int) error {
+	if len(userIDs) > maxPresenceSubscriptions {
+		userIDs = userIDs[:maxPresenceSubscriptions]
+	}
+
+	blocked, err := h.StatusService.BlockedAmong(ctx, subscriberID, userIDs)
+	if err != nil {
+		return err
+	}
+	if len(blocked) > 0 {
+		visible := make([]int, 0, len(userIDs))
+		for _, userID := range userIDs {
+			if !blocked[userID] {
+				visible = append(visible, userID)
 			}
-		default:
-			close(client.Send)
-			delete(h.Clients, client.UserID)
-			return
 		}
+		userIDs = visible
+	}
+
+	statuses, err := h.StatusService.GetStatuses(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+	contacts, err := h.StatusService.ContactsAmong(ctx, subscriberID, userIDs)
+	if err != nil {
+		return err
 	}
+
+	h.shardFor(subscriberID).mailbox.push(shardEvent{
+		kind:     eventSubscribe,
+		userID:   subscriberID,
+		userIDs:  userIDs,
+		contacts: contacts,
+	})
+	for _, status := range statuses {
+		isContact := contacts[status.UserID]
+		if status.UserID != subscriberID && status.OnlineVisibleTo(isContact) {
+			h.sendToUser(subscriberID, statusMessage(status.ViewFor(isContact)))
+		}
+	}
+	return nil
 }
 
-func (h *Hub) handleShutdown() {
-	for _, client := range h.Clients {
-		close(client.Send)
-		shutdownMsg := &models.Message{
-			Type:      "system",
-			Content:   "Server is shutting down",
+// Block stops presence updates between the two users on every replica.
+// Subscribers who were watching are left with the hidden view.
+func (h *Hub) Block(userID, blockedID int) {
+	h.publish(topicPresence, hubEvent{UserID: userID, BlockedID: blockedID})
+}
+
+// BroadcastProfile sends a profile_update event to the users who share a
+// conversation with the profile's owner, except those with a block either way.
+func (h *Hub) BroadcastProfile(ctx context.Context, profile *models.Profile) error {
+	partners, err := h.MessageService.GetConversationPartners(ctx, profile.UserID, maxPresenceSubscriptions)
+	if err != nil {
+		return err
+	}
+	blocked, err := h.StatusService.BlockedAmong(ctx, profile.UserID, partners)
+	if err != nil {
+		return err
+	}
+
+	for _, partnerID := range partners {
+		if blocked[partnerID] {
+			continue
+		}
+		h.Notify(partnerID, &models.Message{
+			Type:      "profile_update",
+			SenderID:  profile.UserID,
+			Profile:   profile,
 			Timestamp: time.Now(),
+		})
+	}
+	return nil
+}
+
+// Notify sends an event to the user's connection on whichever replica
+// holds it.
+func (h *Hub) Notify(userID int, message *models.Message) {
+	h.publish(topicDeliver, hubEvent{UserID: userID, Message: message})
+}
+
+func (h *Hub) UnsubscribePresence(subscriberID int, userIDs []int) {
+	h.shardFor(subscriberID).mailbox.push(shardEvent{kind: eventUnsubscribe, userID: subscriberID, userIDs: userIDs})
+}
+
+func statusMessage(status *models.UserStatus) *models.Message {
+	return &models.Message{
+		Type:      "status_update",
+		SenderID:  status.UserID,
+		Status:    status.Status,
+		Presence:  status.ToUpdate(),
+		Timestamp: time.Now(),
+	}
+}
+
+// sendToUser delivers a message to the user's connections on this node, if
+// any.
+func (h *Hub) sendToUser(userID int, message *models.Message) {
+	h.shardFor(userID).mailbox.push(shardEvent{kind: eventDeliver, userID: userID, message: message})
+}
+
+// sendToClient delivers a message to one connection, such as the reply to a
+// frame it sent.
+func (h *Hub) sendToClient(client *Client, message *models.Message) {
+	h.shardFor(client.UserID).mailbox.push(shardEvent{kind: eventDeliver, userID: client.UserID, client: client, message: message})
+}
+
+// closeClient closes one connection with the given close code from outside
+// its shard.
+func (h *Hub) closeClient(client *Client, code int) {
+	h.shardFor(client.UserID).mailbox.push(shardEvent{kind: eventClose, userID: client.UserID, client: client, code: code})
+}
+
+// publish queues the event for the broker. It never waits on the network, so
+// shard loops and persister workers are not held up by a slow broker; it only
+// blocks while the user's queue is full.
+func (h *Hub) publish(topic string, event hubEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("topic", topic).Msg("Failed to encode hub event")
+		return
+	}
+
+	h.outboxMu.RLock()
+	defer h.outboxMu.RUnlock()
+	if h.outboxClosed {
+		h.Logger.Warn().Str("topic", topic).Int("user_id", event.UserID).Msg("Dropping hub event after shutdown")
+		return
+	}
+	h.outbox[uint(event.UserID)%uint(len(h.outbox))] <- outboundEvent{topic: topic, userID: event.UserID, payload: payload}
+}
+
+func (h *Hub) runPublisher(queue <-chan outboundEvent) {
+	for event := range queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := h.broker.Publish(ctx, event.topic, event.payload); err != nil {
+			h.Logger.Error().Err(err).Str("topic", event.topic).Int("user_id", event.userID).Msg("Failed to publish hub event")
 		}
-		client.Conn.WriteJSON(shutdownMsg)
-		client.Conn.Close()
+		cancel()
 	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := h.StatusService.UpdateAllUsersOffline(ctx); err != nil {
-		h.Logger.Error().Err(err).Msg("Failed to update all users to offline during shutdown")
+// closeOutbox publishes the queued events and stops the publishers. Later
+// events are dropped.
+func (h *Hub) closeOutbox() {
+	h.outboxMu.Lock()
+	h.outboxClosed = true
+	for _, queue := range h.outbox {
+		close(queue)
 	}
+	h.outboxMu.Unlock()
+	h.publishers.Wait()
 }
 
-func (h *Hub) Shutdown() {
-	close(h.ShutdownChan)
+// handleDeliverEvent and handlePresenceEvent run on the broker's goroutine;
+// they only decode the event and push it to the owning shard's mailbox.
+func (h *Hub) handleDeliverEvent(payload []byte) {
+	var event hubEvent
+	if err := json.Unmarshal(payload, &event); err != nil || event.Message == nil {
+		h.Logger.Warn().Err(err).Msg("Dropping malformed deliver event")
+		return
+	}
+
+	h.shardFor(event.UserID).mailbox.push(shardEvent{
+		kind:          eventDeliver,
+		userID:        event.UserID,
+		message:       event.Message,
+		markDelivered: event.MarkDelivered,
+	})
+}
+
+func (h *Hub) handlePresenceEvent(payload []byte) {
+	var event hubEvent
+	if err := json.Unmarshal(payload, &event); err != nil || (event.Status == nil && event.BlockedID == 0) {
+		h.Logger.Warn().Err(err).Msg("Dropping malformed presence event")
+		return
+	}
+
+	if event.BlockedID != 0 {
+		h.shardFor(event.UserID).mailbox.push(shardEvent{kind: eventHide, userID: event.UserID, userIDs: []int{event.BlockedID}})
+		h.shardFor(event.BlockedID).mailbox.push(shardEvent{kind: eventHide, userID: event.BlockedID, userIDs: []int{event.UserID}})
+		return
+	}
+
+	// The change may have been written by another node.
+	h.StatusService.InvalidateStatus(event.UserID)
+
+	for _, s := range h.shards {
+		s.mailbox.push(shardEvent{
+			kind:           eventStatus,
+			userID:         event.UserID,
+			status:         event.Status,
+			privacyChanged: event.PrivacyChanged,
+		})
+	}
+}
+
+func (h *Hub) handleRevokeEvent(payload []byte) {
+	var event hubEvent
+	if err := json.Unmarshal(payload, &event); err != nil || (event.TokenID == "" && event.SessionID == "" && event.Role == "") {
+		h.Logger.Warn().Err(err).Msg("Dropping malformed revoke event")
+		return
+	}
+
+	if event.Role != "" {
+		h.shardFor(event.UserID).mailbox.push(shardEvent{kind: eventSetRole, userID: event.UserID, role: event.Role})
+		return
+	}
+
+	for _, s := range h.shards {
+		s.mailbox.push(shardEvent{kind: eventRevokeToken, tokenID: event.TokenID, sessionID: event.SessionID})
+	}
 }
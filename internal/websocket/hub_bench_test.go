@@ -0,0 +1,356 @@
+package websocket
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/broker"
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+var benchClients = flag.Int("hub.clients", 10000, "simulated clients connected during BenchmarkHub")
+
+const (
+	// benchPopular users are watched by every other client, so each of their
+	// status changes fans out to the whole hub.
+	benchPopular = 10
+	// benchWindow bounds the operations in flight. An operation sends each
+	// client at most two frames, so no client's send buffer can overflow.
+	benchWindow = 64
+)
+
+var benchPersister = PersisterConfig{
+	Workers:       8,
+	BatchSize:     100,
+	FlushInterval: time.Millisecond,
+}
+
+// BenchmarkHub compares the original hub, a single goroutine fed through
+// unbuffered channels, with the sharded hub at several shard counts. Run it
+// with several CPUs to see the shards work in parallel:
+//
+//	go test ./internal/websocket -run '^$' -bench BenchmarkHub -cpu 8 -hub.clients 10000
+func BenchmarkHub(b *testing.B) {
+	targets := []struct {
+		name string
+		new  func(b *testing.B) benchTarget
+	}{
+		{"baseline", newBaselineTarget},
+		{"shards=1", shardedTarget(1)},
+		{"shards=4", shardedTarget(4)},
+		{"shards=16", shardedTarget(16)},
+	}
+
+	for _, target := range targets {
+		b.Run("presence/"+target.name, func(b *testing.B) {
+			clients := *benchClients
+			env := newBenchEnv(b, target.new(b), int32(clients-1))
+			env.subscribeAll(b)
+			// Every status change is delivered to all the popular user's
+			// watchers.
+			env.run(b, func(op int, rng *rand.Rand) {
+				env.target.setStatus(&models.UserStatus{
+					UserID:     rng.Intn(benchPopular) + 1,
+					Status:     models.StatusOnline,
+					StatusText: strconv.Itoa(op),
+				})
+			})
+		})
+		b.Run("messages/"+target.name, func(b *testing.B) {
+			clients := *benchClients
+			env := newBenchEnv(b, target.new(b), 2)
+			// Each message produces an ack for the sender and a delivery
+			// for the receiver.
+			env.run(b, func(op int, rng *rand.Rand) {
+				env.target.send(&models.Message{
+					SenderID:   rng.Intn(clients) + 1,
+					ReceiverID: rng.Intn(clients) + 1,
+					Content:    strconv.Itoa(op),
+				})
+			})
+		})
+	}
+}
+
+// benchTarget is a hub under benchmark.
+type benchTarget interface {
+	register(client *Client)
+	subscribe(userID int, userIDs []int) error
+	setStatus(status *models.UserStatus)
+	send(message *models.Message)
+}
+
+// benchEnv connects the clients and tracks every frame tagged with an
+// operation number, so a run ends exactly when each operation has produced
+// all of its frames.
+type benchEnv struct {
+	target  benchTarget
+	perOp   int32
+	ops     []atomic.Int32
+	done    atomic.Int64
+	dropped atomic.Int64
+	// frames counts every frame received, to tell when the setup settled.
+	frames atomic.Int64
+}
+
+func newBenchEnv(b *testing.B, target benchTarget, perOp int32) *benchEnv {
+	b.Helper()
+	env := &benchEnv{target: target, perOp: perOp, ops: make([]atomic.Int32, b.N)}
+
+	for userID := 1; userID <= *benchClients; userID++ {
+		client := NewClient(nil, nil, userID)
+		go env.consume(client)
+		target.register(client)
+	}
+	env.waitQuiet()
+	return env
+}
+
+func (env *benchEnv) consume(client *Client) {
+	for msg := range client.Send {
+		env.frames.Add(1)
+		if op, ok := benchOp(msg); ok && op < len(env.ops) {
+			if env.ops[op].Add(1) == env.perOp {
+				env.done.Add(1)
+			}
+		}
+	}
+	env.dropped.Add(1)
+}
+
+// benchOp returns the operation number a frame was tagged with.
+func benchOp(msg *models.Message) (int, bool) {
+	tag := msg.Content
+	if msg.Presence != nil {
+		tag = msg.Presence.StatusText
+	}
+	op, err := strconv.Atoi(tag)
+	return op, err == nil
+}
+
+func (env *benchEnv) subscribeAll(b *testing.B) {
+	b.Helper()
+	popular := make([]int, benchPopular)
+	for i := range popular {
+		popular[i] = i + 1
+	}
+
+	for userID := 1; userID <= *benchClients; userID++ {
+		if err := env.target.subscribe(userID, popular); err != nil {
+			b.Fatalf("subscribe: %v", err)
+		}
+	}
+	env.waitQuiet()
+}
+
+// run calls op b.N times from parallel goroutines, keeping at most
+// benchWindow operations unfinished, and waits until every operation has
+// delivered all of its frames. A dropped client fails the run instead of
+// leaving it waiting for frames that will never come.
+func (env *benchEnv) run(b *testing.B, op func(op int, rng *rand.Rand)) {
+	var issued atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for pb.Next() {
+			n := issued.Add(1) - 1
+			for env.done.Load() < n-benchWindow {
+				if env.dropped.Load() > 0 {
+					return
+				}
+				runtime.Gosched()
+			}
+			op(int(n), rng)
+		}
+	})
+
+	for env.done.Load() < int64(b.N) {
+		if dropped := env.dropped.Load(); dropped > 0 {
+			b.Fatalf("%d clients were dropped; %d of %d operations completed", dropped, env.done.Load(), b.N)
+		}
+		runtime.Gosched()
+	}
+	b.StopTimer()
+}
+
+// waitQuiet blocks until the frames triggered by the setup stop arriving.
+func (env *benchEnv) waitQuiet() {
+	last := int64(-1)
+	for {
+		time.Sleep(50 * time.Millisecond)
+		current := env.frames.Load()
+		if current == last {
+			return
+		}
+		last = current
+	}
+}
+
+type shardedBenchTarget struct {
+	hub *Hub
+}
+
+func shardedTarget(shards int) func(b *testing.B) benchTarget {
+	return func(b *testing.B) benchTarget {
+		b.Helper()
+		logger := zerolog.Nop()
+		hub, err := NewHub(nil, &memoryMessageService{}, memoryStatusService{}, memoryPresenceService{}, memoryAlertPolicy{}, broker.NewMemoryBroker(), HubConfig{
+			Shards:    shards,
+			Persister: benchPersister,
+		}, &logger)
+		if err != nil {
+			b.Fatalf("NewHub: %v", err)
+		}
+		go hub.Run()
+		b.Cleanup(hub.Shutdown)
+		return &shardedBenchTarget{hub: hub}
+	}
+}
+
+func (t *shardedBenchTarget) register(client *Client) {
+	client.Hub = t.hub
+	t.hub.Register(client)
+}
+
+func (t *shardedBenchTarget) subscribe(userID int, userIDs []int) error {
+	return t.hub.SubscribePresence(context.Background(), userID, userIDs)
+}
+
+func (t *shardedBenchTarget) setStatus(status *models.UserStatus) {
+	t.hub.notifyStatusChange(status)
+}
+
+func (t *shardedBenchTarget) send(message *models.Message) {
+	t.hub.Broadcast(message)
+}
+
+// baselineHub is the hub as it was before sharding, kept to benchmark
+// against: one goroutine owns every client, all traffic reaches it through
+// unbuffered channels, and status changes go to every other connected
+// client.
+type baselineHub struct {
+	clients    map[int]*Client
+	registerCh chan *Client
+	broadcast  chan *models.Message
+	statuses   chan *models.UserStatus
+	shutdown   chan struct{}
+	done       chan struct{}
+	persister  *Persister
+	persistMu  sync.Mutex
+	persisted  []*models.Message
+	ready      chan struct{}
+}
+
+func newBaselineTarget(b *testing.B) benchTarget {
+	b.Helper()
+	logger := zerolog.Nop()
+	h := &baselineHub{
+		clients:    make(map[int]*Client),
+		registerCh: make(chan *Client),
+		broadcast:  make(chan *models.Message),
+		statuses:   make(chan *models.UserStatus),
+		shutdown:   make(chan struct{}),
+		done:       make(chan struct{}),
+		ready:      make(chan struct{}, 1),
+	}
+	h.persister = NewPersister(&memoryMessageService{}, benchPersister, h.onPersisted, &logger)
+	go h.run()
+	b.Cleanup(func() {
+		close(h.shutdown)
+		<-h.done
+		h.persister.Close()
+	})
+	return h
+}
+
+func (h *baselineHub) run() {
+	defer close(h.done)
+	for {
+		select {
+		case client := <-h.registerCh:
+			h.clients[client.UserID] = client
+		case message := <-h.broadcast:
+			h.persister.Enqueue(message)
+		case status := <-h.statuses:
+			for userID, client := range h.clients {
+				if userID != status.UserID {
+					h.deliver(client, statusMessage(status.Public()))
+				}
+			}
+		case <-h.ready:
+			h.drainPersisted()
+		case <-h.shutdown:
+			for _, client := range h.clients {
+				close(client.Send)
+			}
+			return
+		}
+	}
+}
+
+// onPersisted runs on a persister worker and hands the batch back to the hub
+// loop without blocking, since the loop may be waiting on the persister.
+func (h *baselineHub) onPersisted(messages []*models.Message) {
+	h.persistMu.Lock()
+	h.persisted = append(h.persisted, messages...)
+	h.persistMu.Unlock()
+
+	select {
+	case h.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (h *baselineHub) drainPersisted() {
+	h.persistMu.Lock()
+	messages := h.persisted
+	h.persisted = nil
+	h.persistMu.Unlock()
+
+	for _, msg := range messages {
+		if sender, ok := h.clients[msg.SenderID]; ok {
+			ack := *msg
+			ack.Type = "ack"
+			h.deliver(sender, &ack)
+		}
+		if receiver, ok := h.clients[msg.ReceiverID]; ok {
+			h.deliver(receiver, msg)
+		}
+	}
+}
+
+func (h *baselineHub) deliver(client *Client, message *models.Message) {
+	select {
+	case client.Send <- message:
+	default:
+		close(client.Send)
+		delete(h.clients, client.UserID)
+	}
+}
+
+func (h *baselineHub) register(client *Client) {
+	h.registerCh <- client
+}
+
+// subscribe is a no-op: the original hub sent every status change to every
+// connected client.
+func (h *baselineHub) subscribe(userID int, userIDs []int) error {
+	return nil
+}
+
+func (h *baselineHub) setStatus(status *models.UserStatus) {
+	h.statuses <- status
+}
+
+func (h *baselineHub) send(message *models.Message) {
+	h.broadcast <- message
+}
@@ -0,0 +1,306 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/broker"
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/service"
+	"github.com/rs/zerolog"
+)
+
+func newTestHub(t *testing.T, shards int, messages service.MessageService) *Hub {
+	t.Helper()
+	return newTestHubWithBroker(t, shards, messages, broker.NewMemoryBroker())
+}
+
+func newTestHubWithBroker(t *testing.T, shards int, messages service.MessageService, messageBroker broker.Broker) *Hub {
+	t.Helper()
+	return startHub(t, hubDeps{
+		messages: messages,
+		broker:   messageBroker,
+		cfg:      HubConfig{Shards: shards},
+	})
+}
+
+// hubDeps overrides the in-memory services a test hub runs with.
+type hubDeps struct {
+	messages service.MessageService
+	statuses service.StatusService
+	presence service.PresenceService
+	alerts   service.AlertPolicy
+	broker   broker.Broker
+	cfg      HubConfig
+}
+
+func startHub(t *testing.T, deps hubDeps) *Hub {
+	t.Helper()
+	if deps.messages == nil {
+		deps.messages = &memoryMessageService{}
+	}
+	if deps.statuses == nil {
+		deps.statuses = memoryStatusService{}
+	}
+	if deps.presence == nil {
+		deps.presence = memoryPresenceService{}
+	}
+	if deps.alerts == nil {
+		deps.alerts = memoryAlertPolicy{}
+	}
+	if deps.broker == nil {
+		deps.broker = broker.NewMemoryBroker()
+	}
+	if deps.cfg.Persister.FlushInterval == 0 {
+		deps.cfg.Persister.FlushInterval = time.Millisecond
+	}
+
+	logger := zerolog.Nop()
+	hub, err := NewHub(nil, deps.messages, deps.statuses, deps.presence, deps.alerts, deps.broker, deps.cfg, &logger)
+	if err != nil {
+		t.Fatalf("NewHub: %v", err)
+	}
+	go hub.Run()
+	t.Cleanup(hub.Shutdown)
+	return hub
+}
+
+// connect registers a client without a socket and waits until its shard has
+// taken it.
+func connect(t *testing.T, hub *Hub, userID int) *Client {
+	t.Helper()
+	client := NewClient(hub, nil, userID)
+	hub.Register(client)
+
+	deadline := time.Now().Add(time.Second)
+	for !registered(hub, client) {
+		if time.Now().After(deadline) {
+			t.Fatalf("client of user %d was not registered", userID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return client
+}
+
+func registered(hub *Hub, client *Client) bool {
+	found := false
+	hub.sessions.Range(func(_, value interface{}) bool {
+		found = value == client
+		return !found
+	})
+	return found
+}
+
+// expect returns the next frame of the given type the client receives,
+// skipping presence updates.
+func expect(t *testing.T, client *Client, msgType string) *models.Message {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg, ok := <-client.Send:
+			if !ok {
+				t.Fatalf("connection of user %d closed while waiting for %q", client.UserID, msgType)
+			}
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("user %d did not receive %q", client.UserID, msgType)
+		}
+	}
+}
+
+func TestHubDeliversAcrossShards(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	sender := connect(t, hub, 1)
+	receiver := connect(t, hub, 2)
+	if hub.shardFor(1) == hub.shardFor(2) {
+		t.Fatal("test users share a shard")
+	}
+
+	hub.Broadcast(&models.Message{SenderID: 1, ReceiverID: 2, Content: "oi"})
+
+	ack := expect(t, sender, "ack")
+	delivered := expect(t, receiver, "")
+	if ack.ID == 0 || delivered.ID != ack.ID || delivered.Content != "oi" {
+		t.Fatalf("ack = %+v, delivered = %+v", ack, delivered)
+	}
+}
+
+// stalledBroker holds every Publish until release is closed.
+type stalledBroker struct {
+	broker.Broker
+	release chan struct{}
+}
+
+func (b *stalledBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	<-b.release
+	return b.Broker.Publish(ctx, topic, payload)
+}
+
+func TestPersisterDoesNotWaitForBroker(t *testing.T) {
+	stalled := &stalledBroker{Broker: broker.NewMemoryBroker(), release: make(chan struct{})}
+	hub := newTestHubWithBroker(t, 1, &memoryMessageService{}, stalled)
+	t.Cleanup(func() { close(stalled.release) })
+	sender := connect(t, hub, 1)
+
+	// Both messages go through the same persister worker; with a blocking
+	// publish the second would never be acknowledged.
+	hub.Broadcast(&models.Message{SenderID: 1, ReceiverID: 2, Content: "one"})
+	expect(t, sender, "ack")
+	hub.Broadcast(&models.Message{SenderID: 1, ReceiverID: 2, Content: "two"})
+	if ack := expect(t, sender, "ack"); ack.Content != "two" {
+		t.Fatalf("ack for %q, want the second message", ack.Content)
+	}
+}
+
+func TestShutdownPublishesQueuedEvents(t *testing.T) {
+	logger := zerolog.Nop()
+	received := make(chan []byte, 1)
+	memory := broker.NewMemoryBroker()
+	if err := memory.Subscribe(topicDeliver, func(payload []byte) { received <- payload }); err != nil {
+		t.Fatal(err)
+	}
+	stalled := &stalledBroker{Broker: memory, release: make(chan struct{})}
+	hub, err := NewHub(nil, &memoryMessageService{}, memoryStatusService{}, memoryPresenceService{}, memoryAlertPolicy{}, stalled, HubConfig{}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go hub.Run()
+
+	hub.Notify(7, &models.Message{Type: "contact_request"})
+	close(stalled.release)
+	hub.Shutdown()
+
+	select {
+	case <-received:
+	default:
+		t.Fatal("queued event was not published before shutdown returned")
+	}
+	hub.Notify(7, &models.Message{Type: "contact_request"})
+}
+
+// blockingMessageService drops messages to the users in blockedBy, as if they
+// had blocked the sender.
+type blockingMessageService struct {
+	memoryMessageService
+	blockedBy map[int]bool
+}
+
+func (s *blockingMessageService) SendMessages(ctx context.Context, msgs []*models.Message) error {
+	if err := s.memoryMessageService.SendMessages(ctx, msgs); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		msg.Dropped = s.blockedBy[msg.ReceiverID]
+	}
+	return nil
+}
+
+func TestDroppedMessageIsAcknowledgedLikeAnyOther(t *testing.T) {
+	hub := newTestHub(t, 2, &blockingMessageService{blockedBy: map[int]bool{2: true}})
+	sender := connect(t, hub, 1)
+	blocker := connect(t, hub, 2)
+	other := connect(t, hub, 3)
+
+	hub.Broadcast(&models.Message{SenderID: 1, ReceiverID: 3, Content: "oi"})
+	delivered := expect(t, sender, "ack")
+	expect(t, other, "")
+	hub.Broadcast(&models.Message{SenderID: 1, ReceiverID: 2, Content: "oi"})
+	dropped := expect(t, sender, "ack")
+
+	if dropped.ID == 0 || dropped.Status != delivered.Status {
+		t.Fatalf("ack of dropped message = %+v, want the same shape as %+v", dropped, delivered)
+	}
+	expectNothing(t, blocker, "")
+}
+
+// countingAlertPolicy records the size of each ApplyAlerts call.
+type countingAlertPolicy struct {
+	mu    sync.Mutex
+	calls []int
+}
+
+func (p *countingAlertPolicy) ApplyAlerts(ctx context.Context, msgs []*models.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, len(msgs))
+	return nil
+}
+
+func (p *countingAlertPolicy) batches() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int(nil), p.calls...)
+}
+
+func TestAlertsAppliedOncePerBatch(t *testing.T) {
+	alerts := &countingAlertPolicy{}
+	hub := startHub(t, hubDeps{
+		alerts: alerts,
+		cfg:    HubConfig{Shards: 1, Persister: PersisterConfig{BatchSize: 3, FlushInterval: time.Hour}},
+	})
+	sender := connect(t, hub, 1)
+	receiver := connect(t, hub, 2)
+
+	for _, content := range []string{"um", "dois", "três"} {
+		hub.Broadcast(&models.Message{SenderID: 1, ReceiverID: 2, Content: content})
+	}
+	for i := 0; i < 3; i++ {
+		expect(t, sender, "ack")
+		expect(t, receiver, "")
+	}
+
+	if got := alerts.batches(); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("ApplyAlerts calls = %v, want one call with the batch of 3", got)
+	}
+}
+
+// mutedAlertPolicy silences every message to the users in muted.
+type mutedAlertPolicy struct {
+	muted map[int]bool
+}
+
+func (p mutedAlertPolicy) ApplyAlerts(ctx context.Context, msgs []*models.Message) error {
+	for _, msg := range msgs {
+		msg.Silent = p.muted[msg.ReceiverID]
+	}
+	return nil
+}
+
+func mentionOf(receiverID int) []models.MessageEntity {
+	return []models.MessageEntity{{Type: models.EntityMention, Offset: 0, Length: 4, UserID: receiverID}}
+}
+
+func TestMentionedEventInMutedAndUnmutedConversations(t *testing.T) {
+	hub := startHub(t, hubDeps{
+		alerts: mutedAlertPolicy{muted: map[int]bool{2: true}},
+		cfg:    HubConfig{Shards: 2},
+	})
+	connect(t, hub, 1)
+	muted := connect(t, hub, 2)
+	alerted := connect(t, hub, 3)
+
+	hub.Broadcast(&models.Message{SenderID: 1, ReceiverID: 2, Content: "@ana", Entities: mentionOf(2)})
+	if msg := expect(t, muted, ""); !msg.Silent {
+		t.Fatalf("message in a muted conversation = %+v, want it silent", msg)
+	}
+	if mentioned := expect(t, muted, "mentioned"); mentioned.Silent {
+		t.Fatal("mentioned event is silent")
+	}
+
+	hub.Broadcast(&models.Message{SenderID: 1, ReceiverID: 3, Content: "@ana", Entities: mentionOf(3)})
+	if msg := expect(t, alerted, ""); msg.Silent {
+		t.Fatalf("message = %+v, want it to alert", msg)
+	}
+	if mentioned := expect(t, alerted, "mentioned"); mentioned.Silent {
+		t.Fatal("mentioned event is silent")
+	}
+
+	hub.Broadcast(&models.Message{SenderID: 1, ReceiverID: 3, Content: "oi"})
+	expect(t, alerted, "")
+	expectNothing(t, alerted, "mentioned")
+}
@@ -0,0 +1,48 @@
+package websocket
+
+import "sync/atomic"
+
+type mailboxNode struct {
+	next  atomic.Pointer[mailboxNode]
+	event shardEvent
+}
+
+// mailbox is an unbounded lock-free multi-producer, single-consumer queue.
+// Any goroutine may push; only the owning shard pops. ready is signalled after
+// every push so the shard knows to drain.
+type mailbox struct {
+	head  atomic.Pointer[mailboxNode]
+	tail  *mailboxNode
+	ready chan struct{}
+}
+
+func newMailbox() *mailbox {
+	stub := &mailboxNode{}
+	m := &mailbox{tail: stub, ready: make(chan struct{}, 1)}
+	m.head.Store(stub)
+	return m
+}
+
+func (m *mailbox) push(event shardEvent) {
+	node := &mailboxNode{event: event}
+	prev := m.head.Swap(node)
+	prev.next.Store(node)
+
+	select {
+	case m.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the oldest event. A push that is still linking its node is not
+// visible yet, but it will signal ready once it is.
+func (m *mailbox) pop() (shardEvent, bool) {
+	next := m.tail.next.Load()
+	if next == nil {
+		return shardEvent{}, false
+	}
+	m.tail = next
+	event := next.event
+	next.event = shardEvent{}
+	return event, true
+}
@@ -0,0 +1,212 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/service"
+	"github.com/rs/zerolog"
+)
+
+type PersisterConfig struct {
+	Workers       int
+	BatchSize     int
+	FlushInterval time.Duration
+	// MaxRetries is how many times a failed batch is retried, waiting
+	// RetryBackoff before the first retry and twice as long before each
+	// following one.
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+// Persister writes messages to the database off the hub loop. Each
+// conversation is pinned to one worker, so messages between the same two
+// users are inserted and reported back in the order they were enqueued.
+type Persister struct {
+	workers []*persistWorker
+	wg      sync.WaitGroup
+}
+
+type persistJob struct {
+	message     *models.Message
+	deliveredID int64
+}
+
+type persistWorker struct {
+	jobs           chan persistJob
+	messageService service.MessageService
+	batchSize      int
+	flushInterval  time.Duration
+	maxRetries     int
+	retryBackoff   time.Duration
+	onPersisted    func([]*models.Message)
+	logger         *zerolog.Logger
+}
+
+func NewPersister(
+	messageService service.MessageService,
+	cfg PersisterConfig,
+	onPersisted func([]*models.Message),
+	logger *zerolog.Logger,
+) *Persister {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 20 * time.Millisecond
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 50 * time.Millisecond
+	}
+
+	p := &Persister{}
+	for i := 0; i < cfg.Workers; i++ {
+		w := &persistWorker{
+			jobs:           make(chan persistJob, cfg.BatchSize*4),
+			messageService: messageService,
+			batchSize:      cfg.BatchSize,
+			flushInterval:  cfg.FlushInterval,
+			maxRetries:     cfg.MaxRetries,
+			retryBackoff:   cfg.RetryBackoff,
+			onPersisted:    onPersisted,
+			logger:         logger,
+		}
+		p.workers = append(p.workers, w)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			w.run()
+		}()
+	}
+	return p
+}
+
+// Enqueue schedules the message for insertion. onPersisted is called with
+// each flushed batch once its messages have been assigned an ID, or have
+// their status set to MessageRejected if they could not be saved.
+func (p *Persister) Enqueue(message *models.Message) {
+	p.workerFor(message).jobs <- persistJob{message: message}
+}
+
+// MarkDelivered schedules a status update for a message that reached its
+// receiver. It is routed through the conversation's worker so it can never
+// overtake the insert.
+func (p *Persister) MarkDelivered(message *models.Message) {
+	p.workerFor(message).jobs <- persistJob{deliveredID: message.ID}
+}
+
+// Close flushes all pending work and waits for the workers to exit. The
+// persister must not be used afterwards.
+func (p *Persister) Close() {
+	for _, w := range p.workers {
+		close(w.jobs)
+	}
+	p.wg.Wait()
+}
+
+func (p *Persister) workerFor(message *models.Message) *persistWorker {
+	low, high := message.SenderID, message.ReceiverID
+	if low > high {
+		low, high = high, low
+	}
+	key := uint64(low)*31 + uint64(high)
+	return p.workers[key%uint64(len(p.workers))]
+}
+
+func (w *persistWorker) run() {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	var pending []*models.Message
+	var delivered []int64
+
+	flush := func() {
+		if len(pending) > 0 {
+			w.flushMessages(pending)
+			pending = nil
+		}
+		if len(delivered) > 0 {
+			w.flushDelivered(delivered)
+			delivered = nil
+		}
+	}
+
+	for {
+		select {
+		case job, ok := <-w.jobs:
+			if !ok {
+				flush()
+				return
+			}
+			if job.message != nil {
+				pending = append(pending, job.message)
+			} else {
+				delivered = append(delivered, job.deliveredID)
+			}
+			if len(pending) >= w.batchSize || len(delivered) >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// flushMessages saves the batch, retrying with backoff. If it still fails,
+// each message is saved on its own so that one bad row does not take the rest
+// down with it; messages that cannot be saved are reported as rejected.
+func (w *persistWorker) flushMessages(messages []*models.Message) {
+	err := w.saveMessages(messages)
+	for attempt, backoff := 0, w.retryBackoff; err != nil && attempt < w.maxRetries; attempt++ {
+		w.logger.Warn().Err(err).Int("batch_size", len(messages)).Int("attempt", attempt+1).Msg("Retrying message batch")
+		time.Sleep(backoff)
+		backoff *= 2
+		err = w.saveMessages(messages)
+	}
+
+	if err != nil && len(messages) > 1 {
+		w.logger.Error().Err(err).Int("batch_size", len(messages)).Msg("Failed to save message batch, saving messages one by one")
+		for _, msg := range messages {
+			if err := w.saveMessages([]*models.Message{msg}); err != nil {
+				w.reject(msg, err)
+			}
+		}
+	} else if err != nil {
+		w.reject(messages[0], err)
+	}
+
+	w.onPersisted(messages)
+}
+
+func (w *persistWorker) saveMessages(messages []*models.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return w.messageService.SendMessages(ctx, messages)
+}
+
+// reject marks a message that could not be saved, so its sender is told it
+// was not sent.
+func (w *persistWorker) reject(msg *models.Message, err error) {
+	w.logger.Error().Err(err).Int("sender_id", msg.SenderID).Int("receiver_id", msg.ReceiverID).Msg("Failed to save message")
+	msg.ID = 0
+	msg.Status = models.MessageRejected
+	msg.Dropped = false
+}
+
+func (w *persistWorker) flushDelivered(ids []int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := w.messageService.MarkMessagesAsDeliveredByIDs(ctx, ids); err != nil {
+		w.logger.Error().Err(err).Int("batch_size", len(ids)).Msg("Failed to mark messages as delivered")
+	}
+}
@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type persistedLog struct {
+	mu       sync.Mutex
+	messages []*models.Message
+}
+
+func (l *persistedLog) add(msgs []*models.Message) {
+	l.mu.Lock()
+	l.messages = append(l.messages, msgs...)
+	l.mu.Unlock()
+}
+
+func (l *persistedLog) all() []*models.Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*models.Message(nil), l.messages...)
+}
+
+func runPersister(t *testing.T, service *memoryMessageService, cfg PersisterConfig, messages []*models.Message) []*models.Message {
+	t.Helper()
+	logger := zerolog.Nop()
+	var log persistedLog
+	p := NewPersister(service, cfg, log.add, &logger)
+	for _, msg := range messages {
+		p.Enqueue(msg)
+	}
+	p.Close()
+	return log.all()
+}
+
+func conversationMessages(n int, content func(i int) string) []*models.Message {
+	messages := make([]*models.Message, n)
+	for i := range messages {
+		messages[i] = &models.Message{SenderID: 1, ReceiverID: 2, Content: content(i)}
+	}
+	return messages
+}
+
+func TestPersisterKeepsConversationOrder(t *testing.T) {
+	service := &memoryMessageService{}
+	messages := conversationMessages(25, func(int) string { return "hi" })
+
+	persisted := runPersister(t, service, PersisterConfig{Workers: 4, BatchSize: 10, FlushInterval: time.Hour}, messages)
+
+	if len(persisted) != len(messages) {
+		t.Fatalf("persisted %d messages, want %d", len(persisted), len(messages))
+	}
+	for i, msg := range persisted {
+		if msg != messages[i] {
+			t.Fatalf("message %d persisted out of order", i)
+		}
+		if i > 0 && msg.ID <= persisted[i-1].ID {
+			t.Fatalf("message %d has ID %d after %d", i, msg.ID, persisted[i-1].ID)
+		}
+	}
+	for _, size := range service.batchSizes() {
+		if size > 10 {
+			t.Fatalf("batch of %d messages exceeds the batch size", size)
+		}
+	}
+}
+
+func TestPersisterRetriesFailedBatch(t *testing.T) {
+	failures := 2
+	service := &memoryMessageService{failBatch: func([]*models.Message) error {
+		if failures > 0 {
+			failures--
+			return errors.New("connection reset")
+		}
+		return nil
+	}}
+	messages := conversationMessages(5, func(int) string { return "hi" })
+
+	persisted := runPersister(t, service, PersisterConfig{BatchSize: 5, MaxRetries: 3, RetryBackoff: time.Millisecond}, messages)
+
+	if got := service.batchSizes(); len(got) != 3 {
+		t.Fatalf("SendMessages called with batches %v, want 3 attempts", got)
+	}
+	for _, msg := range persisted {
+		if msg.ID == 0 || msg.Status != "sent" {
+			t.Fatalf("message = {ID: %d, Status: %q}, want it saved", msg.ID, msg.Status)
+		}
+	}
+}
+
+func TestPersisterFallsBackToSingleRows(t *testing.T) {
+	service := &memoryMessageService{failBatch: func(msgs []*models.Message) error {
+		for _, msg := range msgs {
+			if msg.Content == "bad" {
+				return errors.New("data too long")
+			}
+		}
+		return nil
+	}}
+	messages := conversationMessages(4, func(i int) string {
+		if i == 2 {
+			return "bad"
+		}
+		return "hi"
+	})
+
+	persisted := runPersister(t, service, PersisterConfig{BatchSize: 4, MaxRetries: 1, RetryBackoff: time.Millisecond}, messages)
+
+	if len(persisted) != len(messages) {
+		t.Fatalf("persisted %d messages, want %d", len(persisted), len(messages))
+	}
+	for i, msg := range persisted {
+		if msg != messages[i] {
+			t.Fatalf("message %d reported out of order", i)
+		}
+		switch {
+		case msg.Content == "bad" && (msg.Status != models.MessageRejected || msg.ID != 0):
+			t.Errorf("bad message = {ID: %d, Status: %q}, want rejected without ID", msg.ID, msg.Status)
+		case msg.Content != "bad" && (msg.Status != "sent" || msg.ID == 0):
+			t.Errorf("message %d = {ID: %d, Status: %q}, want it saved", i, msg.ID, msg.Status)
+		}
+	}
+}
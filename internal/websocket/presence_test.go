@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+)
+
+// recordingStatusService keeps the connection-driven status of each user.
+type recordingStatusService struct {
+	memoryStatusService
+
+	mu       sync.Mutex
+	statuses map[int]string
+	// invisible users chose to appear offline.
+	invisible map[int]bool
+}
+
+func (s *recordingStatusService) UpdateUserStatus(ctx context.Context, userID int, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[userID] = status
+	return nil
+}
+
+func (s *recordingStatusService) GetUserStatus(ctx context.Context, userID int) (*models.UserStatus, error) {
+	status := &models.UserStatus{UserID: userID, Status: s.status(userID), LastSeen: time.Now()}
+	if s.invisible[userID] {
+		status.ManualStatus = models.StatusInvisible
+		if status.Status != models.StatusOffline {
+			status.Status = models.StatusInvisible
+		}
+	}
+	return status, nil
+}
+
+func (s *recordingStatusService) status(userID int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status, ok := s.statuses[userID]; ok {
+		return status
+	}
+	return models.StatusOnline
+}
+
+// clusterPresence reports a cluster-wide last input per user and records
+// the sessions each heartbeat marked as having input.
+type clusterPresence struct {
+	memoryPresenceService
+
+	mu         sync.Mutex
+	lastInput  map[int]time.Time
+	heartbeats int
+	inputIDs   []string
+}
+
+func (p *clusterPresence) Heartbeat(ctx context.Context, sessionIDs, inputIDs []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.heartbeats++
+	p.inputIDs = append(p.inputIDs, inputIDs...)
+	return nil
+}
+
+func (p *clusterPresence) LastInput(ctx context.Context, userID int) (time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastInput[userID], nil
+}
+
+func (p *clusterPresence) setLastInput(userID int, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastInput[userID] = at
+}
+
+func newPresenceTestHub(t *testing.T) (*Hub, *recordingStatusService, *clusterPresence) {
+	t.Helper()
+	statuses := &recordingStatusService{statuses: make(map[int]string)}
+	presence := &clusterPresence{lastInput: make(map[int]time.Time)}
+	hub := startHub(t, hubDeps{
+		statuses: statuses,
+		presence: presence,
+		cfg: HubConfig{
+			// Only the first heartbeat runs on its own; the tests drive the rest.
+			HeartbeatInterval: time.Hour,
+			AwayAfter:         time.Minute,
+		},
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		presence.mu.Lock()
+		started := presence.heartbeats > 0
+		presence.mu.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("presence loop did not heartbeat")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return hub, statuses, presence
+}
+
+func idleFor(client *Client, d time.Duration) {
+	client.lastInput.Store(time.Now().Add(-d).UnixNano())
+}
+
+func TestIdleConnectionDoesNotMarkActiveUserAway(t *testing.T) {
+	hub, statuses, presence := newPresenceTestHub(t)
+	phone := connect(t, hub, 1)
+	laptop := connect(t, hub, 1)
+
+	idleFor(phone, time.Hour)
+	hub.detectIdle()
+	if got := statuses.status(1); got != models.StatusOnline {
+		t.Fatalf("status with an active laptop = %q, want online", got)
+	}
+
+	// Both local connections are idle, but the user typed on another node.
+	idleFor(laptop, time.Hour)
+	presence.setLastInput(1, time.Now())
+	hub.detectIdle()
+	if got := statuses.status(1); got != models.StatusOnline {
+		t.Fatalf("status with input on another node = %q, want online", got)
+	}
+
+	presence.setLastInput(1, time.Now().Add(-time.Hour))
+	hub.detectIdle()
+	if got := statuses.status(1); got != models.StatusAway {
+		t.Fatalf("status with every session idle = %q, want away", got)
+	}
+	if !phone.away.Load() || !laptop.away.Load() {
+		t.Fatal("idle connections were not marked away")
+	}
+}
+
+func TestInputOnAnyConnectionEndsAway(t *testing.T) {
+	hub, statuses, presence := newPresenceTestHub(t)
+	client := connect(t, hub, 1)
+	statuses.UpdateUserStatus(context.Background(), 1, models.StatusAway)
+
+	// The connection was never marked away here, as if another node had
+	// set the status, so only the heartbeat can bring the user back.
+	client.lastInput.Store(time.Now().Add(time.Second).UnixNano())
+	hub.heartbeat()
+	if got := statuses.status(1); got != models.StatusOnline {
+		t.Fatalf("status after input = %q, want online", got)
+	}
+
+	presence.mu.Lock()
+	defer presence.mu.Unlock()
+	if len(presence.inputIDs) != 1 || presence.inputIDs[0] != client.SessionID {
+		t.Fatalf("heartbeat recorded input on %v, want %q", presence.inputIDs, client.SessionID)
+	}
+}
+
+func TestInvisibleUserConnectsWithoutBroadcast(t *testing.T) {
+	statuses := &recordingStatusService{statuses: make(map[int]string), invisible: map[int]bool{1: true}}
+	hub := startHub(t, hubDeps{statuses: statuses})
+	watcher := connect(t, hub, 2)
+	if err := hub.SubscribePresence(context.Background(), 2, []int{1, 3}); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, watcher, 1, models.StatusOffline)
+	expectStatus(t, watcher, 3, models.StatusOffline)
+
+	// The invisible user already appears offline; any frame when they
+	// connect or leave would give them away.
+	invisible := connect(t, hub, 1)
+	expectNothing(t, watcher, "status_update")
+	hub.Unregister(invisible)
+	expectNothing(t, watcher, "status_update")
+
+	connect(t, hub, 3)
+	expectStatus(t, watcher, 3, models.StatusOnline)
+}
@@ -0,0 +1,403 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+)
+
+type shardEventKind int
+
+const (
+	eventDeliver shardEventKind = iota
+	eventStatus
+	eventSubscribe
+	eventUnsubscribe
+	eventCheckTokens
+	eventRevokeToken
+	eventHide
+	eventSetRole
+	eventClose
+)
+
+// maxPresenceSubscriptions caps how many users one connection may watch.
+const maxPresenceSubscriptions = 1000
+
+type shardEvent struct {
+	kind   shardEventKind
+	userID int
+	// client narrows an eventDeliver to one of the user's connections.
+	client         *Client
+	tokenID        string
+	sessionID      string
+	userIDs        []int
+	contacts       map[int]bool
+	message        *models.Message
+	status         *models.UserStatus
+	role           policy.Role
+	code           int
+	markDelivered  bool
+	privacyChanged bool
+}
+
+// shard owns a subset of the hub's clients and their presence
+// subscriptions. Only the shard goroutine touches its maps.
+type shard struct {
+	hub *Hub
+	// clients holds every connection of each local user; a user may be
+	// connected from several devices at once.
+	clients map[int]map[*Client]struct{}
+	// watchers maps a watched user to the local users subscribed to them and
+	// whether each subscriber is their contact; watching is the reverse
+	// index used to clean up on disconnect.
+	watchers   map[int]map[int]bool
+	watching   map[int]map[int]struct{}
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan *models.Message
+	mailbox    *mailbox
+}
+
+func newShard(hub *Hub) *shard {
+	return &shard{
+		hub:        hub,
+		clients:    make(map[int]map[*Client]struct{}),
+		watchers:   make(map[int]map[int]bool),
+		watching:   make(map[int]map[int]struct{}),
+		register:   make(chan *Client, 64),
+		unregister: make(chan *Client, 64),
+		broadcast:  make(chan *models.Message, 256),
+		mailbox:    newMailbox(),
+	}
+}
+
+func (s *shard) run() {
+	for {
+		select {
+		case client := <-s.register:
+			s.handleRegister(client)
+		case client := <-s.unregister:
+			s.handleUnregister(client)
+		case message := <-s.broadcast:
+			s.handleBroadcast(message)
+		case <-s.mailbox.ready:
+			s.drainMailbox()
+		case <-s.hub.ShutdownChan:
+			s.handleShutdown()
+			return
+		}
+	}
+}
+
+func (s *shard) handleRegister(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sessionID, err := s.hub.PresenceService.Connect(ctx, client.UserID)
+	if err != nil {
+		s.hub.Logger.Error().Err(err).Int("user_id", client.UserID).Msg("Failed to open presence session")
+	} else {
+		client.SessionID = sessionID
+		s.hub.sessions.Store(sessionID, client)
+	}
+
+	if s.clients[client.UserID] == nil {
+		s.clients[client.UserID] = make(map[*Client]struct{})
+	}
+	s.clients[client.UserID][client] = struct{}{}
+	s.hub.broadcastStatus(ctx, client.UserID)
+	s.subscribeConversationPartners(ctx, client)
+	s.sendPendingMessages(client)
+}
+
+// subscribeConversationPartners watches the users the client has recently
+// talked to, so presence works without an explicit subscription.
+func (s *shard) subscribeConversationPartners(ctx context.Context, client *Client) {
+	partners, err := s.hub.MessageService.GetConversationPartners(ctx, client.UserID, maxPresenceSubscriptions)
+	if err != nil {
+		s.hub.Logger.Error().Err(err).Int("user_id", client.UserID).Msg("Failed to load conversation partners")
+		return
+	}
+	if len(partners) == 0 {
+		return
+	}
+
+	if err := s.hub.SubscribePresence(ctx, client.UserID, partners); err != nil {
+		s.hub.Logger.Error().Err(err).Int("user_id", client.UserID).Msg("Failed to subscribe to partner presence")
+	}
+}
+
+func (s *shard) handleUnregister(client *Client) {
+	if _, ok := s.clients[client.UserID][client]; ok {
+		s.dropClient(client)
+	}
+	if client.SessionID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.hub.sessions.Delete(client.SessionID)
+	offline, err := s.hub.PresenceService.Disconnect(ctx, client.SessionID, client.UserID)
+	client.SessionID = ""
+	if err != nil {
+		s.hub.Logger.Error().Err(err).Int("user_id", client.UserID).Msg("Failed to close presence session")
+		return
+	}
+	if offline {
+		s.hub.broadcastStatus(ctx, client.UserID)
+	}
+}
+
+func (s *shard) handleBroadcast(message *models.Message) {
+	if message.SenderID <= 0 || message.ReceiverID <= 0 {
+		s.hub.Logger.Warn().Int("receiver_id", message.ReceiverID).Msg("Dropping message with invalid user ID")
+		return
+	}
+
+	s.hub.persister.Enqueue(message)
+}
+
+func (s *shard) drainMailbox() {
+	for {
+		event, ok := s.mailbox.pop()
+		if !ok {
+			return
+		}
+
+		switch event.kind {
+		case eventDeliver:
+			if event.client != nil {
+				if _, ok := s.clients[event.userID][event.client]; ok {
+					s.send(event.client, event.message)
+				}
+				continue
+			}
+			if s.deliver(event.userID, event.message) && event.markDelivered {
+				s.hub.persister.MarkDelivered(event.message)
+			}
+		case eventStatus:
+			s.deliverStatus(event.status, event.privacyChanged)
+		case eventSubscribe:
+			s.subscribe(event.userID, event.userIDs, event.contacts)
+		case eventUnsubscribe:
+			s.unsubscribe(event.userID, event.userIDs)
+		case eventCheckTokens:
+			s.checkTokens()
+		case eventRevokeToken:
+			s.revokeToken(event.tokenID, event.sessionID)
+		case eventHide:
+			s.hide(event.userID, event.userIDs)
+		case eventSetRole:
+			for client := range s.clients[event.userID] {
+				client.setRole(event.role)
+			}
+		case eventClose:
+			if _, ok := s.clients[event.userID][event.client]; ok {
+				s.closeClient(event.client, event.code)
+			}
+		}
+	}
+}
+
+// deliverStatus renders the status once for contacts and once for everyone
+// else. Subscribers who may not see the user's presence are skipped, unless
+// the privacy settings just changed and they need to learn it is hidden.
+func (s *shard) deliverStatus(status *models.UserStatus, privacyChanged bool) {
+	views := make(map[bool]*models.Message, 2)
+	for subscriberID, isContact := range s.watchers[status.UserID] {
+		if !privacyChanged && !status.OnlineVisibleTo(isContact) {
+			continue
+		}
+		view, ok := views[isContact]
+		if !ok {
+			view = statusMessage(status.ViewFor(isContact))
+			views[isContact] = view
+		}
+		s.deliver(subscriberID, view)
+	}
+}
+
+// deliver queues the message on every connection of the user. It reports
+// whether any of them took it.
+func (s *shard) deliver(userID int, message *models.Message) bool {
+	queued := false
+	for client := range s.clients[userID] {
+		if s.send(client, message) {
+			queued = true
+		}
+	}
+	return queued
+}
+
+// send queues the message on one connection, dropping it if its send buffer
+// is full. It reports whether the message was queued.
+func (s *shard) send(client *Client, message *models.Message) bool {
+	select {
+	case client.Send <- message:
+		return true
+	default:
+		s.dropClient(client)
+		return false
+	}
+}
+
+// forEachClient calls fn for every local connection. fn may drop the client.
+func (s *shard) forEachClient(fn func(client *Client)) {
+	for _, clients := range s.clients {
+		for client := range clients {
+			fn(client)
+		}
+	}
+}
+
+// checkTokens closes connections whose token has expired and asks those
+// about to expire for a fresh one.
+func (s *shard) checkTokens() {
+	now := time.Now()
+	window := 2 * s.hub.cfg.HeartbeatInterval
+	s.forEachClient(func(client *Client) {
+		switch {
+		case client.tokenExpired(now):
+			s.closeClient(client, CloseTokenExpired)
+		case client.tokenExpiresWithin(now, window) && client.reauthRequested.CompareAndSwap(false, true):
+			s.send(client, &models.Message{
+				Type:      "reauth_required",
+				Timestamp: now,
+			})
+		}
+	})
+}
+
+// revokeToken closes the connections opened with the token or in the
+// session. Either ID may be empty.
+func (s *shard) revokeToken(tokenID, sessionID string) {
+	s.forEachClient(func(client *Client) {
+		if client.hasTokenID(tokenID) || client.inSession(sessionID) {
+			s.closeClient(client, CloseTokenRevoked)
+		}
+	})
+}
+
+// closeClient drops the client and makes its write pump send the close code.
+func (s *shard) closeClient(client *Client, code int) {
+	s.hub.Logger.Info().Int("user_id", client.UserID).Int("code", code).Msg("Closing connection")
+	client.closeCode.Store(int32(code))
+	s.dropClient(client)
+}
+
+// dropClient stops writing to the client. The user's subscriptions are
+// forgotten with their last connection. The client's session stays open
+// until the read pump unregisters it.
+func (s *shard) dropClient(client *Client) {
+	close(client.Send)
+	clients := s.clients[client.UserID]
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(s.clients, client.UserID)
+		s.unsubscribeAll(client.UserID)
+	}
+}
+
+func (s *shard) subscribe(subscriberID int, userIDs []int, contacts map[int]bool) {
+	if _, ok := s.clients[subscriberID]; !ok {
+		return
+	}
+
+	targets := s.watching[subscriberID]
+	if targets == nil {
+		targets = make(map[int]struct{})
+		s.watching[subscriberID] = targets
+	}
+	for _, userID := range userIDs {
+		if len(targets) >= maxPresenceSubscriptions {
+			return
+		}
+		if userID <= 0 || userID == subscriberID {
+			continue
+		}
+		targets[userID] = struct{}{}
+		if s.watchers[userID] == nil {
+			s.watchers[userID] = make(map[int]bool)
+		}
+		s.watchers[userID][subscriberID] = contacts[userID]
+	}
+}
+
+func (s *shard) unsubscribe(subscriberID int, userIDs []int) {
+	targets := s.watching[subscriberID]
+	for _, userID := range userIDs {
+		delete(targets, userID)
+		s.removeWatcher(userID, subscriberID)
+	}
+}
+
+// hide unsubscribes the subscriber from the users and sends them each user's
+// hidden view, so they do not keep a stale status.
+func (s *shard) hide(subscriberID int, userIDs []int) {
+	targets := s.watching[subscriberID]
+	for _, userID := range userIDs {
+		if _, ok := targets[userID]; !ok {
+			continue
+		}
+		delete(targets, userID)
+		s.removeWatcher(userID, subscriberID)
+		s.deliver(subscriberID, statusMessage(&models.UserStatus{UserID: userID, Status: models.StatusOffline}))
+	}
+}
+
+func (s *shard) unsubscribeAll(subscriberID int) {
+	for userID := range s.watching[subscriberID] {
+		s.removeWatcher(userID, subscriberID)
+	}
+	delete(s.watching, subscriberID)
+}
+
+func (s *shard) removeWatcher(userID, subscriberID int) {
+	if subscribers, ok := s.watchers[userID]; ok {
+		delete(subscribers, subscriberID)
+		if len(subscribers) == 0 {
+			delete(s.watchers, userID)
+		}
+	}
+}
+
+func (s *shard) sendPendingMessages(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	messages, err := s.hub.MessageService.GetUndeliveredMessages(ctx, client.UserID)
+	if err != nil {
+		s.hub.Logger.Error().Err(err).Int("user_id", client.UserID).Msg("Failed to fetch pending messages")
+		return
+	}
+	if len(messages) > 0 {
+		s.hub.applyAlerts(messages)
+	}
+
+	for _, msg := range messages {
+		if !s.send(client, msg) {
+			return
+		}
+		s.hub.persister.MarkDelivered(msg)
+	}
+}
+
+func (s *shard) handleShutdown() {
+	s.forEachClient(func(client *Client) {
+		close(client.Send)
+		if client.Conn == nil {
+			return
+		}
+		shutdownMsg := &models.Message{
+			Type:      "system",
+			Content:   "Server is shutting down",
+			Timestamp: time.Now(),
+		}
+		client.Conn.WriteJSON(shutdownMsg)
+		client.Conn.Close()
+	})
+}
@@ -0,0 +1,294 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chatapp/internal/models"
+	"github.com/chatapp/internal/policy"
+	"github.com/chatapp/internal/service"
+)
+
+// contactStatusService reports the given pairs as contacts.
+type contactStatusService struct {
+	memoryStatusService
+	contacts map[[2]int]bool
+}
+
+func (s contactStatusService) ContactsAmong(ctx context.Context, viewerID int, userIDs []int) (map[int]bool, error) {
+	contacts := make(map[int]bool)
+	for _, userID := range userIDs {
+		if s.contacts[[2]int{viewerID, userID}] {
+			contacts[userID] = true
+		}
+	}
+	return contacts, nil
+}
+
+// expectStatus waits for the status of userID to reach the client.
+func expectStatus(t *testing.T, client *Client, userID int, status string) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg, ok := <-client.Send:
+			if !ok {
+				t.Fatalf("connection of user %d closed while waiting for a status", client.UserID)
+			}
+			if msg.Type == "status_update" && msg.SenderID == userID && msg.Status == status {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("user %d did not see user %d %s", client.UserID, userID, status)
+		}
+	}
+}
+
+// expectNoStatus fails if the status of userID reaches the client soon.
+func expectNoStatus(t *testing.T, client *Client, userID int, status string) {
+	t.Helper()
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case msg, ok := <-client.Send:
+			if !ok {
+				return
+			}
+			if msg.Type == "status_update" && msg.SenderID == userID && msg.Status == status {
+				t.Fatalf("user %d saw user %d %s", client.UserID, userID, status)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestStatusReachesOnlySubscribers(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	watcher := connect(t, hub, 2)
+	stranger := connect(t, hub, 3)
+
+	if err := hub.SubscribePresence(context.Background(), 2, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, watcher, 1, models.StatusOffline)
+
+	hub.notifyStatusChange(&models.UserStatus{UserID: 1, Status: models.StatusOnline})
+	expectStatus(t, watcher, 1, models.StatusOnline)
+	expectNoStatus(t, stranger, 1, models.StatusOnline)
+
+	hub.UnsubscribePresence(2, []int{1})
+	hub.notifyStatusChange(&models.UserStatus{UserID: 1, Status: models.StatusAway})
+	expectNoStatus(t, watcher, 1, models.StatusAway)
+}
+
+func TestStatusRespectsContactsOnlyPrivacy(t *testing.T) {
+	hub := startHub(t, hubDeps{
+		statuses: contactStatusService{contacts: map[[2]int]bool{{2, 1}: true}},
+		cfg:      HubConfig{Shards: 4},
+	})
+	contact := connect(t, hub, 2)
+	stranger := connect(t, hub, 3)
+	for _, subscriberID := range []int{2, 3} {
+		if err := hub.SubscribePresence(context.Background(), subscriberID, []int{1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hub.notifyStatusChange(&models.UserStatus{
+		UserID:  1,
+		Status:  models.StatusOnline,
+		Privacy: &models.PrivacySettings{OnlineStatus: models.VisibilityContacts, LastSeen: models.VisibilityContacts},
+	})
+	expectStatus(t, contact, 1, models.StatusOnline)
+	expectNoStatus(t, stranger, 1, models.StatusOnline)
+}
+
+func TestBlockStopsPresenceUpdates(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	watcher := connect(t, hub, 2)
+	if err := hub.SubscribePresence(context.Background(), 2, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	hub.notifyStatusChange(&models.UserStatus{UserID: 1, Status: models.StatusOnline})
+	expectStatus(t, watcher, 1, models.StatusOnline)
+
+	// The watcher is left with the hidden view and hears nothing more.
+	hub.Block(1, 2)
+	expectStatus(t, watcher, 1, models.StatusOffline)
+	hub.notifyStatusChange(&models.UserStatus{UserID: 1, Status: models.StatusAway})
+	expectNoStatus(t, watcher, 1, models.StatusAway)
+}
+
+// expectClosed waits for the hub to close the connection with code.
+func expectClosed(t *testing.T, client *Client, code int) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-client.Send:
+			if !ok {
+				if got := int(client.closeCode.Load()); got != code {
+					t.Fatalf("close code = %d, want %d", got, code)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("connection of user %d was not closed", client.UserID)
+		}
+	}
+}
+
+// expectNothing fails if a frame of the given type reaches the client soon.
+func expectNothing(t *testing.T, client *Client, msgType string) {
+	t.Helper()
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case msg, ok := <-client.Send:
+			if !ok {
+				t.Fatalf("connection of user %d closed", client.UserID)
+			}
+			if msg.Type == msgType {
+				t.Fatalf("user %d received %q", client.UserID, msgType)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestDeliveryReachesEveryConnection(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	phone := connect(t, hub, 1)
+	laptop := connect(t, hub, 1)
+
+	hub.sendToUser(1, &models.Message{Type: "message", Content: "hi"})
+	expect(t, phone, "message")
+	expect(t, laptop, "message")
+
+	// Replies to a frame go to the connection that sent it.
+	phone.sendError("bad frame")
+	expect(t, phone, "error")
+	expectNothing(t, laptop, "error")
+}
+
+func TestCheckTokensHandlesEachConnection(t *testing.T) {
+	hub := startHub(t, hubDeps{cfg: HubConfig{Shards: 4, HeartbeatInterval: time.Minute}})
+	older := connect(t, hub, 1)
+	expiring := connect(t, hub, 1)
+	newer := connect(t, hub, 1)
+	older.SetToken(&service.TokenInfo{UserID: 1, ID: "old", ExpiresAt: time.Now().Add(-time.Second)})
+	expiring.SetToken(&service.TokenInfo{UserID: 1, ID: "soon", ExpiresAt: time.Now().Add(time.Minute)})
+	newer.SetToken(&service.TokenInfo{UserID: 1, ID: "new", ExpiresAt: time.Now().Add(time.Hour)})
+
+	hub.checkTokens()
+	expectClosed(t, older, CloseTokenExpired)
+	expect(t, expiring, "reauth_required")
+	expectNothing(t, newer, "reauth_required")
+
+	hub.sendToUser(1, &models.Message{Type: "message"})
+	expect(t, expiring, "message")
+	expect(t, newer, "message")
+}
+
+func TestRevokeTokenClosesOnlyThatConnection(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	older := connect(t, hub, 1)
+	newer := connect(t, hub, 1)
+	older.SetToken(&service.TokenInfo{UserID: 1, ID: "old", ExpiresAt: time.Now().Add(time.Hour)})
+	newer.SetToken(&service.TokenInfo{UserID: 1, ID: "new", ExpiresAt: time.Now().Add(time.Hour)})
+
+	hub.RevokeToken("old")
+	expectClosed(t, older, CloseTokenRevoked)
+
+	hub.sendToUser(1, &models.Message{Type: "message"})
+	expect(t, newer, "message")
+}
+
+func TestRevokeSessionClosesOlderConnection(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	older := connect(t, hub, 1)
+	newer := connect(t, hub, 1)
+	older.SetToken(&service.TokenInfo{UserID: 1, ID: "a", SessionID: "phone", ExpiresAt: time.Now().Add(time.Hour)})
+	newer.SetToken(&service.TokenInfo{UserID: 1, ID: "b", SessionID: "laptop", ExpiresAt: time.Now().Add(time.Hour)})
+
+	hub.RevokeSession("phone")
+	expectClosed(t, older, CloseTokenRevoked)
+
+	hub.sendToUser(1, &models.Message{Type: "message"})
+	expect(t, newer, "message")
+}
+
+func TestUpdateRoleReachesEveryConnection(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	phone := connect(t, hub, 1)
+	laptop := connect(t, hub, 1)
+	other := connect(t, hub, 2)
+	for _, client := range []*Client{phone, laptop, other} {
+		client.SetToken(&service.TokenInfo{UserID: client.UserID, ID: "jti", Role: policy.RoleAdmin, ExpiresAt: time.Now().Add(time.Hour)})
+	}
+
+	hub.UpdateRole(1, policy.RoleUser)
+	deadline := time.Now().Add(time.Second)
+	for phone.token.Load().Role != policy.RoleUser || laptop.token.Load().Role != policy.RoleUser {
+		if time.Now().After(deadline) {
+			t.Fatal("connections kept the old role")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if other.token.Load().Role != policy.RoleAdmin {
+		t.Fatal("another user's role changed")
+	}
+
+	// The token is otherwise unchanged, so it still expires on time.
+	if token := phone.token.Load(); token.ID != "jti" || token.ExpiresAt.IsZero() {
+		t.Fatalf("token = %+v", token)
+	}
+}
+
+func TestAuthorizeFrameUsesCurrentRole(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	client := connect(t, hub, 1)
+	client.SetToken(&service.TokenInfo{UserID: 1, ID: "jti", Role: policy.RoleUser, ExpiresAt: time.Now().Add(time.Hour)})
+	if !client.authorizeFrame("set_status") {
+		t.Fatal("user may not set their status")
+	}
+
+	// A role without the permission is refused from the next frame on.
+	client.setRole(policy.Role("suspended"))
+	if client.authorizeFrame("set_status") {
+		t.Fatal("frame authorized with the old role")
+	}
+	expect(t, client, "error")
+}
+
+func TestClientCannotForgeServerEvents(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	sender := connect(t, hub, 1)
+	receiver := connect(t, hub, 2)
+
+	for _, forged := range []string{"mentioned", "profile_update", "system", "ack", "reauth_required", "status_update"} {
+		sender.handleChat(&models.Message{Type: forged, ReceiverID: 2, Content: forged})
+		if msg := expect(t, receiver, ""); msg.Content != forged || msg.SenderID != 1 {
+			t.Fatalf("relayed frame = %+v, want a chat message from user 1", msg)
+		}
+		expectNothing(t, receiver, forged)
+	}
+}
+
+func TestAuthorizeFrameClosesExpiredToken(t *testing.T) {
+	hub := newTestHub(t, 4, &memoryMessageService{})
+	client := connect(t, hub, 1)
+	client.SetToken(&service.TokenInfo{UserID: 1, ID: "jti", Role: policy.RoleUser, ExpiresAt: time.Now().Add(-time.Second)})
+
+	// Even frames that need no permission are refused once the token lapsed,
+	// without waiting for the next token sweep.
+	for _, msgType := range []string{"", "activity"} {
+		if client.authorizeFrame(msgType) {
+			t.Fatalf("frame %q authorized with an expired token", msgType)
+		}
+	}
+	expectClosed(t, client, CloseTokenExpired)
+}
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS presence_nodes (
+    node_id        VARCHAR(64) PRIMARY KEY,
+    last_heartbeat DATETIME    NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+    session_id     VARCHAR(64) PRIMARY KEY,
+    user_id        INT         NOT NULL,
+    node_id        VARCHAR(64) NOT NULL,
+    connected_at   DATETIME    NOT NULL,
+    last_heartbeat DATETIME    NOT NULL,
+    INDEX idx_user_sessions_user (user_id),
+    INDEX idx_user_sessions_node (node_id),
+    INDEX idx_user_sessions_heartbeat (last_heartbeat)
+);
@@ -0,0 +1,10 @@
+ALTER TABLE user_status
+    ADD COLUMN manual_status     VARCHAR(16)  NULL,
+    ADD COLUMN status_text       VARCHAR(255) NULL,
+    ADD COLUMN status_emoji      VARCHAR(64)  NULL,
+    ADD COLUMN status_expires_at DATETIME     NULL;
+
+-- Last user input seen on each connection. A user is only marked away once
+-- every one of their connections, on any node, has been idle.
+ALTER TABLE user_sessions
+    ADD COLUMN last_input DATETIME NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE user_status
+    ADD COLUMN online_visibility    VARCHAR(16) NULL,
+    ADD COLUMN last_seen_visibility VARCHAR(16) NULL;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS status_events (
+    id          BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id     INT         NOT NULL,
+    status      VARCHAR(16) NOT NULL,
+    occurred_at DATETIME(3) NOT NULL,
+    INDEX idx_status_events_user_time (user_id, occurred_at),
+    INDEX idx_status_events_time (occurred_at)
+);
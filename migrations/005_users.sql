@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS users (
+    id            INT AUTO_INCREMENT PRIMARY KEY,
+    username      VARCHAR(32)  NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    failed_logins INT          NOT NULL DEFAULT 0,
+    locked_until  DATETIME(3)  NULL,
+    created_at    DATETIME(3)  NOT NULL,
+    UNIQUE KEY uq_users_username (username)
+);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash CHAR(64)    PRIMARY KEY,
+    family_id  CHAR(32)    NOT NULL,
+    user_id    INT         NOT NULL,
+    expires_at DATETIME(3) NOT NULL,
+    used_at    DATETIME(3) NULL,
+    revoked_at DATETIME(3) NULL,
+    created_at DATETIME(3) NOT NULL,
+    INDEX idx_refresh_tokens_family (family_id),
+    INDEX idx_refresh_tokens_expires (expires_at)
+);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti        CHAR(32)    PRIMARY KEY,
+    expires_at DATETIME(3) NOT NULL,
+    INDEX idx_revoked_tokens_expires (expires_at)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS ws_tickets (
+    ticket_hash      CHAR(64)    PRIMARY KEY,
+    user_id          INT         NOT NULL,
+    token_id         CHAR(32)    NOT NULL,
+    token_expires_at DATETIME(3) NOT NULL,
+    expires_at       DATETIME(3) NOT NULL,
+    INDEX idx_ws_tickets_expires (expires_at)
+);
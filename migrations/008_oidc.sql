@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS oidc_states (
+    state_hash    CHAR(64)     PRIMARY KEY,
+    nonce         VARCHAR(64)  NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    -- Hash of the value kept in the browser's login cookie. The callback must
+    -- present it, so a state cannot be completed in another browser.
+    binding_hash  CHAR(64)     NOT NULL DEFAULT '',
+    expires_at    DATETIME(3)  NOT NULL,
+    INDEX idx_oidc_states_expires (expires_at)
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer     VARCHAR(255) NOT NULL,
+    subject    VARCHAR(255) NOT NULL,
+    user_id    INT          NOT NULL,
+    created_at DATETIME(3)  NOT NULL,
+    PRIMARY KEY (issuer, subject),
+    INDEX idx_user_identities_user (user_id)
+);
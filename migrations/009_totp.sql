@@ -0,0 +1,19 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret    VARCHAR(64) NULL,
+    ADD COLUMN totp_enabled   BOOLEAN     NOT NULL DEFAULT FALSE,
+    ADD COLUMN totp_last_step BIGINT      NULL;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id        BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id   INT         NOT NULL,
+    code_hash CHAR(64)    NOT NULL,
+    used_at   DATETIME(3) NULL,
+    UNIQUE KEY uq_recovery_codes_user_hash (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    challenge_hash CHAR(64)    PRIMARY KEY,
+    user_id        INT         NOT NULL,
+    expires_at     DATETIME(3) NOT NULL,
+    INDEX idx_mfa_challenges_expires (expires_at)
+);
@@ -0,0 +1,19 @@
+ALTER TABLE users
+    ADD COLUMN is_service_account BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN owner_id           INT     NULL,
+    ADD INDEX idx_users_owner (owner_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id      INT          NOT NULL,
+    name         VARCHAR(64)  NOT NULL,
+    prefix       CHAR(12)     NOT NULL,
+    key_hash     CHAR(64)     NOT NULL,
+    scopes       VARCHAR(255) NOT NULL,
+    created_at   DATETIME(3)  NOT NULL,
+    expires_at   DATETIME(3)  NULL,
+    last_used_at DATETIME(3)  NULL,
+    revoked_at   DATETIME(3)  NULL,
+    UNIQUE KEY uq_api_keys_hash (key_hash),
+    INDEX idx_api_keys_user (user_id)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS auth_sessions (
+    id             CHAR(32)     PRIMARY KEY,
+    user_id        INT          NOT NULL,
+    device_name    VARCHAR(128) NOT NULL,
+    ip             VARCHAR(45)  NOT NULL,
+    user_agent     VARCHAR(255) NOT NULL,
+    created_at     DATETIME(3)  NOT NULL,
+    last_active_at DATETIME(3)  NOT NULL,
+    expires_at     DATETIME(3)  NOT NULL,
+    revoked_at     DATETIME(3)  NULL,
+    INDEX idx_auth_sessions_user (user_id),
+    INDEX idx_auth_sessions_expires (expires_at)
+);
+
+ALTER TABLE ws_tickets
+    ADD COLUMN session_id CHAR(32) NOT NULL DEFAULT '';
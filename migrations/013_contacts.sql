@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS contact_requests (
+    id           BIGINT AUTO_INCREMENT PRIMARY KEY,
+    sender_id    INT         NOT NULL,
+    receiver_id  INT         NOT NULL,
+    status       VARCHAR(16) NOT NULL,
+    created_at   DATETIME(3) NOT NULL,
+    responded_at DATETIME(3) NULL,
+    UNIQUE KEY uq_contact_requests_pair (sender_id, receiver_id),
+    INDEX idx_contact_requests_receiver (receiver_id, status)
+);
+
+-- Each contact is stored in both directions.
+CREATE TABLE IF NOT EXISTS contacts (
+    user_id    INT         NOT NULL,
+    contact_id INT         NOT NULL,
+    created_at DATETIME(3) NOT NULL,
+    PRIMARY KEY (user_id, contact_id)
+);
+
+CREATE TABLE IF NOT EXISTS contact_settings (
+    user_id       INT         PRIMARY KEY,
+    messages_from VARCHAR(16) NOT NULL
+);
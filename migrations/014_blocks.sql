@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_blocks (
+    user_id    INT         NOT NULL,
+    blocked_id INT         NOT NULL,
+    created_at DATETIME(3) NOT NULL,
+    PRIMARY KEY (user_id, blocked_id),
+    INDEX idx_user_blocks_blocked (blocked_id)
+);
+
+-- Messages to a receiver who blocked the sender are stored like any other,
+-- so the sender sees them in their history, but hidden from the receiver.
+ALTER TABLE messages
+    ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,11 @@
+-- Each user's preferences for a one-to-one conversation. Missing rows mean
+-- the defaults: not muted, notification level "all", no nickname.
+CREATE TABLE IF NOT EXISTS conversation_settings (
+    user_id            INT         NOT NULL,
+    partner_id         INT         NOT NULL,
+    muted_until        DATETIME(3) NULL,
+    notification_level VARCHAR(16) NOT NULL,
+    nickname           VARCHAR(64) NOT NULL DEFAULT '',
+    updated_at         DATETIME(3) NOT NULL,
+    PRIMARY KEY (user_id, partner_id)
+);
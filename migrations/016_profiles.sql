@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_profiles (
+    user_id      INT          PRIMARY KEY,
+    display_name VARCHAR(64)  NOT NULL DEFAULT '',
+    avatar_url   VARCHAR(512) NOT NULL DEFAULT '',
+    bio          VARCHAR(280) NOT NULL DEFAULT '',
+    timezone     VARCHAR(64)  NOT NULL DEFAULT '',
+    updated_at   DATETIME(3)  NOT NULL,
+    INDEX idx_user_profiles_display_name (display_name)
+);
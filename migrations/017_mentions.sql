@@ -0,0 +1,10 @@
+ALTER TABLE messages
+    ADD COLUMN entities JSON NULL;
+
+-- One row per user mentioned in a message, for listing a user's mentions.
+CREATE TABLE IF NOT EXISTS message_mentions (
+    message_id BIGINT NOT NULL,
+    user_id    INT    NOT NULL,
+    PRIMARY KEY (message_id, user_id),
+    INDEX idx_message_mentions_user (user_id, message_id)
+);
@@ -0,0 +1,214 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP (Ed25519)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at a JWKS endpoint.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ToJWK encodes the key's public half.
+func (k *Key) ToJWK() (JWK, error) {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type %T", k.Public)
+	}
+	return jwk, nil
+}
+
+// Key decodes the JWK into a verification-only key.
+func (j JWK) Key() (*Key, error) {
+	switch j.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return NewPublicKey(j.KeyID, public)
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return NewPublicKey(j.KeyID, ed25519.PublicKey(x))
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.KeyType)
+	}
+}
+
+// minJWKSRefresh rate-limits refetches triggered by unknown key IDs.
+const minJWKSRefresh = time.Minute
+
+// JWKSSource holds the keys of an external issuer, loaded from a file or an
+// http(s) URL. The set is reloaded every refresh interval, and sooner when a
+// token names a kid it does not know, so the issuer can rotate keys. Tokens
+// verified with its keys must name the source's issuer and audience.
+type JWKSSource struct {
+	location string
+	issuer   string
+	audience string
+	refresh  time.Duration
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*Key
+	fetchedAt time.Time
+	// loading is set while one caller refreshes the set; the others keep
+	// using the current keys instead of waiting for the fetch.
+	loading bool
+}
+
+func NewJWKSSource(location, issuer, audience string, refresh time.Duration) (*JWKSSource, error) {
+	if issuer == "" || audience == "" {
+		return nil, errors.New("external JWKS need an issuer and an audience")
+	}
+	source := &JWKSSource{
+		location: location,
+		issuer:   issuer,
+		audience: audience,
+		refresh:  refresh,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	keys, err := source.fetch()
+	if err != nil {
+		return nil, err
+	}
+	source.keys = keys
+	source.fetchedAt = time.Now()
+	return source, nil
+}
+
+// Issuer is the iss claim the source's tokens must carry.
+func (s *JWKSSource) Issuer() string {
+	return s.issuer
+}
+
+// Audience is the value the source's tokens must list in their aud claim.
+func (s *JWKSSource) Audience() string {
+	return s.audience
+}
+
+// Key returns the key with the given kid, reloading the set if it is stale
+// or does not contain the kid. The lock is not held while fetching, so a
+// slow issuer does not hold up tokens signed with known keys.
+func (s *JWKSSource) Key(kid string) (*Key, bool) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetchedAt)
+	stale := age >= s.refresh || (!ok && age >= minJWKSRefresh)
+	if !stale || s.loading {
+		s.mu.Unlock()
+		return key, ok
+	}
+	s.loading = true
+	s.mu.Unlock()
+
+	keys, err := s.fetch()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loading = false
+	if err != nil {
+		// Keep serving the last good set.
+		return key, ok
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	key, ok = s.keys[kid]
+	return key, ok
+}
+
+// fetch reads and decodes the set without touching the source's state.
+func (s *JWKSSource) fetch() (map[string]*Key, error) {
+	data, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var set JWKSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS from %s: %w", s.location, err)
+	}
+
+	keys := make(map[string]*Key, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.Key()
+		if err != nil {
+			// Skip keys we cannot use rather than rejecting the whole set.
+			continue
+		}
+		keys[key.ID] = key
+	}
+	return keys, nil
+}
+
+func (s *JWKSSource) read() ([]byte, error) {
+	if !strings.HasPrefix(s.location, "http://") && !strings.HasPrefix(s.location, "https://") {
+		return os.ReadFile(s.location)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS from %s: %s", s.location, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
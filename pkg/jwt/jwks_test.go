@@ -0,0 +1,88 @@
+package jwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKSSourceRequiresIssuerAndAudience(t *testing.T) {
+	path := writeJWKS(t, newSigningKey(t, "k1"))
+	if _, err := NewJWKSSource(path, "", "chatapp", time.Hour); err == nil {
+		t.Error("source without an issuer was accepted")
+	}
+	if _, err := NewJWKSSource(path, "https://idp.example", "", time.Hour); err == nil {
+		t.Error("source without an audience was accepted")
+	}
+}
+
+func TestJWKSSourceReloadsStaleKeys(t *testing.T) {
+	old, rotated := newSigningKey(t, "old"), newSigningKey(t, "new")
+	var served atomic.Value
+	served.Store(encodeJWKS(t, old))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(served.Load().([]byte))
+	}))
+	defer server.Close()
+
+	source, err := NewJWKSSource(server.URL, "https://idp.example", "chatapp", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := source.Key("old"); !ok {
+		t.Fatal("initial key not loaded")
+	}
+
+	served.Store(encodeJWKS(t, rotated))
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := source.Key("new"); !ok {
+		t.Fatal("rotated key not loaded")
+	}
+	if _, ok := source.Key("old"); ok {
+		t.Fatal("retired key still served")
+	}
+}
+
+func TestJWKSSourceServesKnownKeysDuringRefresh(t *testing.T) {
+	key := newSigningKey(t, "k1")
+	set := encodeJWKS(t, key)
+	var requests atomic.Int32
+	refreshing := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			close(refreshing)
+			<-release
+		}
+		w.Write(set)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	source, err := NewJWKSSource(server.URL, "https://idp.example", "chatapp", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The first caller after the refresh interval fetches the set and hangs
+	// on the slow issuer.
+	go source.Key("k1")
+	<-refreshing
+
+	found := make(chan bool)
+	go func() {
+		_, ok := source.Key("k1")
+		found <- ok
+	}()
+	select {
+	case ok := <-found:
+		if !ok {
+			t.Fatal("known key not served during the refresh")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Key blocked on the refresh")
+	}
+}
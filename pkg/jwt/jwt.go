@@ -1,50 +1,222 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type Service interface {
-	GenerateToken(userID int) (string, error)
+	// GenerateToken issues an access token. A non-empty sessionID is carried
+	// in the sid claim so the session's tokens can be revoked together.
+	GenerateToken(userID int, sessionID string) (string, error)
 	ValidateToken(tokenString string) (jwt.MapClaims, error)
+	TTL() time.Duration
+	JWKS() JWKSet
+}
+
+// Config selects how tokens are signed and which tokens are accepted.
+type Config struct {
+	// Secret signs and verifies HS256 tokens. It is only used when no
+	// signing keys are configured.
+	Secret string
+	// Keys are this server's asymmetric keys. The one whose ID is
+	// ActiveKeyID signs new tokens; all of them verify, so a key can be
+	// rotated out while its tokens are still valid.
+	Keys        []*Key
+	ActiveKeyID string
+	// External sources verify tokens issued by other identity providers.
+	External []*JWKSSource
+	TTL      time.Duration
 }
 
 type jwtService struct {
-	secret string
+	secret   []byte
+	signing  *Key
+	keys     map[string]*Key
+	external []*JWKSSource
+	methods  []string
+	ttl      time.Duration
 }
 
-func NewJWTService(secret string) Service {
-	return &jwtService{secret: secret}
+// NewJWTService issues tokens that expire after cfg.TTL. Each token carries a
+// random jti so it can be revoked individually, and asymmetric tokens carry
+// the kid of the key that signed them.
+func NewJWTService(cfg Config) (Service, error) {
+	s := &jwtService{
+		keys:     make(map[string]*Key, len(cfg.Keys)),
+		external: cfg.External,
+		ttl:      cfg.TTL,
+	}
+
+	for _, key := range cfg.Keys {
+		s.keys[key.ID] = key
+		s.addMethod(key.Method.Alg())
+	}
+	if len(cfg.Keys) > 0 {
+		active, ok := s.keys[cfg.ActiveKeyID]
+		if !ok || active.Private == nil {
+			return nil, fmt.Errorf("active key %q not found or has no private key", cfg.ActiveKeyID)
+		}
+		s.signing = active
+	} else {
+		if cfg.Secret == "" {
+			return nil, errors.New("either a secret or signing keys are required")
+		}
+		s.secret = []byte(cfg.Secret)
+		s.addMethod(jwt.SigningMethodHS256.Alg())
+	}
+	if len(cfg.External) > 0 {
+		s.addMethod(jwt.SigningMethodRS256.Alg())
+		s.addMethod(jwt.SigningMethodEdDSA.Alg())
+	}
+
+	return s, nil
+}
+
+func (s *jwtService) addMethod(alg string) {
+	for _, method := range s.methods {
+		if method == alg {
+			return
+		}
+	}
+	s.methods = append(s.methods, alg)
 }
 
-func (s *jwtService) GenerateToken(userID int) (string, error) {
+func (s *jwtService) GenerateToken(userID int, sessionID string) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"id":  userID,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
-		"iat": time.Now().Unix(),
+		"jti": jti,
+		"exp": now.Add(s.ttl).Unix(),
+		"iat": now.Unix(),
+	}
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
+
+	if s.signing == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString(s.secret)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.secret))
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.Private)
 }
 
+// ValidateToken verifies the token and returns its claims. Every token must
+// carry an expiry. Tokens signed by an external source must also carry the
+// source's issuer and audience; this server's tokens have no iss, so a
+// non-empty iss in the result marks an external token.
 func (s *jwtService) ValidateToken(tokenString string) (jwt.MapClaims, error) {
+	var source *JWKSSource
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return []byte(s.secret), nil
-	})
-
+		key, from, err := s.keyFunc(token)
+		source = from
+		return key, err
+	}, jwt.WithValidMethods(s.methods), jwt.WithExpirationRequired())
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrInvalidKey
+	}
+	if source == nil {
+		if _, ok := claims["iss"]; ok {
+			return nil, jwt.ErrTokenInvalidIssuer
+		}
 		return claims, nil
 	}
+	if err := checkSource(claims, source); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkSource requires an external token to name its source's issuer and
+// audience, so a key shared by several issuers, or a token meant for another
+// service, is not accepted here.
+func checkSource(claims jwt.MapClaims, source *JWKSSource) error {
+	issuer, err := claims.GetIssuer()
+	if err != nil || issuer != source.Issuer() {
+		return jwt.ErrTokenInvalidIssuer
+	}
+	audience, err := claims.GetAudience()
+	if err != nil {
+		return jwt.ErrTokenInvalidAudience
+	}
+	for _, aud := range audience {
+		if aud == source.Audience() {
+			return nil
+		}
+	}
+	return jwt.ErrTokenInvalidAudience
+}
+
+// keyFunc picks the verification key by kid, looking at this server's keys
+// before the external issuers'. It also returns the external source the key
+// came from, if any. Tokens without a kid are only accepted in HMAC mode.
+func (s *jwtService) keyFunc(token *jwt.Token) (interface{}, *JWKSSource, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if s.secret == nil {
+			return nil, nil, jwt.ErrSignatureInvalid
+		}
+		return s.secret, nil, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, nil, errors.New("token has no kid")
+	}
 
-	return nil, jwt.ErrInvalidKey
+	var source *JWKSSource
+	key, ok := s.keys[kid]
+	for i := 0; !ok && i < len(s.external); i++ {
+		key, ok = s.external[i].Key(kid)
+		source = s.external[i]
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if key.Method.Alg() != token.Method.Alg() {
+		return nil, nil, jwt.ErrSignatureInvalid
+	}
+	return key.Public, source, nil
+}
+
+func (s *jwtService) TTL() time.Duration {
+	return s.ttl
+}
+
+// JWKS returns the public halves of this server's keys.
+func (s *jwtService) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range s.keys {
+		jwk, err := key.ToJWK()
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
 }
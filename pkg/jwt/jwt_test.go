@@ -0,0 +1,133 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newSigningKey returns an Ed25519 key able to sign.
+func newSigningKey(t *testing.T, id string) *Key {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewKey(id, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeJWKS publishes the keys' public halves in a file and returns its path.
+func writeJWKS(t *testing.T, keys ...*Key) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, encodeJWKS(t, keys...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func encodeJWKS(t *testing.T, keys ...*Key) []byte {
+	t.Helper()
+	set := JWKSet{}
+	for _, key := range keys {
+		jwk, err := key.ToJWK()
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func sign(t *testing.T, key *Key, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestValidateTokenChecksExternalIssuerAndAudience(t *testing.T) {
+	key := newSigningKey(t, "idp-1")
+	source, err := NewJWKSSource(writeJWKS(t, key), "https://idp.example", "chatapp", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewJWTService(Config{Secret: "secret", TTL: time.Minute, External: []*JWKSSource{source}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Minute).Unix()
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		valid  bool
+	}{
+		{"valid", jwt.MapClaims{"iss": "https://idp.example", "aud": "chatapp", "sub": "a", "exp": exp}, true},
+		{"audience list", jwt.MapClaims{"iss": "https://idp.example", "aud": []string{"other", "chatapp"}, "sub": "a", "exp": exp}, true},
+		{"other issuer", jwt.MapClaims{"iss": "https://evil.example", "aud": "chatapp", "sub": "a", "exp": exp}, false},
+		{"no issuer", jwt.MapClaims{"aud": "chatapp", "id": 1, "exp": exp}, false},
+		{"other audience", jwt.MapClaims{"iss": "https://idp.example", "aud": "other", "sub": "a", "exp": exp}, false},
+		{"no audience", jwt.MapClaims{"iss": "https://idp.example", "sub": "a", "exp": exp}, false},
+		{"no expiry", jwt.MapClaims{"iss": "https://idp.example", "aud": "chatapp", "sub": "a"}, false},
+		{"expired", jwt.MapClaims{"iss": "https://idp.example", "aud": "chatapp", "sub": "a", "exp": time.Now().Add(-time.Minute).Unix()}, false},
+	}
+	for _, c := range cases {
+		claims, err := service.ValidateToken(sign(t, key, c.claims))
+		if c.valid && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: accepted claims %v", c.name, claims)
+		}
+	}
+}
+
+func TestValidateTokenRequiresExpiryOnOwnTokens(t *testing.T) {
+	service, err := NewJWTService(Config{Secret: "secret", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := service.GenerateToken(1, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := service.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["id"] != float64(1) || claims["sid"] != "session" {
+		t.Fatalf("claims = %v", claims)
+	}
+
+	for name, claims := range map[string]jwt.MapClaims{
+		"no expiry":  {"id": 1},
+		"own issuer": {"id": 1, "iss": "https://idp.example", "exp": time.Now().Add(time.Minute).Unix()},
+	} {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := service.ValidateToken(signed); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}